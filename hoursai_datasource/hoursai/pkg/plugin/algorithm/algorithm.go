@@ -2,6 +2,7 @@ package algorithm
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/client"
 	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
@@ -12,18 +13,21 @@ import (
 	"golang.org/x/net/context"
 	"io"
 	"net/http"
+	"sort"
 	"strconv"
 	"time"
 )
 
+// Point 序列中的一个点，缺失值策略为mark时Value为null
 type Point struct {
-	Timestamp int64   `json:"timestamp"`
-	Value     float64 `json:"value"`
+	Timestamp int64    `json:"timestamp"`
+	Value     *float64 `json:"value"`
 }
 
 type Series []Point
 
 type SyncPreviewQuery struct {
+	Scene    string `json:"scene,omitempty"`
 	Series   Series `json:"series"`
 	Name     string `json:"name"`
 	Version  string `json:"version"`
@@ -32,13 +36,32 @@ type SyncPreviewQuery struct {
 	MetaInfo string `json:"metaInfo"`
 }
 
+// PrometheusSeriesTimeRange 标签、序列和元数据资源接口的请求参数，from和to为秒级时间戳
 type PrometheusSeriesTimeRange struct {
-	From  int64  `json:"from"`
-	To    int64  `json:"to"`
-	Match string `json:"match[]"`
+	From    int64    `json:"from"`
+	To      int64    `json:"to"`
+	Match   string   `json:"match[]"`
+	Matches []string `json:"matches"`
+	Label   string   `json:"label"`
+	Metric  string   `json:"metric"`
+	Limit   int64    `json:"limit"`
+}
+
+// metadataRange 合并match[]和matches，请求未指定limit时使用数据源配置的上限
+func (tr PrometheusSeriesTimeRange) metadataRange(defaultLimit int64) client.MetadataRange {
+	m := client.MetadataRange{Start: tr.From, End: tr.To, Limit: tr.Limit}
+	if tr.Match != "" {
+		m.Matches = append(m.Matches, tr.Match)
+	}
+	m.Matches = append(m.Matches, tr.Matches...)
+	if m.Limit <= 0 {
+		m.Limit = defaultLimit
+	}
+	return m
 }
 
 type RealtimeInitRequest struct {
+	Scene    string `json:"scene,omitempty"`
 	Name     string `json:"name"`
 	Version  string `json:"version"`
 	Params   string `json:"params"`
@@ -64,9 +87,15 @@ type RealtimeResultRequest struct {
 	Interval  int64  `json:"interval"`
 }
 
-func newRealtimeResultRequest(response *backend.DataResponse, q *models.Query) []RealtimeResultRequest {
+// newRealtimeResultRequest 每条时序生成一个请求，frames为每个请求对应的frame下标
+func newRealtimeResultRequest(response *backend.DataResponse, q *models.Query) ([]RealtimeResultRequest, []int) {
 	result := make([]RealtimeResultRequest, 0)
-	for _, frame := range response.Frames {
+	var frames []int
+	for i, frame := range response.Frames {
+		if !isTimeSeries(frame) {
+			continue
+		}
+		frames = append(frames, i)
 		var r RealtimeResultRequest
 		_, labelString, algorithm := getSeriesFromResponse(frame, q)
 		taskId, metaInfo := getTaskIdFromTaskInfo(q.TaskInfo, labelString, algorithm, q)
@@ -99,15 +128,23 @@ func newRealtimeResultRequest(response *backend.DataResponse, q *models.Query) [
 		}
 		result = append(result, r)
 	}
-	return result
+	return result, frames
 }
 
-func newRealtimeRunRequest(response *backend.DataResponse, q *models.Query) ([]RealtimeRunRequest, []map[string]string) {
+// newRealtimeRunRequest 每条时序生成一个请求，frames为每个请求对应的frame下标
+func newRealtimeRunRequest(response *backend.DataResponse, q *models.Query) ([]RealtimeRunRequest,
+	[]map[string]string, []int) {
 	result := make([]RealtimeRunRequest, 0)
 	metaInfos := make([]map[string]string, 0)
-	for _, frame := range response.Frames {
+	var frames []int
+	for i, frame := range response.Frames {
+		if !isTimeSeries(frame) {
+			continue
+		}
+		frames = append(frames, i)
 		s, labelString, algorithm := getSeriesFromResponse(frame, q)
 		taskId, metaInfo := getTaskIdFromTaskInfo(q.TaskInfo, labelString, algorithm, q)
+		metaInfo = withTimezone(metaInfo, q)
 		metaInfoByte, err := json.Marshal(metaInfo)
 		if err != nil {
 			log.DefaultLogger.Error("Create sync preview request error,", err)
@@ -119,7 +156,20 @@ func newRealtimeRunRequest(response *backend.DataResponse, q *models.Query) ([]R
 			MetaInfo: string(metaInfoByte),
 		})
 	}
-	return result, metaInfos
+	return result, metaInfos, frames
+}
+
+// withTimezone 复制metaInfo并加入查询的时区和日历，算法按当地时间计算日、周季节性；任务创建时已记录的时区保持不变
+func withTimezone(metaInfo map[string]string, q *models.Query) map[string]string {
+	result := make(map[string]string, len(metaInfo)+1)
+	for k, v := range metaInfo {
+		result[k] = v
+	}
+	if result["timezone"] == "" {
+		result["timezone"] = q.TimezoneName()
+	}
+	addCalendar(result, q)
+	return result
 }
 
 // getTaskIdFromTaskInfo 从taskInfo中根据序列信息获取taskId
@@ -157,12 +207,17 @@ func getSeriesFromResponse(frame *data.Frame, q *models.Query) (Series, string,
 		algorithm   map[string]string
 	)
 
-	// 获取series
+	// 获取series，预处理后的序列中只有标记为缺失的点为null
 	for i := 0; i <= frame.Fields[0].Len()-1; i++ {
-		s = append(s, Point{
-			Timestamp: frame.Fields[0].At(i).(time.Time).Unix() * 1000,
-			Value:     frame.Fields[1].At(i).(float64),
-		})
+		ts, ok := timeAt(frame.Fields[0], i)
+		if !ok {
+			continue
+		}
+		p := Point{Timestamp: ts.Unix() * 1000}
+		if v, ok := valueAt(frame.Fields[1], i); ok {
+			p.Value = &v
+		}
+		s = append(s, p)
 	}
 
 	// 获取labels json字符串
@@ -182,12 +237,19 @@ func getSeriesFromResponse(frame *data.Frame, q *models.Query) (Series, string,
 	return s, labelString, algorithm
 }
 
-func newSyncPreviewRequest(response *backend.DataResponse, q *models.Query) ([]SyncPreviewQuery, []map[string]string) {
+// newSyncPreviewRequest 每条时序生成一个请求，frames为每个请求对应的frame下标
+func newSyncPreviewRequest(response *backend.DataResponse, q *models.Query) ([]SyncPreviewQuery,
+	[]map[string]string, []int) {
 	var (
 		querys    []SyncPreviewQuery
 		metaInfos = make([]map[string]string, 0)
+		frames    []int
 	)
-	for _, frame := range response.Frames {
+	for i, frame := range response.Frames {
+		if !isTimeSeries(frame) {
+			continue
+		}
+		frames = append(frames, i)
 		s, labelString, algorithm := getSeriesFromResponse(frame, q)
 		var (
 			metaInfoByte []byte
@@ -197,13 +259,16 @@ func newSyncPreviewRequest(response *backend.DataResponse, q *models.Query) ([]S
 				//"legend": q.LegendFormat,
 				"labels":   labelString,
 				"interval": strconv.FormatInt(int64(q.Step), 10),
+				"timezone": q.TimezoneName(),
 			}
 		)
+		addCalendar(metaInfo, q)
 		if metaInfoByte, err = json.Marshal(metaInfo); err != nil {
 			log.DefaultLogger.Error("Create sync preview request error,", err)
 		}
 
 		q := SyncPreviewQuery{
+			Scene:    q.Scene,
 			Name:     algorithm["name"],
 			Version:  algorithm["version"],
 			Params:   algorithm["params"],
@@ -214,89 +279,146 @@ func newSyncPreviewRequest(response *backend.DataResponse, q *models.Query) ([]S
 		metaInfos = append(metaInfos, metaInfo)
 		querys = append(querys, q)
 	}
-	return querys, metaInfos
+	return querys, metaInfos, frames
 }
 
-// CallAlgorithm 调用相关算法接
-func CallAlgorithm(ctx context.Context, r *backend.DataResponse, q *models.Query) (*backend.DataResponse, error) {
-	response := &backend.DataResponse{}
+// managerSettings 从数据源JsonData中解析manager配置，并生成调用manager所需的header
+func managerSettings(q *models.Query) (map[string]string, http.Header, error) {
 	log.DefaultLogger.Info("Datasource json data is: ", q.JsonData)
-	// 从JsonData中过滤出需要的字段
-	jsonMap := make(map[string]string)
-	if err := json.Unmarshal(q.JsonData, &jsonMap); err != nil {
+	jsonMap, err := util.JsonDataToStringMap(q.JsonData)
+	if err != nil {
 		log.DefaultLogger.Error("Query json data to map error, error is: ", err)
-		return response, err
+		return nil, nil, err
 	}
 	header := http.Header{
 		"Authorization": []string{jsonMap["token"]},
 		"sourceType":    []string{util.ProjectType},
 	}
+	return jsonMap, header, nil
+}
+
+// CallAlgorithm 调用相关算法接
+func CallAlgorithm(ctx context.Context, r *backend.DataResponse, q *models.Query,
+	policy *client.ManagerPolicy) (*backend.DataResponse, error) {
+	response := &backend.DataResponse{}
+	jsonMap, header, err := managerSettings(q)
+	if err != nil {
+		return response, err
+	}
+
+	// 日志类场景的输入不是时序数据，无法由promql查询结果驱动
+	if q.Scene == util.SceneLogClustering {
+		return response, util.PluginError(backend.StatusBadRequest,
+			fmt.Errorf("scene %s is not supported for metric queries", q.Scene))
+	}
+
+	// 发送给任何算法前先处理缺失值并对齐step，预处理后的序列只用于算法请求，返回的原始序列保持查询结果的点，只在meta中记录预处理信息
+	prepared, err := Preprocess(r, q)
+	if err != nil {
+		return response, err
+	}
+
+	rules, err := getAnomalyRules(q, q.Engine)
+	if err != nil {
+		return response, err
+	}
+	if q.Engine == util.EngineBuiltin {
+		return withPreprocessMeta(detectBuiltin(r, prepared, q, rules), prepared), nil
+	}
 
 	var (
-		body      []byte
-		err       error
+		items     []interface{}
+		points    []int
 		metaInfos []map[string]string
+		frames    []int
+		sampled   *backend.DataResponse
+		ds        *downsampling
 	)
 	c := client.NewClient(&http.Client{Timeout: 60 * time.Second}, "", "")
+	c.SetPolicy(policy)
 	switch q.QueryType {
 	case util.SyncPreviewType:
 		c.SetUrl(jsonMap["managerUrl"] + util.SyncPreviewPath)
 		c.SetMethod(http.MethodPost)
+		c.SetRetry(getRetryOptions(q))
+		c.SetCoalesce(true)
+		// 长序列按点数上限降采样后再发送，结果还原到原始序列的时间点上
+		sampled, ds, err = Downsample(prepared, q)
+		if err != nil {
+			return response, err
+		}
 		var syncPreviewRequest []SyncPreviewQuery
-		syncPreviewRequest, metaInfos = newSyncPreviewRequest(r, q)
-		body, err = json.Marshal(syncPreviewRequest)
+		syncPreviewRequest, metaInfos, frames = newSyncPreviewRequest(sampled, q)
+		for i, item := range syncPreviewRequest {
+			if ds != nil && ds.intervals[frames[i]] > 0 {
+				item.Interval = int64(ds.intervals[frames[i]] / time.Second)
+			}
+			items = append(items, item)
+			points = append(points, len(item.Series))
+		}
 	case util.RealtimeRunType:
 		c.SetUrl(jsonMap["managerUrl"] + util.RealtimeRunPath)
 		c.SetMethod(http.MethodPost)
 		var realtimeRunRequest []RealtimeRunRequest
-		realtimeRunRequest, metaInfos = newRealtimeRunRequest(r, q)
-		body, err = json.Marshal(realtimeRunRequest)
+		realtimeRunRequest, metaInfos, frames = newRealtimeRunRequest(prepared, q)
+		for _, item := range realtimeRunRequest {
+			items = append(items, item)
+			points = append(points, len(item.Series))
+		}
 	case util.RealtimeResultType:
 		c.SetUrl(jsonMap["managerUrl"] + util.RealtimeResultPath)
 		c.SetMethod(http.MethodPost)
-		realtimeResultRequest := newRealtimeResultRequest(r, q)
-		body, err = json.Marshal(realtimeResultRequest)
-	}
-
-	if err != nil {
-		log.DefaultLogger.Error("Request to json error, error is: ", err)
-		return response, err
+		c.SetRetry(getRetryOptions(q))
+		c.SetCoalesce(true)
+		var realtimeResultRequest []RealtimeResultRequest
+		realtimeResultRequest, frames = newRealtimeResultRequest(prepared, q)
+		for _, item := range realtimeResultRequest {
+			items = append(items, item)
+			points = append(points, 0)
+		}
 	}
 
-	resp, err := c.CallAlgorithm(ctx, body, header)
-	if err != nil {
-		log.DefaultLogger.Error("Http request to call algorithm error, error is: ", err)
-		return response, err
+	// 按序列数和点数分批并发调用，避免大请求超时
+	response, err = callBatches(ctx, c, header, r, items, points, frames, metaInfos, q, rules)
+	// 熔断期间预览查询可以退回到内置引擎
+	if errors.Is(err, client.ErrCircuitOpen) && q.QueryType == util.SyncPreviewType &&
+		jsonMap["fallbackEngine"] == util.EngineBuiltin {
+		log.DefaultLogger.Info("Manager circuit is open, fall back to builtin engine", "refId", q.RefId)
+		// 内置引擎的显著性与manager不同，使用内置引擎的严重级别阈值
+		if rules.Severity, err = getSeverityTiers(q, util.EngineBuiltin); err != nil {
+			return response, err
+		}
+		response = detectBuiltin(r, prepared, q, rules)
+		if len(response.Frames) > 0 {
+			response.Frames[0].AppendNotices(data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     "HoursAI manager is unavailable, results are computed by the built-in engine.",
+			})
+		}
+		return withPreprocessMeta(response, prepared), nil
 	}
-	//var result []byte
-	//if result, err = io.ReadAll(resp.Body); err != nil {
-	//	log.DefaultLogger.Error("Metrics http response body to []byte error, error is: ", err)
-	//}
-	//log.DefaultLogger.Info("Call prometheus metadata result is: ", string(result))
-
-	response, err = ParseAlgorithmResponse(resp, r, q.QueryType, metaInfos, q.Series)
-	if err != nil {
-		log.DefaultLogger.Error("Parse algorithm response error, error is: ", err)
-		return response, err
+	if ds != nil {
+		response = expandResponse(response, r, prepared, ds, q)
 	}
-	return response, nil
+	return withPreprocessMeta(response, prepared), err
 }
 
-// CallCore 调用与查询无关的业务接口
+// CallCore 调用与查询无关的业务接口，promHeader为转发给prometheus的认证请求头
 func CallCore(ctx context.Context, body []byte, jsonMap map[string]string, operationType string,
-	promClient *client.Client) ([]byte, error) {
+	promClient *client.Client, promHeader http.Header, policy *client.ManagerPolicy) ([]byte, error) {
 	header := http.Header{
 		"Authorization": []string{jsonMap["token"]},
 		"sourceType":    []string{util.ProjectType},
 	}
 	c := client.NewClient(&http.Client{}, "", "")
+	c.SetPolicy(policy)
 	var err error
 	switch operationType {
 	case util.AlgorithmListType:
 		c.SetUrl(jsonMap["managerUrl"] + util.AlgorithmListPath)
 		c.SetMethod(http.MethodGet)
 	case util.RealtimeInitType:
-		body, err = GenerateRealtimeInitBody(ctx, body, promClient)
+		body, err = GenerateRealtimeInitBody(ctx, body, promClient, promHeader)
 		if err != nil {
 			log.DefaultLogger.Error("Generate task id error, error is: ", err)
 			return []byte(err.Error()), err
@@ -322,7 +444,8 @@ func CallCore(ctx context.Context, body []byte, jsonMap map[string]string, opera
 	return result, nil
 }
 
-func GenerateRealtimeInitBody(ctx context.Context, body []byte, promClient *client.Client) ([]byte, error) {
+func GenerateRealtimeInitBody(ctx context.Context, body []byte, promClient *client.Client,
+	promHeader http.Header) ([]byte, error) {
 	// 将函数体内的变量声明提到最小作用域
 	var (
 		bodyMap       map[string]interface{}
@@ -336,24 +459,36 @@ func GenerateRealtimeInitBody(ctx context.Context, body []byte, promClient *clie
 		err           error
 		ok            bool
 		metaInfoByte  []byte
+		expr          string
+		timezone      string
 	)
 	// 处理json数据
 	if err = json.Unmarshal(body, &bodyMap); err != nil {
 		log.DefaultLogger.Error("Generate task id body to map error, error is: ", err)
 		return []byte(err.Error()), err
 	}
+	// 即席过滤条件同样作用于序列查询，实时任务的promql与面板查询保持一致
+	if expr, err = adHocExpr(body, bodyMap["expr"].(string)); err != nil {
+		log.DefaultLogger.Error("Apply ad-hoc filters error, error is: ", err)
+		return []byte(err.Error()), err
+	}
+	// 实时任务按面板的时区计算季节性
+	timezone, _ = bodyMap["timezone"].(string)
+	utcOffsetSec, _ := bodyMap["utcOffsetSec"].(float64)
+	timezone = models.LoadLocation(timezone, int64(utcOffsetSec)).String()
 	// 构建时间范围
 	timeRange = PrometheusSeriesTimeRange{
 		From:  int64(bodyMap["start"].(float64)),
 		To:    int64(bodyMap["end"].(float64)),
-		Match: bodyMap["expr"].(string),
+		Match: expr,
 	}
 	if timeRangeByte, err = json.Marshal(timeRange); err != nil {
 		log.DefaultLogger.Error("Generate task id timerange to byte error, error is: ", err)
 		return []byte(err.Error()), err
 	}
 	// 调用prometheus接口获取数据
-	if pResult, err = CallPrometheusMetadata(ctx, timeRangeByte, util.SeriesType, promClient, true); err != nil {
+	// 生成实时任务需要全部序列，不限制结果数量
+	if pResult, err = CallPrometheusMetadata(ctx, timeRangeByte, util.SeriesType, promClient, promHeader, 0); err != nil {
 		log.DefaultLogger.Error("Call Prometheus Metadata error, error is: ", err)
 		return []byte(err.Error()), err
 	}
@@ -374,15 +509,18 @@ func GenerateRealtimeInitBody(ctx context.Context, body []byte, promClient *clie
 			return []byte(err.Error()), err
 		}
 		if metaInfoByte, err = json.Marshal(map[string]string{
-			"promql": bodyMap["expr"].(string),
+			"promql": expr,
 			//"legend": bodyMap["legendFormat"].(string),
 			//"interval": strconv.FormatFloat(bodyMap["interval"].(float64), 'f', -1, 64),
-			"labels": string(seriesByte),
+			"labels":   string(seriesByte),
+			"timezone": timezone,
 		}); err != nil {
 			log.DefaultLogger.Error("Generate task id meta info to byte error, error is: ", err)
 			return []byte(err.Error()), err
 		}
+		scene, _ := bodyMap["scene"].(string)
 		result = append(result, RealtimeInitRequest{
+			Scene:    scene,
 			Name:     bodyMap["name"].(string),
 			Params:   bodyMap["params"].(string),
 			Version:  bodyMap["version"].(string),
@@ -397,9 +535,31 @@ func GenerateRealtimeInitBody(ctx context.Context, body []byte, promClient *clie
 	return resultByte, nil
 }
 
-// CallPrometheusMetadata 查询prometheus相关metadata
+// adHocExpr 将请求中的即席过滤条件加入表达式的所有选择器，表达式含有未替换的变量等无法解析时保持原样，
+// 由prometheus返回语法错误
+func adHocExpr(body []byte, expr string) (string, error) {
+	var req struct {
+		AdHocFilters []models.AdHocFilter `json:"adhocFilters"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return "", err
+	}
+	matchers, err := models.AdHocMatchers(req.AdHocFilters)
+	if err != nil {
+		return "", util.PluginError(backend.StatusBadRequest, err)
+	}
+	filtered, err := models.ApplyAdHocFilters(expr, matchers)
+	if err != nil {
+		log.DefaultLogger.Warn("Expression cannot be parsed, ad-hoc filters are not applied", "expr", expr, "err", err)
+		return expr, nil
+	}
+	return filtered, nil
+}
+
+// CallPrometheusMetadata 查询prometheus的指标名、标签、序列和指标元数据，按请求的时间范围和选择器过滤，
+// 结果数量超过limit时截断。header为资源请求中需要转发给prometheus的认证请求头
 func CallPrometheusMetadata(ctx context.Context, body []byte, operationType string,
-	promClient *client.Client, needTime bool) ([]byte, error) {
+	promClient *client.Client, header http.Header, defaultLimit int64) ([]byte, error) {
 	var (
 		result []byte
 		resp   *http.Response
@@ -408,28 +568,116 @@ func CallPrometheusMetadata(ctx context.Context, body []byte, operationType stri
 	)
 	if err := json.Unmarshal(body, &tr); err != nil {
 		log.DefaultLogger.Error("Metric query body to struct error, error is: ", err)
-		return []byte(err.Error()), err
+		return []byte(err.Error()), util.PluginError(backend.StatusBadRequest, err)
 	}
 
+	m := tr.metadataRange(defaultLimit)
 	switch operationType {
 	case util.MetricsType:
-		resp, err = promClient.QueryMetrics(ctx, tr.From, tr.To, nil)
+		resp, err = promClient.QueryMetrics(ctx, m, header)
 	case util.LabelNamesType:
-		resp, err = promClient.QueryLabelNames(ctx, tr.From, tr.To, nil)
+		resp, err = promClient.QueryLabelNames(ctx, m, header)
+	case util.LabelValuesType:
+		if !labelNameRegexp.MatchString(tr.Label) {
+			err = util.PluginError(backend.StatusBadRequest, fmt.Errorf("invalid label name %q", tr.Label))
+			return []byte(err.Error()), err
+		}
+		resp, err = promClient.QueryLabelValues(ctx, tr.Label, m, header)
 	case util.SeriesType:
-		resp, err = promClient.QuerySeries(ctx, tr.From, tr.To, tr.Match, nil, needTime)
+		if len(m.Matches) == 0 {
+			err = util.PluginError(backend.StatusBadRequest, fmt.Errorf("series query requires at least one matcher"))
+			return []byte(err.Error()), err
+		}
+		resp, err = promClient.QuerySeries(ctx, m, header)
+	case util.MetadataType:
+		resp, err = promClient.QueryMetadata(ctx, tr.Metric, m.Limit, header)
 	default:
-		log.DefaultLogger.Info("")
+		err = util.PluginError(backend.StatusNotFound, fmt.Errorf("unsupported prometheus metadata type %s",
+			operationType))
+		return []byte(err.Error()), err
 	}
 	if err != nil {
 		log.DefaultLogger.Error("Http request to call prometheus metadata error, error is: ", err)
 		return []byte(err.Error()), err
 	}
 
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.DefaultLogger.Error("Failed to close response body", "err", err)
+		}
+	}()
 	if result, err = io.ReadAll(resp.Body); err != nil {
 		log.DefaultLogger.Error("Metrics http response body to []byte error, error is: ", err)
 		return []byte(err.Error()), err
 	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		err = util.DownstreamError(util.StatusFromHTTP(resp.StatusCode),
+			fmt.Errorf("prometheus returned HTTP %d: %s", resp.StatusCode, result))
+		log.DefaultLogger.Error("Call prometheus metadata error, error is: ", err)
+		return result, err
+	}
 	log.DefaultLogger.Info("Call prometheus metadata result is: ", string(result))
-	return result, nil
+	return limitResult(result, m.Limit), nil
+}
+
+// limitResult 旧版本prometheus会忽略limit参数，超过上限的结果在插件侧截断并在warnings中说明
+func limitResult(result []byte, limit int64) []byte {
+	if limit <= 0 {
+		return result
+	}
+	var rsp map[string]json.RawMessage
+	if err := json.Unmarshal(result, &rsp); err != nil {
+		return result
+	}
+	var (
+		limited []byte
+		total   int
+		err     error
+		list    []json.RawMessage
+		object  map[string]json.RawMessage
+	)
+	if json.Unmarshal(rsp["data"], &list) == nil {
+		if total = len(list); int64(total) <= limit {
+			return result
+		}
+		limited, err = json.Marshal(list[:limit])
+	} else if json.Unmarshal(rsp["data"], &object) == nil {
+		if total = len(object); int64(total) <= limit {
+			return result
+		}
+		// metadata按指标名排序后截断，保证结果稳定
+		keys := make([]string, 0, len(object))
+		for k := range object {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		kept := make(map[string]json.RawMessage, limit)
+		for _, k := range keys[:limit] {
+			kept[k] = object[k]
+		}
+		limited, err = json.Marshal(kept)
+	} else {
+		return result
+	}
+	if err != nil {
+		log.DefaultLogger.Error("Limit prometheus metadata result error", "err", err)
+		return result
+	}
+
+	var warnings []string
+	if raw, ok := rsp["warnings"]; ok {
+		if err := json.Unmarshal(raw, &warnings); err != nil {
+			log.DefaultLogger.Error("Read prometheus warnings error", "err", err)
+		}
+	}
+	warnings = append(warnings, fmt.Sprintf("results truncated to %d of %d", limit, total))
+	rsp["data"] = limited
+	if rsp["warnings"], err = json.Marshal(warnings); err != nil {
+		return result
+	}
+	out, err := json.Marshal(rsp)
+	if err != nil {
+		return result
+	}
+	return out
 }
@@ -0,0 +1,89 @@
+package algorithm
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestCallAlgorithmKeepsOriginalFrames(t *testing.T) {
+	// 原始序列带有null点和额外的字段，预处理只影响发送给算法的序列
+	frame := seriesFrame(data.Labels{"host": "a"}, 0, fp(1), nil, fp(3), fp(4), fp(5))
+	frame.Fields = append(frame.Fields, data.NewField("instance", nil, []string{"a", "a", "a", "a", "a"}))
+	r := &backend.DataResponse{Frames: data.Frames{frame}}
+	q := &models.Query{
+		QueryType: util.SyncPreviewType,
+		Engine:    util.EngineBuiltin,
+		Start:     time.UnixMilli(0),
+		End:       time.UnixMilli(4000),
+		Step:      time.Second,
+		JsonData:  json.RawMessage(`{}`),
+	}
+
+	response, err := CallAlgorithm(context.Background(), r, q, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Frames) < 2 {
+		t.Fatalf("expected original and result frames, got %d", len(response.Frames))
+	}
+	if response.Frames[0] != frame {
+		t.Fatal("original frame was replaced by the preprocessed frame")
+	}
+	if len(frame.Fields) != 3 || frame.Fields[1].Len() != 5 {
+		t.Errorf("original frame was modified: %d fields, %d points", len(frame.Fields), frame.Fields[1].Len())
+	}
+	if v, ok := frame.Fields[1].ConcreteAt(1); ok {
+		t.Errorf("missing point was filled in the original frame: %v", v)
+	}
+	// 预处理信息写入返回序列的meta
+	want := map[string]string{"missingPolicy": util.MissingDrop, "missingPoints": "1", "alignedStep": "1s"}
+	for _, f := range response.Frames {
+		if f.Meta == nil {
+			t.Fatalf("frame %s has no preprocess meta", f.Name)
+		}
+		custom, _ := f.Meta.Custom.(map[string]string)
+		for k, v := range want {
+			if custom[k] != v {
+				t.Errorf("frame %s meta %s = %q, want %q", f.Name, k, custom[k], v)
+			}
+		}
+	}
+}
+
+func TestAdHocExpr(t *testing.T) {
+	body := []byte(`{"adhocFilters":[{"key":"job","operator":"=","value":"api"}]}`)
+	tests := []struct {
+		name string
+		body []byte
+		expr string
+		want string
+	}{
+		{name: "filters are added", body: body, expr: `rate(http_requests_total[5m])`,
+			want: `rate(http_requests_total{job="api"}[5m])`},
+		{name: "no filters", body: []byte(`{}`), expr: `up`, want: `up`},
+		{name: "uninterpolated variables are kept", body: body, expr: `rate(http_requests_total[$__rate_interval])`,
+			want: `rate(http_requests_total[$__rate_interval])`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := adHocExpr(tt.body, tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := adHocExpr([]byte(`{"adhocFilters":[{"key":"job","operator":"<","value":"api"}]}`), "up"); err == nil {
+		t.Error("expected error for an unsupported operator")
+	}
+}
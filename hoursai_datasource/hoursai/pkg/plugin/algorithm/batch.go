@@ -0,0 +1,240 @@
+package algorithm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/client"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util/converter"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"golang.org/x/net/context"
+)
+
+const (
+	defaultBatchMaxSeries   = 50
+	defaultBatchMaxPoints   = 100000
+	defaultBatchConcurrency = 4
+	defaultRetryMaxAttempts = 3
+)
+
+// BatchOptions 算法请求的分批配置
+type BatchOptions struct {
+	MaxSeries   int
+	MaxPoints   int
+	Concurrency int
+}
+
+// batch 一批请求在原始请求列表中的下标范围[start, end)
+type batch struct {
+	start int
+	end   int
+}
+
+// getBatchOptions 读取数据源配置的分批参数，未配置时使用默认值
+func getBatchOptions(q *models.Query) BatchOptions {
+	opts := BatchOptions{
+		MaxSeries:   defaultBatchMaxSeries,
+		MaxPoints:   defaultBatchMaxPoints,
+		Concurrency: defaultBatchConcurrency,
+	}
+	jsonData := jsonDataMap(q)
+	for key, target := range map[string]*int{
+		"batchMaxSeries":   &opts.MaxSeries,
+		"batchMaxPoints":   &opts.MaxPoints,
+		"batchConcurrency": &opts.Concurrency,
+	} {
+		v, err := util.GetInt64Optional(jsonData, key)
+		if err != nil {
+			log.DefaultLogger.Error("Read batch option error", "key", key, "err", err)
+			continue
+		}
+		if v > 0 {
+			*target = int(v)
+		}
+	}
+	return opts
+}
+
+// getRetryOptions 读取数据源配置的重试次数，未配置时使用默认值
+func getRetryOptions(q *models.Query) client.RetryOptions {
+	opts := client.RetryOptions{MaxAttempts: defaultRetryMaxAttempts}
+	attempts, err := util.GetInt64Optional(jsonDataMap(q), "retryMaxAttempts")
+	if err != nil {
+		log.DefaultLogger.Error("Read retry option error", "err", err)
+	}
+	if attempts > 0 {
+		opts.MaxAttempts = int(attempts)
+	}
+	return opts
+}
+
+func jsonDataMap(q *models.Query) map[string]interface{} {
+	var jsonData map[string]interface{}
+	if err := json.Unmarshal(q.JsonData, &jsonData); err != nil {
+		log.DefaultLogger.Error("Query json data to map error, error is: ", err)
+	}
+	return jsonData
+}
+
+// splitBatches 按序列数和总点数切分请求，单条序列超过点数上限时单独成批
+func splitBatches(points []int, opts BatchOptions) []batch {
+	var (
+		batches []batch
+		current = batch{}
+		total   int
+	)
+	for i, p := range points {
+		full := current.end-current.start >= opts.MaxSeries ||
+			(opts.MaxPoints > 0 && total+p > opts.MaxPoints && current.end > current.start)
+		if full {
+			batches = append(batches, current)
+			current = batch{start: i, end: i}
+			total = 0
+		}
+		current.end = i + 1
+		total += p
+	}
+	if current.end > current.start {
+		batches = append(batches, current)
+	}
+	return batches
+}
+
+// callBatches 并发调用各批请求，按原始顺序合并结果；失败的批次不影响其它批次，对应序列上会附加错误说明。
+// frames为每个请求对应的r中frame的下标
+func callBatches(ctx context.Context, c *client.Client, header http.Header, r *backend.DataResponse,
+	items []interface{}, points []int, frames []int, metaInfos []map[string]string, q *models.Query,
+	rules converter.AnomalyRules) (*backend.DataResponse, error) {
+	opts := getBatchOptions(q)
+	batches := splitBatches(points, opts)
+	log.DefaultLogger.Info("Call algorithm in batches", "series", len(items), "batches", len(batches))
+
+	var (
+		wg        sync.WaitGroup
+		sem       = make(chan struct{}, opts.Concurrency)
+		responses = make([]*backend.DataResponse, len(batches))
+		failures  = make([][]converter.SeriesFailure, len(batches))
+		errs      = make([]error, len(batches))
+	)
+	for i, b := range batches {
+		wg.Add(1)
+		go func(i int, b batch) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			var metas []map[string]string
+			if len(metaInfos) >= b.end {
+				metas = metaInfos[b.start:b.end]
+			}
+			responses[i], failures[i], errs[i] = callBatch(ctx, c, header, items[b.start:b.end], metas, q, rules)
+		}(i, b)
+	}
+	wg.Wait()
+
+	// seriesFrame 请求下标对应的原始序列，没有对应的frame时返回nil
+	seriesFrame := func(item int) *data.Frame {
+		if item < 0 || item >= len(frames) || frames[item] >= len(r.Frames) {
+			return nil
+		}
+		return r.Frames[frames[item]]
+	}
+	var (
+		results       data.Frames
+		failedSeries  int
+		failedBatches int
+		firstErr      error
+	)
+	for i, b := range batches {
+		if errs[i] != nil {
+			log.DefaultLogger.Error("Algorithm batch failed", "start", b.start, "end", b.end, "err", errs[i])
+			failedBatches++
+			failedSeries += b.end - b.start
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			for j := b.start; j < b.end; j++ {
+				if frame := seriesFrame(j); frame != nil {
+					frame.AppendNotices(data.Notice{
+						Severity: data.NoticeSeverityError,
+						Text:     fmt.Sprintf("Algorithm request failed for this series: %s", errs[i]),
+					})
+				}
+			}
+			continue
+		}
+		// 批次成功但部分序列计算失败时，在对应的原始序列上说明原因
+		for _, failure := range failures[i] {
+			log.DefaultLogger.Error("Algorithm failed for series", "index", b.start+failure.Index,
+				"code", failure.Code, "message", failure.Message)
+			failedSeries++
+			if firstErr == nil {
+				firstErr = errors.New(failure.Notice(data.NoticeSeverityError).Text)
+			}
+			if frame := seriesFrame(b.start + failure.Index); frame != nil {
+				frame.AppendNotices(failure.Notice(data.NoticeSeverityError))
+			}
+		}
+		results = append(results, responses[i].Frames...)
+	}
+	if len(batches) > 0 && failedBatches == len(batches) {
+		return &backend.DataResponse{}, firstErr
+	}
+
+	response := assembleResponse(r, results, q)
+	if failedSeries > 0 && len(response.Frames) > 0 {
+		response.Frames[0].AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text: fmt.Sprintf("Algorithm failed for %d of %d series, first error: %s", failedSeries, len(items),
+				firstErr),
+		})
+	}
+	return response, nil
+}
+
+// assembleResponse 合并原始序列与算法结果frame，只输出指定frame时不保留原始序列并沿用其meta
+func assembleResponse(r *backend.DataResponse, frames data.Frames, q *models.Query) *backend.DataResponse {
+	response := &backend.DataResponse{}
+	if q.Series == "" {
+		response.Frames = append(r.Frames, frames...)
+		return response
+	}
+	for _, frame := range frames {
+		if len(r.Frames) > 0 && frame.Meta == nil {
+			frame.Meta = converter.CopyFrameMeta(r.Frames[0].Meta)
+		}
+	}
+	response.Frames = frames
+	return response
+}
+
+func callBatch(ctx context.Context, c *client.Client, header http.Header, items []interface{},
+	metaInfos []map[string]string, q *models.Query, rules converter.AnomalyRules) (*backend.DataResponse,
+	[]converter.SeriesFailure, error) {
+	body, err := json.Marshal(items)
+	if err != nil {
+		log.DefaultLogger.Error("Request to json error, error is: ", err)
+		return nil, nil, err
+	}
+	resp, err := c.CallAlgorithm(ctx, body, header)
+	if err != nil {
+		log.DefaultLogger.Error("Http request to call algorithm error, error is: ", err)
+		return nil, nil, err
+	}
+	rsp, failures, err := ParseAlgorithmResponse(resp, &backend.DataResponse{}, q.QueryType, metaInfos, q.Series,
+		q.Scene, rules)
+	if err != nil {
+		log.DefaultLogger.Error("Parse algorithm response error, error is: ", err)
+		return nil, nil, err
+	}
+	if rsp.Error != nil {
+		return nil, nil, rsp.Error
+	}
+	return rsp, failures, nil
+}
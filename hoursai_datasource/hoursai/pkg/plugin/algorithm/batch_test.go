@@ -0,0 +1,75 @@
+package algorithm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/client"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util/converter"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestCallBatchesNoticesFollowFrameIndex(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status": "success", "data": [
+			{"status": {"code": 0, "status": "success"}, "data": [{"timestamp": 0, "anomaly": 0}]},
+			{"status": {"code": 500, "status": "failed", "message": "model error"}}
+		]}`))
+	}))
+	defer server.Close()
+
+	// 第一个frame不是时序，不生成请求
+	exemplar := data.NewFrame("exemplar", data.NewField("traceID", nil, []string{"abc"}))
+	r := &backend.DataResponse{Frames: data.Frames{
+		exemplar,
+		floatFrame(data.Labels{"pod": "a"}, 1, 2),
+		floatFrame(data.Labels{"pod": "b"}, 3, 4),
+	}}
+	q := &models.Query{Step: time.Minute, JsonData: []byte(`{}`), Scene: "timeseries_anomaly_detection"}
+	items, metaInfos, frames := newSyncPreviewRequest(r, q)
+	if len(items) != 2 || frames[0] != 1 || frames[1] != 2 {
+		t.Fatalf("got %d items for frames %v, want 2 items for frames [1 2]", len(items), frames)
+	}
+	batchItems := make([]interface{}, len(items))
+	points := make([]int, len(items))
+	for i, item := range items {
+		batchItems[i] = item
+		points[i] = len(item.Series)
+	}
+	c := client.NewClient(&http.Client{}, http.MethodPost, server.URL)
+	if _, err := callBatches(context.Background(), c, http.Header{}, r, batchItems, points, frames, metaInfos, q,
+		converter.AnomalyRules{}); err != nil {
+		t.Fatal(err)
+	}
+	// 汇总说明在第一个frame上，单条序列的失败说明只在对应的序列上
+	if exemplar.Meta != nil {
+		for _, n := range exemplar.Meta.Notices {
+			if strings.HasPrefix(n.Text, "Algorithm failed for this series") {
+				t.Errorf("series notice attached to the exemplar frame: %s", n.Text)
+			}
+		}
+	}
+	notices := func(f *data.Frame) string {
+		if f.Meta == nil {
+			return ""
+		}
+		var texts []string
+		for _, n := range f.Meta.Notices {
+			texts = append(texts, n.Text)
+		}
+		return strings.Join(texts, "; ")
+	}
+	if got := notices(r.Frames[2]); !strings.Contains(got, "model error") {
+		t.Errorf("failed series notices = %q, want the model error", got)
+	}
+	if got := notices(r.Frames[1]); strings.Contains(got, "model error") {
+		t.Errorf("successful series got the failure notice: %q", got)
+	}
+}
@@ -0,0 +1,119 @@
+package algorithm
+
+import (
+	"math"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util/converter"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// builtinWindow 内置引擎计算基线使用的历史点数
+	builtinWindow = 30
+	// builtinBandWidth 上下界相对基线的标准差倍数
+	builtinBandWidth = 3.0
+)
+
+// detectBuiltin 内置单变量检测：以前builtinWindow个点的均值为基线，均值加减3倍标准差为上下界
+func detectBuiltin(r *backend.DataResponse, prepared *backend.DataResponse, q *models.Query,
+	rules converter.AnomalyRules) *backend.DataResponse {
+	var frames data.Frames
+	for _, frame := range prepared.Frames {
+		if len(frame.Fields) < 2 || frame.Fields[0].Type().NonNullableType() != data.FieldTypeTime ||
+			!frame.Fields[1].Type().Numeric() {
+			continue
+		}
+		series := builtinFrames(frame, q)
+		// 与manager的结果一致，按规则过滤异常点并分级后再选取series指定的frame
+		byName := map[string]*data.Frame{"value": frame}
+		for _, f := range series {
+			byName[f.Name] = f
+		}
+		rules.ApplyFrames(byName, q.Series != "")
+		for _, f := range series {
+			if q.Series == "" || f.Name == q.Series {
+				frames = append(frames, f)
+			}
+		}
+	}
+	return assembleResponse(r, frames, q)
+}
+
+func builtinFrames(frame *data.Frame, q *models.Query) data.Frames {
+	timeField, valueField := frame.Fields[0], frame.Fields[1]
+	n := timeField.Len()
+	var (
+		times        = make([]time.Time, n)
+		upper        = make([]float64, n)
+		lower        = make([]float64, n)
+		baseline     = make([]float64, n)
+		anomaly      = make([]float64, n)
+		significance = make([]float64, n)
+	)
+	values := make([]float64, n)
+	for i := 0; i < n; i++ {
+		times[i], _ = timeAt(timeField, i)
+		// 缺失点按NaN参与计算，不影响基线
+		v, ok := valueAt(valueField, i)
+		if !ok {
+			v = math.NaN()
+		}
+		values[i] = v
+	}
+	for i := 0; i < n; i++ {
+		start := i - builtinWindow
+		if start < 0 {
+			start = 0
+		}
+		mean, std := meanStd(values[start:i])
+		if i == 0 {
+			mean = values[0]
+		}
+		baseline[i] = mean
+		upper[i] = mean + builtinBandWidth*std
+		lower[i] = mean - builtinBandWidth*std
+		if std > 0 {
+			deviation := math.Abs(values[i]-mean) / (builtinBandWidth * std)
+			significance[i] = math.Min(deviation, 1)
+			if deviation > 1 {
+				anomaly[i] = 1
+			}
+		}
+	}
+
+	labels := valueField.Labels.Copy()
+	interval := float64(q.Step.Milliseconds())
+	newFrame := func(name string, values []float64) *data.Frame {
+		tf := data.NewField(data.TimeSeriesTimeFieldName, nil, times)
+		tf.Config = &data.FieldConfig{Interval: interval}
+		return data.NewFrame(name, tf, data.NewField(data.TimeSeriesValueFieldName, labels, values))
+	}
+	return data.Frames{
+		newFrame("upper", upper),
+		newFrame("lower", lower),
+		newFrame("baseline", baseline),
+		newFrame("anomaly", anomaly),
+		newFrame("significance", significance),
+	}
+}
+
+func meanStd(values []float64) (float64, float64) {
+	var mean, m2 float64
+	var count int
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		count++
+		delta := v - mean
+		mean += delta / float64(count)
+		m2 += delta * (v - mean)
+	}
+	if count < 2 {
+		return mean, 0
+	}
+	return mean, math.Sqrt(m2 / float64(count-1))
+}
@@ -0,0 +1,90 @@
+package algorithm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util/converter"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// addCalendar metaInfo中加入查询范围内的节假日和维护窗口，算法将其作为特殊日期和排除区间
+func addCalendar(metaInfo map[string]string, q *models.Query) {
+	if q.Calendar.Empty() {
+		return
+	}
+	calendar, err := json.Marshal(q.Calendar)
+	if err != nil {
+		log.DefaultLogger.Error("Calendar to json error,", err)
+		return
+	}
+	metaInfo["calendar"] = string(calendar)
+}
+
+// SuppressMaintenance 将维护窗口内的异常点置为0并清除其严重级别，被抑制的点数记录在frame meta中
+func SuppressMaintenance(r *backend.DataResponse, c *models.Calendar) *backend.DataResponse {
+	if r == nil || c == nil || len(c.Windows) == 0 {
+		return r
+	}
+	total := 0
+	for _, frame := range r.Frames {
+		if converter.ResultName(frame.Name) != "anomaly" || len(frame.Fields) < 2 {
+			continue
+		}
+		suppressed, remaining := 0, 0
+		field := frame.Fields[1]
+		severity, _ := frame.FieldByName(converter.SeverityFieldName)
+		for i := 0; i < field.Len(); i++ {
+			ts, ok := timeAt(frame.Fields[0], i)
+			if !ok {
+				continue
+			}
+			if v, ok := valueAt(field, i); !ok || v == 0 {
+				continue
+			}
+			if !c.InWindow(ts) {
+				remaining++
+				continue
+			}
+			switch field.Type() {
+			case data.FieldTypeFloat64:
+				field.Set(i, 0.0)
+			case data.FieldTypeNullableFloat64:
+				zero := 0.0
+				field.Set(i, &zero)
+			default:
+				continue
+			}
+			if severity != nil && severity.Type() == data.FieldTypeString {
+				severity.Set(i, "")
+			}
+			suppressed++
+		}
+		// 告警frame的严重级别在标签中，异常点全部被抑制时不再带级别
+		if suppressed > 0 && remaining == 0 {
+			if _, ok := field.Labels[converter.SeverityFieldName]; ok {
+				labels := field.Labels.Copy()
+				delete(labels, converter.SeverityFieldName)
+				field.Labels = labels
+			}
+		}
+		if suppressed > 0 {
+			frame.Meta = converter.WithCustomMeta(frame.Meta, map[string]string{
+				"suppressedAnomalies": strconv.Itoa(suppressed),
+			})
+			total += suppressed
+		}
+	}
+	if total > 0 && len(r.Frames) > 0 {
+		r.Frames[0].Meta = converter.CopyFrameMeta(r.Frames[0].Meta)
+		r.Frames[0].AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("%d anomaly points inside maintenance windows were suppressed.", total),
+		})
+	}
+	return r
+}
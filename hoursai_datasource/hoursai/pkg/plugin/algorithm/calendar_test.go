@@ -0,0 +1,113 @@
+package algorithm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util/converter"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// anomalyFrame 每分钟一个点的anomaly frame，带逐点的严重级别
+func anomalyFrame(labels data.Labels, values []float64, severities []string) *data.Frame {
+	frame := floatFrame(labels, values...)
+	frame.Name = "anomaly"
+	frame.Fields = append(frame.Fields, data.NewField(converter.SeverityFieldName, nil, severities))
+	return frame
+}
+
+func TestSuppressMaintenance(t *testing.T) {
+	// 维护窗口覆盖第1、2个点，左闭右开
+	calendar := &models.Calendar{Windows: []models.Window{{
+		Source: util.WindowMaintenance,
+		Start:  time.Minute.Milliseconds(),
+		End:    3 * time.Minute.Milliseconds(),
+	}}}
+	tests := []struct {
+		name       string
+		values     []float64
+		severities []string
+		want       []float64
+		wantSev    []string
+		suppressed string
+	}{
+		{name: "points inside the window are suppressed",
+			values: []float64{1, 1, 1, 1}, severities: []string{"warning", "critical", "info", "warning"},
+			want: []float64{1, 0, 0, 1}, wantSev: []string{"warning", "", "", "warning"}, suppressed: "2"},
+		{name: "window end is exclusive",
+			values: []float64{0, 0, 0, 1}, severities: []string{"", "", "", "info"},
+			want: []float64{0, 0, 0, 1}, wantSev: []string{"", "", "", "info"}},
+		{name: "normal points are not counted",
+			values: []float64{0, 1, 0, 0}, severities: []string{"", "critical", "", ""},
+			want: []float64{0, 0, 0, 0}, wantSev: []string{"", "", "", ""}, suppressed: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value := floatFrame(nil, 5, 6, 7, 8)
+			frame := anomalyFrame(nil, tt.values, tt.severities)
+			r := SuppressMaintenance(&backend.DataResponse{Frames: data.Frames{value, frame}}, calendar)
+			for i, want := range tt.want {
+				if got := frame.Fields[1].At(i).(float64); got != want {
+					t.Errorf("point %d = %v, want %v", i, got, want)
+				}
+				if got := frame.Fields[2].At(i).(string); got != tt.wantSev[i] {
+					t.Errorf("point %d severity = %q, want %q", i, got, tt.wantSev[i])
+				}
+			}
+			var suppressed string
+			if frame.Meta != nil {
+				suppressed = frame.Meta.Custom.(map[string]string)["suppressedAnomalies"]
+			}
+			if suppressed != tt.suppressed {
+				t.Errorf("suppressedAnomalies = %q, want %q", suppressed, tt.suppressed)
+			}
+			notices := 0
+			if r.Frames[0].Meta != nil {
+				notices = len(r.Frames[0].Meta.Notices)
+			}
+			if (notices > 0) != (tt.suppressed != "") {
+				t.Errorf("got %d notices on the first frame", notices)
+			}
+			if value.Fields[1].At(1).(float64) != 6 {
+				t.Error("value frame must not be changed")
+			}
+		})
+	}
+}
+
+func TestSuppressMaintenanceAlertSeverityLabel(t *testing.T) {
+	calendar := &models.Calendar{Windows: []models.Window{{Start: 0, End: time.Hour.Milliseconds()}}}
+	// 全部异常点被抑制时告警frame不再带严重级别标签
+	frame := floatFrame(data.Labels{"host": "a", converter.SeverityFieldName: "critical"}, 0, 1, 1)
+	frame.Name = "anomaly"
+	SuppressMaintenance(&backend.DataResponse{Frames: data.Frames{frame}}, calendar)
+	if _, ok := frame.Fields[1].Labels[converter.SeverityFieldName]; ok {
+		t.Error("severity label kept after all anomalies were suppressed")
+	}
+	if frame.Fields[1].Labels["host"] != "a" {
+		t.Error("other labels must be kept")
+	}
+
+	// 窗口外仍有异常点时保留级别
+	calendar.Windows[0].End = time.Minute.Milliseconds() * 2
+	frame = floatFrame(data.Labels{converter.SeverityFieldName: "critical"}, 0, 1, 1)
+	frame.Name = "anomaly"
+	SuppressMaintenance(&backend.DataResponse{Frames: data.Frames{frame}}, calendar)
+	if _, ok := frame.Fields[1].Labels[converter.SeverityFieldName]; !ok {
+		t.Error("severity label removed although anomalies remain outside the window")
+	}
+}
+
+func TestSuppressMaintenanceNoWindows(t *testing.T) {
+	frame := anomalyFrame(nil, []float64{1, 1}, []string{"info", "info"})
+	r := &backend.DataResponse{Frames: data.Frames{frame}}
+	for _, c := range []*models.Calendar{nil, {}, {Holidays: []string{"2022-01-01"}}} {
+		SuppressMaintenance(r, c)
+	}
+	if frame.Fields[1].At(0).(float64) != 1 || frame.Meta != nil {
+		t.Error("anomalies suppressed without maintenance windows")
+	}
+}
@@ -0,0 +1,158 @@
+package algorithm
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// CardinalityLimit 发送给算法前的序列数和点数上限，0表示不限制；未指定策略时按方差裁剪，只有配置为reject时才拒绝查询
+type CardinalityLimit struct {
+	MaxSeries int64
+	MaxPoints int64
+	Strategy  string
+}
+
+// Merge 查询级别的限制只能收紧数据源级别的限制，策略以查询配置为准
+func (l CardinalityLimit) Merge(q CardinalityLimit) CardinalityLimit {
+	merged := l
+	if q.MaxSeries > 0 && (merged.MaxSeries == 0 || q.MaxSeries < merged.MaxSeries) {
+		merged.MaxSeries = q.MaxSeries
+	}
+	if q.MaxPoints > 0 && (merged.MaxPoints == 0 || q.MaxPoints < merged.MaxPoints) {
+		merged.MaxPoints = q.MaxPoints
+	}
+	if q.Strategy != "" {
+		merged.Strategy = q.Strategy
+	}
+	return merged
+}
+
+type candidateSeries struct {
+	frame  *data.Frame
+	points int64
+	order  float64
+}
+
+// GuardCardinality 序列数或点数超限时按策略拒绝或裁剪序列，并在保留的第一个frame上说明被丢弃的序列
+func GuardCardinality(r *backend.DataResponse, limit CardinalityLimit) (*backend.DataResponse, error) {
+	if r == nil || (limit.MaxSeries <= 0 && limit.MaxPoints <= 0) {
+		return r, nil
+	}
+	if limit.Strategy == "" {
+		limit.Strategy = util.CardinalityTopVariance
+	}
+	switch limit.Strategy {
+	case util.CardinalityReject, util.CardinalityTopVariance, util.CardinalityHashSample:
+	default:
+		return nil, util.PluginError(backend.StatusBadRequest,
+			fmt.Errorf("unknown cardinality strategy %q", limit.Strategy))
+	}
+	var (
+		series      []candidateSeries
+		others      data.Frames
+		totalPoints int64
+	)
+	for _, frame := range r.Frames {
+		if len(frame.Fields) < 2 || frame.Fields[0].Type() != data.FieldTypeTime {
+			others = append(others, frame)
+			continue
+		}
+		points := int64(frame.Fields[0].Len())
+		totalPoints += points
+		series = append(series, candidateSeries{frame: frame, points: points})
+	}
+	seriesExceeded := limit.MaxSeries > 0 && int64(len(series)) > limit.MaxSeries
+	pointsExceeded := limit.MaxPoints > 0 && totalPoints > limit.MaxPoints
+	if !seriesExceeded && !pointsExceeded {
+		return r, nil
+	}
+
+	switch limit.Strategy {
+	case util.CardinalityTopVariance:
+		for i := range series {
+			series[i].order = -variance(series[i].frame.Fields[1])
+		}
+	case util.CardinalityHashSample:
+		for i := range series {
+			h := fnv.New64a()
+			_, _ = h.Write([]byte(series[i].frame.Fields[1].Labels.String()))
+			series[i].order = float64(h.Sum64())
+		}
+	case util.CardinalityReject:
+		return nil, fmt.Errorf("query returned %d series with %d points, which exceeds the limit of %s; "+
+			"narrow the expression or raise the limit", len(series), totalPoints, limit.describe())
+	}
+	sort.SliceStable(series, func(i, j int) bool {
+		return series[i].order < series[j].order
+	})
+
+	var (
+		kept       data.Frames
+		keptPoints int64
+	)
+	for _, s := range series {
+		if limit.MaxSeries > 0 && int64(len(kept)) >= limit.MaxSeries {
+			break
+		}
+		if limit.MaxPoints > 0 && keptPoints+s.points > limit.MaxPoints {
+			continue
+		}
+		kept = append(kept, s.frame)
+		keptPoints += s.points
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("no series fits into the limit of %s", limit.describe())
+	}
+	kept[0].AppendNotices(data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text: fmt.Sprintf("Cardinality limit of %s exceeded: %d of %d series (%d of %d points) sent to the "+
+			"algorithm using %s sampling, %d series dropped.", limit.describe(), len(kept), len(series), keptPoints,
+			totalPoints, limit.Strategy, len(series)-len(kept)),
+	})
+	return &backend.DataResponse{
+		Frames: append(kept, others...),
+		Error:  r.Error,
+		Status: r.Status,
+	}, nil
+}
+
+func (l CardinalityLimit) describe() string {
+	switch {
+	case l.MaxSeries > 0 && l.MaxPoints > 0:
+		return fmt.Sprintf("%d series / %d points", l.MaxSeries, l.MaxPoints)
+	case l.MaxSeries > 0:
+		return fmt.Sprintf("%d series", l.MaxSeries)
+	default:
+		return fmt.Sprintf("%d points", l.MaxPoints)
+	}
+}
+
+// variance 序列的样本方差，非数值字段返回0
+func variance(field *data.Field) float64 {
+	var count int
+	var mean, m2 float64
+	for i := 0; i < field.Len(); i++ {
+		v, ok := field.ConcreteAt(i)
+		if !ok {
+			continue
+		}
+		f, ok := v.(float64)
+		if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+			continue
+		}
+		count++
+		delta := f - mean
+		mean += delta / float64(count)
+		m2 += delta * (f - mean)
+	}
+	if count < 2 {
+		return 0
+	}
+	return m2 / float64(count-1)
+}
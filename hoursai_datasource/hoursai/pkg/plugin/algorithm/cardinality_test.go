@@ -0,0 +1,75 @@
+package algorithm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestGuardCardinality(t *testing.T) {
+	newResponse := func() *backend.DataResponse {
+		return &backend.DataResponse{Frames: data.Frames{
+			floatFrame(data.Labels{"pod": "a"}, 1, 1, 1),
+			floatFrame(data.Labels{"pod": "b"}, 1, 9, 1),
+			floatFrame(data.Labels{"pod": "c"}, 1, 5, 1),
+		}}
+	}
+	tests := []struct {
+		name    string
+		limit   CardinalityLimit
+		kept    []string
+		notice  bool
+		wantErr bool
+	}{
+		{"no limit", CardinalityLimit{}, []string{"a", "b", "c"}, false, false},
+		{"within limit", CardinalityLimit{MaxSeries: 3, Strategy: util.CardinalityReject},
+			[]string{"a", "b", "c"}, false, false},
+		{"default strategy truncates by variance", CardinalityLimit{MaxSeries: 2}, []string{"b", "c"}, true, false},
+		{"reject only when configured", CardinalityLimit{MaxSeries: 2, Strategy: util.CardinalityReject},
+			nil, false, true},
+		{"point limit", CardinalityLimit{MaxPoints: 3, Strategy: util.CardinalityTopVariance},
+			[]string{"b"}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := GuardCardinality(newResponse(), tt.limit)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(r.Frames) != len(tt.kept) {
+				t.Fatalf("kept %d series, want %d", len(r.Frames), len(tt.kept))
+			}
+			for i, pod := range tt.kept {
+				if got := r.Frames[i].Fields[1].Labels["pod"]; got != pod {
+					t.Errorf("series %d is pod %s, want %s", i, got, pod)
+				}
+			}
+			if got := r.Frames[0].Meta != nil && len(r.Frames[0].Meta.Notices) > 0; got != tt.notice {
+				t.Errorf("notice = %v, want %v", got, tt.notice)
+			}
+		})
+	}
+}
+
+func TestGuardCardinalityUnknownStrategy(t *testing.T) {
+	r := &backend.DataResponse{Frames: data.Frames{floatFrame(data.Labels{"pod": "a"}, 1, 2)}}
+	// 策略名拼写错误时即使未超限也报告原因，而不是按拒绝处理
+	for _, maxSeries := range []int64{1, 10} {
+		_, err := GuardCardinality(r, CardinalityLimit{MaxSeries: maxSeries, Strategy: "topvariance"})
+		var queryErr *util.QueryError
+		if !errors.As(err, &queryErr) || queryErr.Status != backend.StatusBadRequest ||
+			!strings.Contains(err.Error(), `unknown cardinality strategy "topvariance"`) {
+			t.Errorf("max series %d: got %v, want an unknown strategy error", maxSeries, err)
+		}
+	}
+}
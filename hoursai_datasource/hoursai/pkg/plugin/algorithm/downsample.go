@@ -0,0 +1,405 @@
+package algorithm
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util/converter"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// minPointBudget LTTB至少保留首尾点和一个中间点
+const minPointBudget = 3
+
+// discreteFrames 取值为标记的算法结果，还原时不做插值
+var discreteFrames = map[string]bool{
+	"anomaly":     true,
+	"changePoint": true,
+}
+
+// DownsampleOptions 单条序列发送给算法的点数上限和降采样方式，Budget为0时不降采样
+type DownsampleOptions struct {
+	Budget int
+	Method string
+}
+
+// downsampling 一次降采样的结果，用于调整请求的interval和还原算法结果
+type downsampling struct {
+	opts DownsampleOptions
+	// intervals 各序列降采样后的平均间隔，与请求中的序列一一对应，未降采样的序列为0
+	intervals []time.Duration
+	// holds 按桶聚合时标记类结果在整个桶内生效，key为序列id
+	holds map[uint64]time.Duration
+}
+
+// getDownsampleOptions 查询配置优先，其次为数据源配置，默认不降采样，降采样方式默认为lttb
+func getDownsampleOptions(q *models.Query) DownsampleOptions {
+	opts := DownsampleOptions{Budget: int(q.PointBudget), Method: q.Downsample}
+	jsonData := jsonDataMap(q)
+	if opts.Budget <= 0 {
+		budget, err := util.GetInt64Optional(jsonData, "pointBudget")
+		if err != nil {
+			log.DefaultLogger.Error("Read point budget error", "err", err)
+		}
+		opts.Budget = int(budget)
+	}
+	if opts.Method == "" {
+		method, err := util.GetStringOptional(jsonData, "downsampleMethod")
+		if err != nil {
+			log.DefaultLogger.Error("Read downsample method error", "err", err)
+		}
+		opts.Method = method
+	}
+	if opts.Method == "" {
+		opts.Method = util.DownsampleLTTB
+	}
+	return opts
+}
+
+// Downsample 将超过点数上限的序列降采样后再发送给算法，返回的frame与输入一一对应，
+// 没有序列需要降采样时downsampling为nil
+func Downsample(r *backend.DataResponse, q *models.Query) (*backend.DataResponse, *downsampling, error) {
+	opts := getDownsampleOptions(q)
+	if opts.Budget <= 0 {
+		return r, nil, nil
+	}
+	switch opts.Method {
+	case util.DownsampleLTTB, util.DownsampleAvg, util.DownsampleMinMax:
+	default:
+		return nil, nil, util.PluginError(backend.StatusBadRequest,
+			fmt.Errorf("unknown downsample method %s", opts.Method))
+	}
+	if opts.Budget < minPointBudget {
+		return nil, nil, util.PluginError(backend.StatusBadRequest,
+			fmt.Errorf("point budget must be at least %d, got %d", minPointBudget, opts.Budget))
+	}
+
+	var (
+		response = &backend.DataResponse{Error: r.Error, Status: r.Status}
+		ds       = &downsampling{opts: opts, holds: make(map[uint64]time.Duration)}
+		applied  bool
+	)
+	for _, frame := range r.Frames {
+		if len(frame.Fields) < 2 || frame.Fields[0].Type().NonNullableType() != data.FieldTypeTime ||
+			frame.Fields[0].Len() <= opts.Budget {
+			response.Frames = append(response.Frames, frame)
+			ds.intervals = append(ds.intervals, 0)
+			continue
+		}
+		points := framePoints(frame)
+		var sampled []samplePoint
+		switch opts.Method {
+		case util.DownsampleAvg:
+			size := bucketSize(len(points), opts.Budget)
+			sampled = avgBuckets(points, size)
+			ds.holds[seriesID(frame.Fields[1].Labels)] = time.Duration(size) * q.Step
+		case util.DownsampleMinMax:
+			sampled = minMaxBuckets(points, bucketSize(len(points), opts.Budget/2))
+		default:
+			sampled = lttb(points, opts.Budget)
+		}
+		response.Frames = append(response.Frames, newSampledFrame(frame, sampled))
+		var interval time.Duration
+		if len(sampled) > 1 {
+			interval = points[len(points)-1].ts.Sub(points[0].ts) / time.Duration(len(sampled)-1)
+		}
+		ds.intervals = append(ds.intervals, interval)
+		applied = true
+	}
+	if !applied {
+		return r, nil, nil
+	}
+	return response, ds, nil
+}
+
+func framePoints(frame *data.Frame) []samplePoint {
+	points := make([]samplePoint, 0, frame.Fields[0].Len())
+	for i := 0; i < frame.Fields[0].Len(); i++ {
+		ts, ok := timeAt(frame.Fields[0], i)
+		if !ok {
+			continue
+		}
+		v, ok := valueAt(frame.Fields[1], i)
+		points = append(points, samplePoint{ts: ts, value: v, missing: !ok, row: i})
+	}
+	return points
+}
+
+func bucketSize(points int, buckets int) int {
+	if buckets < 1 {
+		buckets = 1
+	}
+	return (points + buckets - 1) / buckets
+}
+
+// lttb Largest-Triangle-Three-Buckets，保留首尾点，每个桶选取与前一个选中点和下一个桶均值构成三角形面积最大的点
+func lttb(points []samplePoint, threshold int) []samplePoint {
+	if threshold >= len(points) || threshold < minPointBudget {
+		return points
+	}
+	origin := points[0].ts
+	x := func(p samplePoint) float64 { return p.ts.Sub(origin).Seconds() }
+
+	sampled := make([]samplePoint, 0, threshold)
+	sampled = append(sampled, points[0])
+	every := float64(len(points)-2) / float64(threshold-2)
+	a := 0
+	for i := 0; i < threshold-2; i++ {
+		avgStart := int(math.Floor(float64(i+1)*every)) + 1
+		avgEnd := int(math.Floor(float64(i+2)*every)) + 1
+		if avgEnd > len(points) {
+			avgEnd = len(points)
+		}
+		var avgX, avgY float64
+		valid := 0
+		for _, p := range points[avgStart:avgEnd] {
+			if p.missing {
+				continue
+			}
+			avgX += x(p)
+			avgY += p.value
+			valid++
+		}
+		if valid > 0 {
+			avgX /= float64(valid)
+			avgY /= float64(valid)
+		} else {
+			avgX = x(points[avgStart])
+			avgY = points[a].value
+		}
+
+		ax, ay := x(points[a]), points[a].value
+		if points[a].missing {
+			ay = avgY
+		}
+		rangeStart := int(math.Floor(float64(i)*every)) + 1
+		rangeEnd := int(math.Floor(float64(i+1)*every)) + 1
+		next, maxArea := rangeStart, -1.0
+		for j := rangeStart; j < rangeEnd; j++ {
+			if points[j].missing {
+				continue
+			}
+			area := math.Abs((ax-avgX)*(points[j].value-ay) - (ax-x(points[j]))*(avgY-ay))
+			if area > maxArea {
+				next, maxArea = j, area
+			}
+		}
+		sampled = append(sampled, points[next])
+		a = next
+	}
+	return append(sampled, points[len(points)-1])
+}
+
+// avgBuckets 每size个点取一次均值，时间为桶内第一个点，桶内没有有效值时为缺失点
+func avgBuckets(points []samplePoint, size int) []samplePoint {
+	sampled := make([]samplePoint, 0, len(points)/size+1)
+	for start := 0; start < len(points); start += size {
+		end := start + size
+		if end > len(points) {
+			end = len(points)
+		}
+		var sum float64
+		valid := 0
+		for _, p := range points[start:end] {
+			if !p.missing {
+				sum += p.value
+				valid++
+			}
+		}
+		if valid == 0 {
+			sampled = append(sampled, samplePoint{ts: points[start].ts, missing: true})
+			continue
+		}
+		sampled = append(sampled, samplePoint{ts: points[start].ts, value: sum / float64(valid)})
+	}
+	return sampled
+}
+
+// minMaxBuckets 每个桶按时间顺序保留最小值和最大值两个点，保证尖峰不会被平均掉
+func minMaxBuckets(points []samplePoint, size int) []samplePoint {
+	sampled := make([]samplePoint, 0, 2*(len(points)/size+1))
+	for start := 0; start < len(points); start += size {
+		end := start + size
+		if end > len(points) {
+			end = len(points)
+		}
+		minIdx, maxIdx := -1, -1
+		for j := start; j < end; j++ {
+			if points[j].missing {
+				continue
+			}
+			if minIdx < 0 || points[j].value < points[minIdx].value {
+				minIdx = j
+			}
+			if maxIdx < 0 || points[j].value > points[maxIdx].value {
+				maxIdx = j
+			}
+		}
+		switch {
+		case minIdx < 0:
+			sampled = append(sampled, samplePoint{ts: points[start].ts, missing: true})
+		case minIdx == maxIdx:
+			sampled = append(sampled, points[minIdx])
+		case minIdx < maxIdx:
+			sampled = append(sampled, points[minIdx], points[maxIdx])
+		default:
+			sampled = append(sampled, points[maxIdx], points[minIdx])
+		}
+	}
+	return sampled
+}
+
+// newSampledFrame 降采样后的序列只用于生成算法请求，缺失点保持为null
+func newSampledFrame(frame *data.Frame, points []samplePoint) *data.Frame {
+	times := make([]time.Time, len(points))
+	values := make([]*float64, len(points))
+	for i := range points {
+		times[i] = points[i].ts
+		if !points[i].missing {
+			values[i] = &points[i].value
+		}
+	}
+	timeField := data.NewField(frame.Fields[0].Name, frame.Fields[0].Labels, times)
+	valueField := data.NewField(frame.Fields[1].Name, frame.Fields[1].Labels, values)
+	newFrame := data.NewFrame(frame.Name, timeField, valueField)
+	newFrame.RefID = frame.RefID
+	newFrame.Meta = frame.Meta
+	return newFrame
+}
+
+// expandResponse 将降采样序列上的算法结果还原到展示的step上：数值结果线性插值，标记类结果只保留在选中的点
+// （按桶聚合时为整个桶）上。full为降采样前的预处理序列，display中原样返回的原始序列不做处理
+func expandResponse(response *backend.DataResponse, display *backend.DataResponse, full *backend.DataResponse,
+	ds *downsampling, q *models.Query) *backend.DataResponse {
+	if response == nil {
+		return response
+	}
+	raw := make(map[*data.Frame]bool, len(display.Frames))
+	for _, frame := range display.Frames {
+		raw[frame] = true
+	}
+	grids := make(map[uint64]*data.Field, len(full.Frames))
+	for _, frame := range full.Frames {
+		if len(frame.Fields) >= 2 {
+			grids[seriesID(frame.Fields[1].Labels)] = frame.Fields[0]
+		}
+	}
+	for i, frame := range response.Frames {
+		if raw[frame] || len(frame.Fields) < 2 || frame.Fields[0].Type().NonNullableType() != data.FieldTypeTime {
+			continue
+		}
+		key := seriesID(frame.Fields[1].Labels)
+		grid, ok := grids[key]
+		if !ok || grid.Len() <= frame.Fields[0].Len() {
+			continue
+		}
+		response.Frames[i] = expandFrame(frame, grid, discreteFrames[converter.ResultName(frame.Name)], ds.holds[key], q.Step, ds.opts)
+	}
+	return response
+}
+
+// seriesID 由排序后的标签名和值计算的序列id，标签值中含有逗号或等号时也不会与其它序列混淆
+func seriesID(labels data.Labels) uint64 {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := fnv.New64a()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0xff})
+		h.Write([]byte(labels[k]))
+		h.Write([]byte{0xff})
+	}
+	return h.Sum64()
+}
+
+func expandFrame(frame *data.Frame, grid *data.Field, discrete bool, hold time.Duration, step time.Duration,
+	opts DownsampleOptions) *data.Frame {
+	sampled := framePoints(frame)
+	times := make([]time.Time, 0, grid.Len())
+	values := make([]float64, 0, grid.Len())
+	// 标记类结果的字符串字段（如严重级别）随标记值一起还原
+	var sources, extras []*data.Field
+	if discrete {
+		for _, field := range frame.Fields[2:] {
+			if field.Type() != data.FieldTypeString {
+				continue
+			}
+			extra := data.NewFieldFromFieldType(data.FieldTypeString, 0)
+			extra.Name, extra.Labels, extra.Config = field.Name, field.Labels, field.Config
+			sources = append(sources, field)
+			extras = append(extras, extra)
+		}
+	}
+	j := 0
+	for i := 0; i < grid.Len(); i++ {
+		ts, ok := timeAt(grid, i)
+		if !ok {
+			continue
+		}
+		// j指向最后一个不晚于ts的采样点
+		for j+1 < len(sampled) && !sampled[j+1].ts.After(ts) {
+			j++
+		}
+		v := expandValue(sampled, j, ts, discrete, hold)
+		times = append(times, ts)
+		values = append(values, v)
+		for k, extra := range extras {
+			s := ""
+			if v != 0 && !math.IsNaN(v) {
+				s, _ = sources[k].At(sampled[j].row).(string)
+			}
+			extra.Append(s)
+		}
+	}
+
+	timeField := data.NewField(data.TimeSeriesTimeFieldName, nil, times)
+	timeField.Config = &data.FieldConfig{Interval: float64(step.Milliseconds())}
+	valueField := data.NewField(frame.Fields[1].Name, frame.Fields[1].Labels, values)
+	valueField.Config = frame.Fields[1].Config
+	newFrame := data.NewFrame(frame.Name, append([]*data.Field{timeField, valueField}, extras...)...)
+	newFrame.RefID = frame.RefID
+	newFrame.Meta = converter.WithCustomMeta(frame.Meta, map[string]string{
+		"downsampleMethod": opts.Method,
+		"pointBudget":      strconv.Itoa(opts.Budget),
+	})
+	return newFrame
+}
+
+func expandValue(sampled []samplePoint, j int, ts time.Time, discrete bool, hold time.Duration) float64 {
+	if len(sampled) == 0 {
+		return math.NaN()
+	}
+	p := sampled[j]
+	if discrete {
+		if p.missing || ts.Before(p.ts) {
+			return 0
+		}
+		if ts.Equal(p.ts) || ts.Sub(p.ts) < hold {
+			return p.value
+		}
+		return 0
+	}
+	if p.missing {
+		return math.NaN()
+	}
+	if ts.Before(p.ts) || ts.Equal(p.ts) || j+1 >= len(sampled) {
+		return p.value
+	}
+	next := sampled[j+1]
+	if next.missing {
+		return math.NaN()
+	}
+	ratio := float64(ts.Sub(p.ts)) / float64(next.ts.Sub(p.ts))
+	return p.value + (next.value-p.value)*ratio
+}
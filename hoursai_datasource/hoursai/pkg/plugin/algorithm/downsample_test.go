@@ -0,0 +1,279 @@
+package algorithm
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// samplePoints 每秒一个点，nil为缺失点
+func samplePoints(values ...*float64) []samplePoint {
+	points := make([]samplePoint, len(values))
+	for i, v := range values {
+		points[i] = samplePoint{ts: time.Unix(int64(i), 0), row: i}
+		if v == nil {
+			points[i].missing = true
+			continue
+		}
+		points[i].value = *v
+	}
+	return points
+}
+
+func pointValues(points []samplePoint) []*float64 {
+	values := make([]*float64, len(points))
+	for i := range points {
+		if !points[i].missing {
+			values[i] = fp(points[i].value)
+		}
+	}
+	return values
+}
+
+func equalValues(a, b []*float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if (a[i] == nil) != (b[i] == nil) || (a[i] != nil && *a[i] != *b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func formatValues(values []*float64) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		if v != nil {
+			result[i] = *v
+		}
+	}
+	return result
+}
+
+func TestLTTB(t *testing.T) {
+	flat := samplePoints(fp(0), fp(0), fp(0), fp(0), fp(0), fp(9), fp(0), fp(0), fp(0), fp(0))
+	tests := []struct {
+		name      string
+		points    []samplePoint
+		threshold int
+		want      int
+	}{
+		{name: "budget above length", points: flat, threshold: 20, want: len(flat)},
+		{name: "budget equal to length", points: flat, threshold: len(flat), want: len(flat)},
+		{name: "budget of two keeps all points", points: flat, threshold: 2, want: len(flat)},
+		{name: "budget of zero keeps all points", points: flat, threshold: 0, want: len(flat)},
+		{name: "minimum budget", points: flat, threshold: minPointBudget, want: minPointBudget},
+		{name: "downsampled", points: flat, threshold: 4, want: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sampled := lttb(tt.points, tt.threshold)
+			if len(sampled) != tt.want {
+				t.Fatalf("got %d points, want %d", len(sampled), tt.want)
+			}
+			if !sampled[0].ts.Equal(tt.points[0].ts) || !sampled[len(sampled)-1].ts.Equal(tt.points[len(tt.points)-1].ts) {
+				t.Error("first and last points must be kept")
+			}
+		})
+	}
+
+	t.Run("keeps the spike", func(t *testing.T) {
+		found := false
+		for _, p := range lttb(flat, 4) {
+			found = found || p.value == 9
+		}
+		if !found {
+			t.Error("spike was dropped")
+		}
+	})
+
+	t.Run("all-missing bucket", func(t *testing.T) {
+		points := samplePoints(fp(1), fp(2), nil, nil, nil, nil, fp(3), fp(4))
+		sampled := lttb(points, 4)
+		if len(sampled) != 4 {
+			t.Fatalf("got %d points, want 4", len(sampled))
+		}
+		for i := 1; i < len(sampled); i++ {
+			if !sampled[i].ts.After(sampled[i-1].ts) {
+				t.Fatalf("points are not in time order: %v", sampled)
+			}
+		}
+	})
+}
+
+func TestAvgBuckets(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []samplePoint
+		size   int
+		want   []*float64
+		times  []int64
+	}{
+		{name: "partial last bucket", points: samplePoints(fp(1), fp(3), fp(5), fp(7), fp(9)), size: 2,
+			want: []*float64{fp(2), fp(6), fp(9)}, times: []int64{0, 2, 4}},
+		{name: "exact buckets", points: samplePoints(fp(1), fp(3), fp(5), fp(7)), size: 2,
+			want: []*float64{fp(2), fp(6)}, times: []int64{0, 2}},
+		{name: "missing points are skipped", points: samplePoints(fp(1), nil, fp(5), fp(7)), size: 2,
+			want: []*float64{fp(1), fp(6)}, times: []int64{0, 2}},
+		{name: "all-missing bucket", points: samplePoints(fp(1), fp(3), nil, nil, fp(5)), size: 2,
+			want: []*float64{fp(2), nil, fp(5)}, times: []int64{0, 2, 4}},
+		{name: "single bucket", points: samplePoints(fp(1), fp(2), fp(3)), size: 5,
+			want: []*float64{fp(2)}, times: []int64{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sampled := avgBuckets(tt.points, tt.size)
+			if got := pointValues(sampled); !equalValues(got, tt.want) {
+				t.Fatalf("values = %v, want %v", formatValues(got), formatValues(tt.want))
+			}
+			for i, p := range sampled {
+				if p.ts.Unix() != tt.times[i] {
+					t.Errorf("point %d at %d, want the first point of the bucket at %d", i, p.ts.Unix(), tt.times[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMinMaxBuckets(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []samplePoint
+		size   int
+		want   []*float64
+	}{
+		{name: "keeps time order", points: samplePoints(fp(5), fp(1), fp(3), fp(2), fp(4), fp(6)), size: 3,
+			want: []*float64{fp(5), fp(1), fp(2), fp(6)}},
+		{name: "partial last bucket", points: samplePoints(fp(1), fp(2), fp(3), fp(4), fp(5)), size: 2,
+			want: []*float64{fp(1), fp(2), fp(3), fp(4), fp(5)}},
+		{name: "constant bucket keeps one point", points: samplePoints(fp(2), fp(2), fp(2)), size: 3,
+			want: []*float64{fp(2)}},
+		{name: "all-missing bucket", points: samplePoints(fp(1), fp(2), nil, nil), size: 2,
+			want: []*float64{fp(1), fp(2), nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pointValues(minMaxBuckets(tt.points, tt.size)); !equalValues(got, tt.want) {
+				t.Errorf("values = %v, want %v", formatValues(got), formatValues(tt.want))
+			}
+		})
+	}
+}
+
+func TestDownsampleBudget(t *testing.T) {
+	frame := floatFrame(data.Labels{"host": "a"}, 1, 2, 3, 4, 5, 6)
+	r := &backend.DataResponse{Frames: data.Frames{frame}}
+	tests := []struct {
+		name    string
+		budget  int64
+		applied bool
+		invalid bool
+	}{
+		{name: "no budget", budget: 0},
+		{name: "budget above length", budget: 10},
+		{name: "budget of one", budget: 1, invalid: true},
+		{name: "budget of two", budget: 2, invalid: true},
+		{name: "minimum budget", budget: minPointBudget, applied: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &models.Query{PointBudget: tt.budget, Step: time.Minute, JsonData: json.RawMessage(`{}`)}
+			sampled, ds, err := Downsample(r, q)
+			var queryErr *util.QueryError
+			if tt.invalid {
+				if !errors.As(err, &queryErr) || queryErr.Status != backend.StatusBadRequest {
+					t.Fatalf("expected bad request error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (ds != nil) != tt.applied {
+				t.Fatalf("downsampling applied = %v, want %v", ds != nil, tt.applied)
+			}
+			if tt.applied && sampled.Frames[0].Fields[0].Len() != int(tt.budget) {
+				t.Errorf("got %d points, want %d", sampled.Frames[0].Fields[0].Len(), tt.budget)
+			}
+		})
+	}
+}
+
+func TestExpandResponse(t *testing.T) {
+	labels := data.Labels{"host": "a"}
+	full := &backend.DataResponse{Frames: data.Frames{floatFrame(labels, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)}}
+	display := &backend.DataResponse{Frames: data.Frames{floatFrame(labels, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)}}
+	// 降采样后的结果只有第0、5、9个点
+	sampled := func(name string, values ...float64) *data.Frame {
+		times := []time.Time{time.Unix(0, 0), time.Unix(300, 0), time.Unix(540, 0)}
+		return data.NewFrame(name, data.NewField("Time", nil, times), data.NewField("Value", labels, values))
+	}
+	tests := []struct {
+		name  string
+		frame *data.Frame
+		hold  time.Duration
+		want  []float64
+	}{
+		{name: "numeric results are interpolated", frame: sampled("baseline", 0, 50, 90),
+			want: []float64{0, 10, 20, 30, 40, 50, 60, 70, 80, 90}},
+		{name: "markers stay on the selected points", frame: sampled("anomaly", 0, 1, 0),
+			want: []float64{0, 0, 0, 0, 0, 1, 0, 0, 0, 0}},
+		{name: "markers hold for the bucket", frame: sampled("anomaly", 0, 1, 0), hold: 2 * time.Minute,
+			want: []float64{0, 0, 0, 0, 0, 1, 1, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &downsampling{opts: DownsampleOptions{Budget: 3, Method: util.DownsampleAvg},
+				holds: map[uint64]time.Duration{seriesID(labels): tt.hold}}
+			response := &backend.DataResponse{Frames: append(data.Frames{display.Frames[0]}, tt.frame)}
+			response = expandResponse(response, display, full, ds, &models.Query{Step: time.Minute})
+			if response.Frames[0] != display.Frames[0] {
+				t.Error("original frame must be returned unchanged")
+			}
+			field := response.Frames[1].Fields[1]
+			if field.Len() != len(tt.want) {
+				t.Fatalf("got %d points, want %d", field.Len(), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if got, _ := field.ConcreteAt(i); math.Abs(got.(float64)-want) > 1e-9 {
+					t.Errorf("point %d = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExpandResponseSeriesID(t *testing.T) {
+	// 两条序列的Labels.String()相同，但属于不同的序列
+	a := data.Labels{"a": "x, b=y"}
+	b := data.Labels{"a": "x", "b": "y"}
+	if a.String() != b.String() {
+		t.Fatalf("test labels should render the same: %q %q", a.String(), b.String())
+	}
+	if seriesID(a) == seriesID(b) {
+		t.Fatal("different label sets must have different series ids")
+	}
+	full := &backend.DataResponse{Frames: data.Frames{
+		floatFrame(a, 0, 1, 2, 3, 4),
+		floatFrame(b, 0, 1),
+	}}
+	result := data.NewFrame("baseline", data.NewField("Time", nil, []time.Time{time.Unix(0, 0), time.Unix(240, 0)}),
+		data.NewField("Value", b, []float64{0, 4}))
+	ds := &downsampling{opts: DownsampleOptions{Budget: 3, Method: util.DownsampleLTTB},
+		holds: map[uint64]time.Duration{}}
+	response := expandResponse(&backend.DataResponse{Frames: data.Frames{result}}, &backend.DataResponse{}, full, ds,
+		&models.Query{Step: time.Minute})
+	// b的网格只有两个点，结果不能被展开到a的网格上
+	if n := response.Frames[0].Fields[0].Len(); n != 2 {
+		t.Errorf("result expanded onto another series' grid: %d points", n)
+	}
+}
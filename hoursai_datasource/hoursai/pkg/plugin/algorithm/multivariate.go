@@ -0,0 +1,290 @@
+package algorithm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/client"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util/converter"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"golang.org/x/net/context"
+)
+
+// builtinAnomalyThreshold 内置引擎判定异常的联合分数阈值
+const builtinAnomalyThreshold = 3.0
+
+type MultivariatePoint struct {
+	Timestamp int64     `json:"timestamp"`
+	Values    []float64 `json:"values"`
+}
+
+type MultivariateQuery struct {
+	Scene      string              `json:"scene,omitempty"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version"`
+	Params     string              `json:"params"`
+	Interval   int64               `json:"interval"`
+	MetaInfo   string              `json:"metaInfo"`
+	Dimensions []string            `json:"dimensions"`
+	Series     []MultivariatePoint `json:"series"`
+}
+
+// jointSeries 标签相同的多个表达式序列按时间戳对齐后的结果
+type jointSeries struct {
+	labels data.Labels
+	points []MultivariatePoint
+}
+
+// joinSeries 将每个表达式的查询结果按去掉__name__后的标签分组，只保留所有表达式都存在的时间点；
+// 同一表达式的多条序列去掉__name__后标签相同时无法区分，返回错误
+func joinSeries(responses []*backend.DataResponse, exprs []string) ([]jointSeries, error) {
+	type entry struct {
+		labels data.Labels
+		values []map[int64]float64
+	}
+	entries := make(map[string]*entry)
+	var keys []string
+	for i, r := range responses {
+		for _, frame := range r.Frames {
+			if len(frame.Fields) < 2 || frame.Fields[0].Type().NonNullableType() != data.FieldTypeTime ||
+				!frame.Fields[1].Type().Numeric() {
+				continue
+			}
+			labels := frame.Fields[1].Labels.Copy()
+			delete(labels, "__name__")
+			key := labels.String()
+			e, ok := entries[key]
+			if !ok {
+				e = &entry{labels: labels, values: make([]map[int64]float64, len(responses))}
+				entries[key] = e
+				keys = append(keys, key)
+			}
+			if e.values[i] != nil {
+				expr := ""
+				if i < len(exprs) {
+					expr = exprs[i]
+				}
+				return nil, util.PluginError(backend.StatusBadRequest, fmt.Errorf("expression %q returns more "+
+					"than one series with labels %s after dropping __name__", expr, key))
+			}
+			e.values[i] = make(map[int64]float64)
+			for j := 0; j < frame.Fields[0].Len(); j++ {
+				ts, ok := timeAt(frame.Fields[0], j)
+				if !ok {
+					continue
+				}
+				// 缺失点不参与联合，该时间点会被丢弃
+				if v, ok := valueAt(frame.Fields[1], j); ok {
+					e.values[i][ts.UnixMilli()] = v
+				}
+			}
+		}
+	}
+
+	result := make([]jointSeries, 0, len(keys))
+	for _, key := range keys {
+		e := entries[key]
+		complete := true
+		for _, values := range e.values {
+			if values == nil {
+				complete = false
+				break
+			}
+		}
+		if !complete {
+			log.DefaultLogger.Info("Skip series missing in some expressions", "labels", key)
+			continue
+		}
+		timestamps := make([]int64, 0, len(e.values[0]))
+		for ts := range e.values[0] {
+			timestamps = append(timestamps, ts)
+		}
+		sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+		js := jointSeries{labels: e.labels}
+		for _, ts := range timestamps {
+			point := MultivariatePoint{Timestamp: ts, Values: make([]float64, 0, len(e.values))}
+			for _, values := range e.values {
+				v, ok := values[ts]
+				if !ok {
+					break
+				}
+				point.Values = append(point.Values, v)
+			}
+			if len(point.Values) == len(e.values) {
+				js.points = append(js.points, point)
+			}
+		}
+		result = append(result, js)
+	}
+	return result, nil
+}
+
+func newMultivariateRequest(joint []jointSeries, q *models.Query) ([]MultivariateQuery, []map[string]string) {
+	var (
+		querys    []MultivariateQuery
+		metaInfos = make([]map[string]string, 0, len(joint))
+	)
+	dimensions, err := json.Marshal(q.Exprs)
+	if err != nil {
+		log.DefaultLogger.Error("Dimensions to json error,", err)
+	}
+	for _, js := range joint {
+		labels, err := json.Marshal(js.labels)
+		if err != nil {
+			log.DefaultLogger.Error("Labels to json error,", err)
+		}
+		metaInfo := map[string]string{
+			"promql":     q.Expr,
+			"labels":     string(labels),
+			"interval":   strconv.FormatInt(int64(q.Step/time.Second), 10),
+			"dimensions": string(dimensions),
+			"timezone":   q.TimezoneName(),
+		}
+		addCalendar(metaInfo, q)
+		metaInfoByte, err := json.Marshal(metaInfo)
+		if err != nil {
+			log.DefaultLogger.Error("Create multivariate request error,", err)
+		}
+		querys = append(querys, MultivariateQuery{
+			Scene:      q.Scene,
+			Name:       q.Name,
+			Version:    q.Version,
+			Params:     q.Params,
+			Interval:   int64(q.Step / time.Second),
+			MetaInfo:   string(metaInfoByte),
+			Dimensions: q.Exprs,
+			Series:     js.points,
+		})
+		metaInfos = append(metaInfos, metaInfo)
+	}
+	return querys, metaInfos
+}
+
+// CallMultivariate 将多个表达式的查询结果联合后调用多变量异常检测，engine为builtin时在插件内计算
+func CallMultivariate(ctx context.Context, responses []*backend.DataResponse, q *models.Query,
+	policy *client.ManagerPolicy) (*backend.DataResponse, error) {
+	result := &backend.DataResponse{}
+	for i, r := range responses {
+		// 发送给算法前先处理缺失值并对齐step，返回的原始序列保持查询结果原样
+		result.Frames = append(result.Frames, r.Frames...)
+		prepared, err := Preprocess(r, q)
+		if err != nil {
+			return result, err
+		}
+		responses[i] = prepared
+	}
+	joint, err := joinSeries(responses, q.Exprs)
+	if err != nil {
+		return result, err
+	}
+	if len(joint) == 0 {
+		return result, util.PluginError(backend.StatusBadRequest,
+			fmt.Errorf("no series with identical labels found across %d expressions", len(q.Exprs)))
+	}
+
+	if q.Engine == util.EngineBuiltin {
+		interval := float64(q.Step / time.Second)
+		for _, js := range joint {
+			result.Frames = append(result.Frames,
+				converter.NewMultivariateFrames(detectMultivariate(js.points, len(q.Exprs)), js.labels, q.Exprs,
+					interval)...)
+		}
+		return result, nil
+	}
+
+	jsonMap, header, err := managerSettings(q)
+	if err != nil {
+		return result, err
+	}
+	request, metaInfos := newMultivariateRequest(joint, q)
+	body, err := json.Marshal(request)
+	if err != nil {
+		log.DefaultLogger.Error("Request to json error, error is: ", err)
+		return result, err
+	}
+	c := client.NewClient(&http.Client{Timeout: 60 * time.Second}, http.MethodPost,
+		jsonMap["managerUrl"]+util.MultivariatePath)
+	c.SetPolicy(policy)
+	c.SetRetry(getRetryOptions(q))
+	c.SetCoalesce(true)
+	resp, err := c.CallAlgorithm(ctx, body, header)
+	if err != nil {
+		log.DefaultLogger.Error("Http request to call algorithm error, error is: ", err)
+		return result, err
+	}
+	rsp, failures, err := ParseAlgorithmResponse(resp, result, util.MultivariateType, metaInfos, "", q.Scene,
+		converter.AnomalyRules{})
+	if err != nil || rsp.Error != nil || len(failures) == 0 {
+		return rsp, err
+	}
+	if len(rsp.Frames) > 0 {
+		rsp.Frames[0].AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Multivariate detection failed for %d of %d series groups", len(failures), len(joint)),
+		})
+	}
+	return rsp, nil
+}
+
+// detectMultivariate 内置多变量检测：各维度z-score的均方根作为联合分数，贡献度为各维度z-score平方的占比
+func detectMultivariate(points []MultivariatePoint, dimensions int) converter.MultivariateResult {
+	mean := make([]float64, dimensions)
+	std := make([]float64, dimensions)
+	for d := 0; d < dimensions; d++ {
+		var m, m2 float64
+		for i, p := range points {
+			delta := p.Values[d] - m
+			m += delta / float64(i+1)
+			m2 += delta * (p.Values[d] - m)
+		}
+		mean[d] = m
+		if len(points) > 1 {
+			std[d] = math.Sqrt(m2 / float64(len(points)-1))
+		}
+	}
+
+	r := converter.MultivariateResult{
+		Timestamps:    make([]time.Time, 0, len(points)),
+		Score:         make([]float64, 0, len(points)),
+		Anomaly:       make([]float64, 0, len(points)),
+		Contributions: make([][]float64, dimensions),
+	}
+	squares := make([]float64, dimensions)
+	for _, p := range points {
+		var sum float64
+		for d := 0; d < dimensions; d++ {
+			z := 0.0
+			if std[d] != 0 {
+				z = (p.Values[d] - mean[d]) / std[d]
+			}
+			squares[d] = z * z
+			sum += squares[d]
+		}
+		score := math.Sqrt(sum / float64(dimensions))
+		anomaly := 0.0
+		if score > builtinAnomalyThreshold {
+			anomaly = 1
+		}
+		r.Timestamps = append(r.Timestamps, time.UnixMilli(p.Timestamp))
+		r.Score = append(r.Score, score)
+		r.Anomaly = append(r.Anomaly, anomaly)
+		for d := 0; d < dimensions; d++ {
+			contribution := 0.0
+			if sum != 0 {
+				contribution = squares[d] / sum
+			}
+			r.Contributions[d] = append(r.Contributions[d], contribution)
+		}
+	}
+	return r
+}
@@ -0,0 +1,97 @@
+package algorithm
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func seriesFrame(labels data.Labels, start int64, values ...*float64) *data.Frame {
+	times := make([]time.Time, len(values))
+	for i := range values {
+		times[i] = time.UnixMilli(start + int64(i)*1000)
+	}
+	return data.NewFrame("", data.NewField("Time", nil, times), data.NewField("Value", labels, values))
+}
+
+func fp(v float64) *float64 { return &v }
+
+func TestJoinSeries(t *testing.T) {
+	cpu := &backend.DataResponse{Frames: data.Frames{
+		seriesFrame(data.Labels{"__name__": "cpu", "host": "a"}, 0, fp(1), fp(2), fp(3)),
+		seriesFrame(data.Labels{"__name__": "cpu", "host": "b"}, 0, fp(1), fp(2)),
+	}}
+	mem := &backend.DataResponse{Frames: data.Frames{
+		// 缺失点和多出的时间点都不参与联合
+		seriesFrame(data.Labels{"__name__": "mem", "host": "a"}, 1000, fp(20), nil, fp(40)),
+	}}
+	joint, err := joinSeries([]*backend.DataResponse{cpu, mem}, []string{"cpu", "mem"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(joint) != 1 {
+		t.Fatalf("got %d joint series, want 1 (host=b is missing in mem)", len(joint))
+	}
+	if got := joint[0].labels.String(); got != "host=a" {
+		t.Errorf("labels = %s, want host=a", got)
+	}
+	if len(joint[0].points) != 1 || joint[0].points[0].Timestamp != 1000 ||
+		joint[0].points[0].Values[0] != 2 || joint[0].points[0].Values[1] != 20 {
+		t.Errorf("points = %+v, want one point at 1000 with values [2 20]", joint[0].points)
+	}
+}
+
+func TestJoinSeriesLabelCollision(t *testing.T) {
+	// 去掉__name__后同一表达式的两条序列标签相同
+	cpu := &backend.DataResponse{Frames: data.Frames{
+		seriesFrame(data.Labels{"__name__": "cpu_user", "host": "a"}, 0, fp(1)),
+		seriesFrame(data.Labels{"__name__": "cpu_system", "host": "a"}, 0, fp(2)),
+	}}
+	mem := &backend.DataResponse{Frames: data.Frames{
+		seriesFrame(data.Labels{"__name__": "mem", "host": "a"}, 0, fp(3)),
+	}}
+	if _, err := joinSeries([]*backend.DataResponse{cpu, mem}, []string{"cpu", "mem"}); err == nil {
+		t.Fatal("expected an error for colliding labels")
+	}
+}
+
+func TestDetectMultivariate(t *testing.T) {
+	var points []MultivariatePoint
+	for i := 0; i < 50; i++ {
+		points = append(points, MultivariatePoint{Timestamp: int64(i) * 1000,
+			Values: []float64{float64(i % 2), 10 + float64(i%3)}})
+	}
+	points[40].Values = []float64{20, 11}
+	r := detectMultivariate(points, 2)
+	if len(r.Score) != len(points) || len(r.Anomaly) != len(points) || len(r.Contributions) != 2 {
+		t.Fatalf("unexpected result sizes: %d scores, %d anomalies, %d dimensions", len(r.Score),
+			len(r.Anomaly), len(r.Contributions))
+	}
+	for i, a := range r.Anomaly {
+		if want := i == 40; (a == 1) != want {
+			t.Errorf("anomaly[%d] = %v, want %v", i, a, want)
+		}
+	}
+	// 异常点主要由第一个维度贡献，贡献度之和为1
+	if c := r.Contributions[0][40]; c < 0.9 {
+		t.Errorf("contribution of dimension 0 at the anomaly = %v, want > 0.9", c)
+	}
+	for i := range points {
+		if sum := r.Contributions[0][i] + r.Contributions[1][i]; math.Abs(sum-1) > 1e-9 && sum != 0 {
+			t.Errorf("contributions at %d sum to %v", i, sum)
+		}
+	}
+}
+
+func TestDetectMultivariateConstantDimension(t *testing.T) {
+	points := []MultivariatePoint{{Timestamp: 0, Values: []float64{1, 5}}, {Timestamp: 1000, Values: []float64{1, 5}}}
+	r := detectMultivariate(points, 2)
+	for i, s := range r.Score {
+		if s != 0 || r.Anomaly[i] != 0 {
+			t.Errorf("point %d: score %v anomaly %v, want 0 for constant series", i, s, r.Anomaly[i])
+		}
+	}
+}
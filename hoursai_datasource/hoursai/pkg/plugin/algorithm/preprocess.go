@@ -0,0 +1,263 @@
+package algorithm
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util/converter"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// maxGridPoints 按step补齐缺失点时单条序列的点数上限，超过时只对齐不补点
+const maxGridPoints = 100000
+
+// samplePoint 预处理中的单个点，missing表示该时间点没有有效值
+type samplePoint struct {
+	ts      time.Time
+	value   float64
+	missing bool
+	// row 点在原frame中的行号
+	row int
+}
+
+// getMissingPolicy 查询配置优先，其次为数据源配置，默认丢弃缺失点
+func getMissingPolicy(q *models.Query) string {
+	if q.MissingPolicy != "" {
+		return q.MissingPolicy
+	}
+	policy, err := util.GetStringOptional(jsonDataMap(q), "missingPolicy")
+	if err != nil {
+		log.DefaultLogger.Error("Read missing policy error", "err", err)
+	}
+	if policy == "" {
+		return util.MissingDrop
+	}
+	return policy
+}
+
+// Preprocess 将序列对齐到step，并按缺失值策略处理null、NaN、Inf及缺失的时间点，处理方式记录在frame meta中
+func Preprocess(r *backend.DataResponse, q *models.Query) (*backend.DataResponse, error) {
+	policy := getMissingPolicy(q)
+	switch policy {
+	case util.MissingDrop, util.MissingLinear, util.MissingForwardFill, util.MissingMark:
+	default:
+		return nil, util.PluginError(backend.StatusBadRequest, fmt.Errorf("unknown missing value policy %s", policy))
+	}
+	// 以对齐后的查询开始时间为原点，与发送给prometheus的范围查询一致
+	var origin time.Time
+	if q.Step > 0 {
+		origin = q.TimeRange().Start
+	}
+	response := &backend.DataResponse{Error: r.Error, Status: r.Status}
+	for _, frame := range r.Frames {
+		if len(frame.Fields) < 2 || frame.Fields[0].Type().NonNullableType() != data.FieldTypeTime {
+			response.Frames = append(response.Frames, frame)
+			continue
+		}
+		points := alignPoints(frame, origin, q.Step)
+		missing := 0
+		for _, p := range points {
+			if p.missing {
+				missing++
+			}
+		}
+		response.Frames = append(response.Frames, newPreprocessedFrame(frame, fillMissing(points, policy), policy,
+			missing, q.Step))
+	}
+	return response, nil
+}
+
+// alignPoints 读取有效点并以origin为原点对齐到step，同一个step内保留最后一个点，中间缺失的step补为缺失点
+func alignPoints(frame *data.Frame, origin time.Time, step time.Duration) []samplePoint {
+	timeField, valueField := frame.Fields[0], frame.Fields[1]
+	points := make([]samplePoint, 0, timeField.Len())
+	for i := 0; i < timeField.Len(); i++ {
+		ts, ok := timeAt(timeField, i)
+		if !ok {
+			continue
+		}
+		if step > 0 {
+			ts = alignTime(ts, origin, step)
+		}
+		v, ok := valueAt(valueField, i)
+		points = append(points, samplePoint{ts: ts, value: v, missing: !ok})
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].ts.Before(points[j].ts) })
+
+	aligned := make([]samplePoint, 0, len(points))
+	for _, p := range points {
+		n := len(aligned)
+		if n > 0 && aligned[n-1].ts.Equal(p.ts) {
+			if !p.missing || aligned[n-1].missing {
+				aligned[n-1] = p
+			}
+			continue
+		}
+		aligned = append(aligned, p)
+	}
+	if step <= 0 || len(aligned) < 2 {
+		return aligned
+	}
+	first, last := aligned[0].ts, aligned[len(aligned)-1].ts
+	if int64(last.Sub(first)/step)+1 > maxGridPoints {
+		return aligned
+	}
+	grid := make([]samplePoint, 0, int(last.Sub(first)/step)+1)
+	j := 0
+	for ts := first; !ts.After(last); ts = ts.Add(step) {
+		if j < len(aligned) && aligned[j].ts.Equal(ts) {
+			grid = append(grid, aligned[j])
+			j++
+			continue
+		}
+		grid = append(grid, samplePoint{ts: ts, missing: true})
+	}
+	return grid
+}
+
+// fillMissing 按策略处理缺失点，无法插值或填充的首尾缺失点直接丢弃
+func fillMissing(points []samplePoint, policy string) []samplePoint {
+	if policy == util.MissingMark {
+		return points
+	}
+	result := make([]samplePoint, 0, len(points))
+	prev := -1
+	for i, p := range points {
+		if !p.missing {
+			result = append(result, p)
+			prev = i
+			continue
+		}
+		switch policy {
+		case util.MissingForwardFill:
+			if prev >= 0 {
+				result = append(result, samplePoint{ts: p.ts, value: points[prev].value})
+			}
+		case util.MissingLinear:
+			next := i + 1
+			for next < len(points) && points[next].missing {
+				next++
+			}
+			if prev < 0 || next >= len(points) {
+				continue
+			}
+			a, b := points[prev], points[next]
+			ratio := float64(p.ts.Sub(a.ts)) / float64(b.ts.Sub(a.ts))
+			result = append(result, samplePoint{ts: p.ts, value: a.value + (b.value-a.value)*ratio})
+		}
+	}
+	return result
+}
+
+func newPreprocessedFrame(frame *data.Frame, points []samplePoint, policy string, missing int,
+	step time.Duration) *data.Frame {
+	times := make([]time.Time, len(points))
+	for i, p := range points {
+		times[i] = p.ts
+	}
+	var valueField *data.Field
+	if policy == util.MissingMark {
+		values := make([]*float64, len(points))
+		for i := range points {
+			if !points[i].missing {
+				values[i] = &points[i].value
+			}
+		}
+		valueField = data.NewField(frame.Fields[1].Name, frame.Fields[1].Labels, values)
+	} else {
+		values := make([]float64, len(points))
+		for i, p := range points {
+			values[i] = p.value
+		}
+		valueField = data.NewField(frame.Fields[1].Name, frame.Fields[1].Labels, values)
+	}
+	valueField.Config = frame.Fields[1].Config
+	timeField := data.NewField(frame.Fields[0].Name, frame.Fields[0].Labels, times)
+	timeField.Config = frame.Fields[0].Config
+
+	newFrame := data.NewFrame(frame.Name, timeField, valueField)
+	newFrame.RefID = frame.RefID
+	newFrame.Meta = converter.WithCustomMeta(frame.Meta, map[string]string{
+		"missingPolicy": policy,
+		"missingPoints": strconv.Itoa(missing),
+		"alignedStep":   step.String(),
+	})
+	return newFrame
+}
+
+// preprocessMetaKeys 预处理写入frame meta的字段
+var preprocessMetaKeys = []string{"missingPolicy", "missingPoints", "alignedStep"}
+
+// withPreprocessMeta 预处理后的序列只发送给算法，将其缺失值处理信息按标签写回返回的原始序列和结果序列
+func withPreprocessMeta(response *backend.DataResponse, prepared *backend.DataResponse) *backend.DataResponse {
+	if response == nil || prepared == nil {
+		return response
+	}
+	metas := make(map[uint64]map[string]string, len(prepared.Frames))
+	for _, frame := range prepared.Frames {
+		if len(frame.Fields) < 2 || frame.Meta == nil {
+			continue
+		}
+		custom, ok := frame.Meta.Custom.(map[string]string)
+		if !ok {
+			continue
+		}
+		values := make(map[string]string, len(preprocessMetaKeys))
+		for _, k := range preprocessMetaKeys {
+			if v, ok := custom[k]; ok {
+				values[k] = v
+			}
+		}
+		metas[seriesID(frame.Fields[1].Labels)] = values
+	}
+	for _, frame := range response.Frames {
+		if !isTimeSeries(frame) {
+			continue
+		}
+		if values, ok := metas[seriesID(frame.Fields[1].Labels)]; ok && len(values) > 0 {
+			frame.Meta = converter.WithCustomMeta(frame.Meta, values)
+		}
+	}
+	return response
+}
+
+// alignTime 将时间向下对齐到origin加整数倍step，与prometheus范围查询的时间点一致
+func alignTime(ts time.Time, origin time.Time, step time.Duration) time.Time {
+	offset := ts.Sub(origin) % step
+	if offset < 0 {
+		offset += step
+	}
+	return ts.Add(-offset)
+}
+
+// timeAt 读取时间字段，兼容可空字段
+func timeAt(field *data.Field, i int) (time.Time, bool) {
+	v, ok := field.ConcreteAt(i)
+	if !ok {
+		return time.Time{}, false
+	}
+	ts, ok := v.(time.Time)
+	return ts, ok
+}
+
+// isTimeSeries frame是否为时间字段加数值字段的时序
+func isTimeSeries(frame *data.Frame) bool {
+	return len(frame.Fields) >= 2 && frame.Fields[0].Type().NonNullableType() == data.FieldTypeTime &&
+		frame.Fields[1].Type().Numeric()
+}
+
+// valueAt 读取数值字段，null、NaN和Inf均视为无效值
+func valueAt(field *data.Field, i int) (float64, bool) {
+	v, err := field.NullableFloatAt(i)
+	if err != nil || v == nil || math.IsNaN(*v) || math.IsInf(*v, 0) {
+		return 0, false
+	}
+	return *v, true
+}
@@ -2,44 +2,108 @@ package algorithm
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
 	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util/converter"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	jsoniter "github.com/json-iterator/go"
 	"net/http"
+	"runtime/debug"
 )
 
+// managerSource 错误信息中的下游服务名称
+const managerSource = "HoursAI manager"
+
+// ParseAlgorithmResponse 解析算法结果并按规则过滤异常点，failures为计算失败的序列，整体请求失败时通过err或r.Error返回
 func ParseAlgorithmResponse(res *http.Response, result *backend.DataResponse, responseType string,
-	metaInfos []map[string]string, series string) (*backend.DataResponse, error) {
+	metaInfos []map[string]string, series string, scene string,
+	rules converter.AnomalyRules) (r *backend.DataResponse, failures []converter.SeriesFailure, err error) {
 	defer func() {
 		if err := res.Body.Close(); err != nil {
 			log.DefaultLogger.Error("Failed to close response body", "err", err)
 		}
 	}()
 
-	var r *backend.DataResponse
-	iter := jsoniter.Parse(jsoniter.ConfigDefault, res.Body, 1024)
-	r = converter.ReadAlgorithmStyleResult(iter, result, responseType, metaInfos, series)
+	body, err := util.ReadJSONBody(res, managerSource)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 响应结构与预期不符时不能让插件进程崩溃
+	defer func() {
+		if p := recover(); p != nil {
+			log.DefaultLogger.Error("Parse algorithm response panic", "panic", p, "stack", string(debug.Stack()))
+			r, failures, err = nil, nil, util.DownstreamError(backend.StatusBadGateway,
+				fmt.Errorf("%s returned an unexpected response: %v; body starts with: %q", managerSource, p,
+					util.BodyPreview(body)))
+		}
+	}()
+
+	iter := jsoniter.ParseBytes(jsoniter.ConfigDefault, body)
+	r, failures = converter.ReadAlgorithmStyleResult(iter, result, responseType, metaInfos, series, scene, rules)
+	if err := util.CheckIterator(iter, body, managerSource); err != nil {
+		return nil, nil, err
+	}
 
+	if res.StatusCode >= http.StatusBadRequest {
+		// HTTP状态码比响应体中的业务码更可信
+		if r == nil {
+			r = &backend.DataResponse{}
+		}
+		var queryErr *util.QueryError
+		if errors.As(r.Error, &queryErr) {
+			queryErr.Status = util.StatusFromHTTP(res.StatusCode)
+		} else {
+			queryErr = util.DownstreamError(util.StatusFromHTTP(res.StatusCode),
+				fmt.Errorf("%s returned HTTP %d; body starts with: %q", managerSource, res.StatusCode,
+					util.BodyPreview(body)))
+		}
+		r.Error = queryErr
+		r.Status = queryErr.Status
+		return r, nil, nil
+	}
 	if r == nil {
-		return r, fmt.Errorf("received empty from response")
+		return r, nil, util.DownstreamError(backend.StatusBadGateway,
+			fmt.Errorf("%s response contains no data; body starts with: %q", managerSource, util.BodyPreview(body)))
 	}
-	return r, nil
+	return r, failures, nil
 }
 
-func ParseCoreResponse(res *http.Response, responseType string) ([]byte, error) {
+func ParseCoreResponse(res *http.Response, responseType string) (result []byte, err error) {
 	defer func() {
 		if err := res.Body.Close(); err != nil {
 			log.DefaultLogger.Error("Failed to close response body", "err", err)
 		}
 	}()
 
+	body, err := util.ReadJSONBody(res, managerSource)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			log.DefaultLogger.Error("Parse core response panic", "panic", p, "stack", string(debug.Stack()))
+			result, err = nil, util.DownstreamError(backend.StatusBadGateway,
+				fmt.Errorf("%s returned an unexpected response: %v; body starts with: %q", managerSource, p,
+					util.BodyPreview(body)))
+		}
+	}()
+
 	var r interface{}
-	iter := jsoniter.Parse(jsoniter.ConfigDefault, res.Body, 1024)
+	iter := jsoniter.ParseBytes(jsoniter.ConfigDefault, body)
 	r = converter.ReadCoreStyleResult(iter, responseType)
+	if err := util.CheckIterator(iter, body, managerSource); err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= http.StatusBadRequest {
+		core, _ := r.(converter.CoreResponse)
+		return nil, util.DownstreamError(util.StatusFromHTTP(res.StatusCode),
+			fmt.Errorf("%s returned HTTP %d: %s", managerSource, res.StatusCode, core.Message))
+	}
 
-	result, err := json.Marshal(r)
+	result, err = json.Marshal(r)
 	log.DefaultLogger.Info(string(result))
 	if err != nil {
 		return []byte(`{"msg": "algorithm list result to json error.", "status": "error"}`), err
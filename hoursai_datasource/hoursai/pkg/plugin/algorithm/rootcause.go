@@ -0,0 +1,284 @@
+package algorithm
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// defaultRootCauseLimit 根因排序默认返回的候选数
+	defaultRootCauseLimit = 20
+	// relatedShiftScale 相关指标在异常窗口内的变化达到该倍数的标准差时变化得分为1
+	relatedShiftScale = 3.0
+)
+
+var labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// RootCauseRequest 根因排序请求，时间单位均为秒
+type RootCauseRequest struct {
+	Expr         string   `json:"expr"`
+	Start        int64    `json:"start"`
+	End          int64    `json:"end"`
+	AnomalyStart int64    `json:"anomalyStart"`
+	AnomalyEnd   int64    `json:"anomalyEnd"`
+	Dimensions   []string `json:"dimensions"`
+	Step         int64    `json:"step"`
+	Limit        int      `json:"limit"`
+	// Related 其他指标的表达式，排序与整体序列在异常窗口同时变化的序列
+	Related []string `json:"related"`
+}
+
+// RootCauseCandidate 某个标签维度取值对异常的贡献
+type RootCauseCandidate struct {
+	Dimension    string            `json:"dimension"`
+	Labels       map[string]string `json:"labels"`
+	Correlation  float64           `json:"correlation"`
+	Contribution float64           `json:"contribution"`
+	Score        float64           `json:"score"`
+	// Expr 相关指标的表达式，按维度排序的候选为空
+	Expr string `json:"expr,omitempty"`
+	// Shift 相关指标异常窗口内均值相对窗口外的变化，以窗口外的标准差为单位
+	Shift float64 `json:"shift,omitempty"`
+}
+
+// Validate 检查请求参数，并补齐默认值
+func (r *RootCauseRequest) Validate() error {
+	if r.Expr == "" {
+		return fmt.Errorf("expr is required")
+	}
+	if r.Start >= r.End {
+		return fmt.Errorf("start must be before end")
+	}
+	if r.AnomalyStart >= r.AnomalyEnd || r.AnomalyStart < r.Start || r.AnomalyEnd > r.End {
+		return fmt.Errorf("anomaly window must be a non-empty range inside [start, end]")
+	}
+	if len(r.Dimensions) == 0 {
+		return fmt.Errorf("at least one dimension is required")
+	}
+	for _, dimension := range r.Dimensions {
+		for _, label := range strings.Split(dimension, ",") {
+			if !labelNameRegexp.MatchString(strings.TrimSpace(label)) {
+				return fmt.Errorf("invalid label name %q in dimension %q", label, dimension)
+			}
+		}
+	}
+	for _, expr := range r.Related {
+		if strings.TrimSpace(expr) == "" {
+			return fmt.Errorf("related expressions must not be empty")
+		}
+	}
+	if r.Limit <= 0 {
+		r.Limit = defaultRootCauseLimit
+	}
+	return nil
+}
+
+// TotalExpr 汇总后的整体序列
+func (r *RootCauseRequest) TotalExpr() string {
+	return fmt.Sprintf("sum(%s)", r.Expr)
+}
+
+// DimensionExpr 按维度聚合的候选序列
+func (r *RootCauseRequest) DimensionExpr(dimension string) string {
+	return fmt.Sprintf("sum by (%s) (%s)", dimension, r.Expr)
+}
+
+// RankRootCause 计算每条候选序列与整体序列的相关系数，以及异常窗口内相对基线变化量的占比，按两者乘积排序
+func RankRootCause(total *backend.DataResponse, candidates map[string]*backend.DataResponse,
+	r *RootCauseRequest) []RootCauseCandidate {
+	totalValues := frameValues(total)
+	if len(totalValues) == 0 {
+		return nil
+	}
+	anomalyStart := time.Unix(r.AnomalyStart, 0).UnixMilli()
+	anomalyEnd := time.Unix(r.AnomalyEnd, 0).UnixMilli()
+	totalShift := windowShift(totalValues, anomalyStart, anomalyEnd)
+
+	var result []RootCauseCandidate
+	for _, dimension := range r.Dimensions {
+		response, ok := candidates[dimension]
+		if !ok || response == nil {
+			continue
+		}
+		for _, frame := range response.Frames {
+			values := seriesValues(frame)
+			if len(values) == 0 {
+				continue
+			}
+			contribution := 0.0
+			if totalShift != 0 {
+				contribution = windowShift(values, anomalyStart, anomalyEnd) / totalShift
+			}
+			correlation := pearson(values, totalValues)
+			result = append(result, RootCauseCandidate{
+				Dimension:    dimension,
+				Labels:       frame.Fields[1].Labels.Copy(),
+				Correlation:  correlation,
+				Contribution: contribution,
+				Score:        math.Abs(correlation) * math.Abs(contribution),
+			})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Score > result[j].Score
+	})
+	if len(result) > r.Limit {
+		result = result[:r.Limit]
+	}
+	return result
+}
+
+// RankRelated 计算相关指标的每条序列与整体序列的相关系数，以及异常窗口内以标准差为单位的变化，按两者乘积排序
+func RankRelated(total *backend.DataResponse, related map[string]*backend.DataResponse,
+	r *RootCauseRequest) []RootCauseCandidate {
+	totalValues := frameValues(total)
+	if len(totalValues) == 0 {
+		return nil
+	}
+	anomalyStart := time.Unix(r.AnomalyStart, 0).UnixMilli()
+	anomalyEnd := time.Unix(r.AnomalyEnd, 0).UnixMilli()
+
+	var result []RootCauseCandidate
+	for _, expr := range r.Related {
+		response, ok := related[expr]
+		if !ok || response == nil {
+			continue
+		}
+		for _, frame := range response.Frames {
+			values := seriesValues(frame)
+			if len(values) == 0 {
+				continue
+			}
+			shift := standardShift(values, anomalyStart, anomalyEnd)
+			correlation := pearson(values, totalValues)
+			result = append(result, RootCauseCandidate{
+				Expr:        expr,
+				Labels:      frame.Fields[1].Labels.Copy(),
+				Correlation: correlation,
+				Shift:       shift,
+				Score:       math.Abs(correlation) * math.Min(math.Abs(shift)/relatedShiftScale, 1),
+			})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Score > result[j].Score
+	})
+	if len(result) > r.Limit {
+		result = result[:r.Limit]
+	}
+	return result
+}
+
+// frameValues 取response中第一条时序的值
+func frameValues(r *backend.DataResponse) map[int64]float64 {
+	if r == nil {
+		return nil
+	}
+	for _, frame := range r.Frames {
+		if values := seriesValues(frame); len(values) > 0 {
+			return values
+		}
+	}
+	return nil
+}
+
+// seriesValues 时序的有效值，值字段可以是可空或整数等数值类型
+func seriesValues(frame *data.Frame) map[int64]float64 {
+	if !isTimeSeries(frame) {
+		return nil
+	}
+	values := make(map[int64]float64, frame.Fields[0].Len())
+	for i := 0; i < frame.Fields[0].Len(); i++ {
+		ts, ok := timeAt(frame.Fields[0], i)
+		if !ok {
+			continue
+		}
+		// NaN和Inf会使相关系数失效，直接跳过
+		if v, ok := valueAt(frame.Fields[1], i); ok {
+			values[ts.UnixMilli()] = v
+		}
+	}
+	return values
+}
+
+// windowShift 异常窗口内均值相对窗口外均值的变化量
+func windowShift(values map[int64]float64, start, end int64) float64 {
+	var inSum, outSum float64
+	var inCount, outCount int
+	for ts, v := range values {
+		if ts >= start && ts <= end {
+			inSum += v
+			inCount++
+		} else {
+			outSum += v
+			outCount++
+		}
+	}
+	if inCount == 0 || outCount == 0 {
+		return 0
+	}
+	return inSum/float64(inCount) - outSum/float64(outCount)
+}
+
+// standardShift 异常窗口内均值相对窗口外均值的变化量除以窗口外的标准差，窗口外没有波动时为0
+func standardShift(values map[int64]float64, start, end int64) float64 {
+	var outside []float64
+	for ts, v := range values {
+		if ts < start || ts > end {
+			outside = append(outside, v)
+		}
+	}
+	if len(outside) < 2 {
+		return 0
+	}
+	var mean, m2 float64
+	for i, v := range outside {
+		delta := v - mean
+		mean += delta / float64(i+1)
+		m2 += delta * (v - mean)
+	}
+	std := math.Sqrt(m2 / float64(len(outside)-1))
+	if std == 0 {
+		return 0
+	}
+	return windowShift(values, start, end) / std
+}
+
+// pearson 两条序列在公共时间点上的皮尔逊相关系数
+func pearson(a, b map[int64]float64) float64 {
+	var xs, ys []float64
+	for ts, x := range a {
+		if y, ok := b[ts]; ok {
+			xs = append(xs, x)
+			ys = append(ys, y)
+		}
+	}
+	n := float64(len(xs))
+	if n < 2 {
+		return 0
+	}
+	var sumX, sumY float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+	var cov, varX, varY float64
+	for i := range xs {
+		dx, dy := xs[i]-meanX, ys[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(varX*varY)
+}
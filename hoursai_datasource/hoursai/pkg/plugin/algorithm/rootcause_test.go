@@ -0,0 +1,114 @@
+package algorithm
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func floatFrame(labels data.Labels, values ...float64) *data.Frame {
+	times := make([]time.Time, len(values))
+	for i := range values {
+		times[i] = time.Unix(int64(i*60), 0)
+	}
+	return data.NewFrame("", data.NewField("Time", nil, times), data.NewField("Value", labels, values))
+}
+
+func TestRankRootCause(t *testing.T) {
+	// 异常窗口为第6到第8个点，整体的上升全部来自region=us，其中约一半来自host=h1
+	total := &backend.DataResponse{Frames: data.Frames{
+		floatFrame(nil, 20, 21, 20, 21, 20, 21, 60, 62, 61, 20, 21),
+	}}
+	// 可空值字段中的null点被跳过，不影响排序
+	nullable := data.NewFrame("",
+		floatFrame(nil, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0).Fields[0],
+		data.NewField("Value", data.Labels{"host": "h1"}, []*float64{
+			fp(10), fp(10.5), nil, fp(10.5), fp(10), fp(10.5), fp(30), fp(31), fp(30.5), fp(10), nil}))
+	candidates := map[string]*backend.DataResponse{
+		"region": {Frames: data.Frames{
+			floatFrame(data.Labels{"region": "eu"}, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10),
+			floatFrame(data.Labels{"region": "us"}, 10, 11, 10, 11, 10, 11, 50, 52, 51, 10, 11),
+		}},
+		"host": {Frames: data.Frames{
+			nullable,
+			floatFrame(data.Labels{"host": "h2"}, 10, 10.5, 10, 10.5, 10, 10.5, 10, 10.5, 10, 10, 10.5),
+		}},
+	}
+	req := &RootCauseRequest{AnomalyStart: 360, AnomalyEnd: 480, Dimensions: []string{"region", "host"}, Limit: 10}
+	result := RankRootCause(total, candidates, req)
+	if len(result) != 4 {
+		t.Fatalf("got %d candidates, want 4: %+v", len(result), result)
+	}
+	want := []string{"region=us", "host=h1"}
+	for i, w := range want {
+		c := result[i]
+		if got := c.Dimension + "=" + c.Labels[c.Dimension]; got != w {
+			t.Fatalf("candidate %d = %s, want %s: %+v", i, got, w, result)
+		}
+	}
+	if c := result[0]; c.Contribution < 0.95 || c.Correlation < 0.95 {
+		t.Errorf("region=us contribution %v correlation %v, want the main driver", c.Contribution, c.Correlation)
+	}
+	if c := result[1]; c.Contribution < 0.4 || c.Contribution > 0.6 {
+		t.Errorf("host=h1 contribution %v, want about half of the shift", c.Contribution)
+	}
+	for _, c := range result[2:] {
+		if c.Score >= result[1].Score || math.Abs(c.Contribution) > 0.1 {
+			t.Errorf("%s %v scored %v with contribution %v, want below the drivers", c.Dimension, c.Labels,
+				c.Score, c.Contribution)
+		}
+	}
+	req.Limit = 1
+	if got := RankRootCause(total, candidates, req); len(got) != 1 || got[0].Labels["region"] != "us" {
+		t.Errorf("limit 1 returned %+v, want region=us", got)
+	}
+}
+
+func TestRankRelated(t *testing.T) {
+	// 异常窗口为第6到第8个点
+	total := &backend.DataResponse{Frames: data.Frames{
+		floatFrame(nil, 10, 11, 10, 11, 10, 11, 30, 32, 31, 10, 11),
+	}}
+	related := map[string]*backend.DataResponse{
+		"latency": {Frames: data.Frames{
+			floatFrame(data.Labels{"svc": "api"}, 1, 1.1, 1, 1.1, 1, 1.1, 5, 5.2, 5.1, 1, 1.1),
+			floatFrame(data.Labels{"svc": "db"}, 1, 1.2, 1.1, 1, 1.2, 1.1, 1, 1.2, 1.1, 1, 1.2),
+		}},
+		"flat": {Frames: data.Frames{floatFrame(data.Labels{"svc": "idle"}, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2)}},
+	}
+	req := &RootCauseRequest{AnomalyStart: 360, AnomalyEnd: 480, Related: []string{"latency", "flat"}, Limit: 10}
+	result := RankRelated(total, related, req)
+	if len(result) != 3 {
+		t.Fatalf("got %d related series, want 3", len(result))
+	}
+	if result[0].Expr != "latency" || result[0].Labels["svc"] != "api" {
+		t.Errorf("top related series = %s %v, want latency svc=api", result[0].Expr, result[0].Labels)
+	}
+	if result[0].Score < 0.9 || result[0].Shift < relatedShiftScale {
+		t.Errorf("top related series score %v shift %v, want a strong co-movement", result[0].Score,
+			result[0].Shift)
+	}
+	for _, c := range result[1:] {
+		if c.Score >= result[0].Score {
+			t.Errorf("%s %v scored %v, not below the co-moving series", c.Expr, c.Labels, c.Score)
+		}
+	}
+	req.Limit = 1
+	if got := RankRelated(total, related, req); len(got) != 1 {
+		t.Errorf("limit 1 returned %d series", len(got))
+	}
+}
+
+func TestStandardShift(t *testing.T) {
+	values := map[int64]float64{0: 1, 1: 3, 2: 1, 3: 3, 4: 12}
+	// 窗口外均值2，标准差约1.155，窗口内12
+	if got := standardShift(values, 4, 4); got < 8.6 || got > 8.7 {
+		t.Errorf("standardShift = %v, want about 8.66", got)
+	}
+	if got := standardShift(map[int64]float64{0: 1, 1: 1, 2: 5}, 2, 2); got != 0 {
+		t.Errorf("standardShift without variance outside the window = %v, want 0", got)
+	}
+}
@@ -0,0 +1,58 @@
+package algorithm
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/intervalv2"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util/converter"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// getAnomalyRules 查询配置优先，其次为数据源配置，默认不过滤异常点，严重级别阈值按engine的默认值补齐
+func getAnomalyRules(q *models.Query, engine string) (converter.AnomalyRules, error) {
+	rules := converter.AnomalyRules{
+		MinConsecutive:  q.MinConsecutive,
+		MinSignificance: q.MinSignificance,
+		Direction:       q.Direction,
+		Calendar:        q.Calendar,
+	}
+	jsonData := jsonDataMap(q)
+	var err error
+	if rules.MinConsecutive <= 0 {
+		if rules.MinConsecutive, err = util.GetInt64Optional(jsonData, "minConsecutive"); err != nil {
+			log.DefaultLogger.Error("Read min consecutive error", "err", err)
+		}
+	}
+	if rules.MinSignificance <= 0 {
+		if rules.MinSignificance, err = util.GetFloat64Optional(jsonData, "minSignificance"); err != nil {
+			log.DefaultLogger.Error("Read min significance error", "err", err)
+		}
+	}
+	cooldown := q.Cooldown
+	if cooldown == "" {
+		if cooldown, err = util.GetStringOptional(jsonData, "cooldown"); err != nil {
+			log.DefaultLogger.Error("Read cooldown error", "err", err)
+		}
+	}
+	if cooldown != "" {
+		if rules.Cooldown, err = intervalv2.ParseIntervalStringToTimeDuration(cooldown); err != nil {
+			return rules, util.PluginError(backend.StatusBadRequest, fmt.Errorf("invalid cooldown %q", cooldown))
+		}
+	}
+	if rules.Direction == "" {
+		if rules.Direction, err = util.GetStringOptional(jsonData, "anomalyDirection"); err != nil {
+			log.DefaultLogger.Error("Read anomaly direction error", "err", err)
+		}
+	}
+	switch rules.Direction {
+	case "", util.DirectionBoth, util.DirectionAbove, util.DirectionBelow:
+	default:
+		return rules, util.PluginError(backend.StatusBadRequest, fmt.Errorf("unknown anomaly direction %s, "+
+			"expected %s, %s or %s", rules.Direction, util.DirectionBoth, util.DirectionAbove, util.DirectionBelow))
+	}
+	rules.Severity, err = getSeverityTiers(q, engine)
+	return rules, err
+}
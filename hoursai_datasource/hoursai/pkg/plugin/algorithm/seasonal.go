@@ -0,0 +1,318 @@
+package algorithm
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/intervalv2"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util/converter"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// defaultSeasonalOffset 未配置偏移时与上周同一时间比较
+	defaultSeasonalOffset = "7d"
+	// defaultSeasonalThreshold 偏离历史基线超过该比例时标记为异常
+	defaultSeasonalThreshold = 0.3
+	day                      = 24 * time.Hour
+)
+
+// SeasonalOffset 历史基线相对当前序列的偏移，整天的偏移按查询时区的日期计算，夏令时切换前后仍比较当地的同一时刻
+type SeasonalOffset struct {
+	Name     string
+	Duration time.Duration
+}
+
+func (o SeasonalOffset) days() int {
+	if o.Duration%day != 0 {
+		return 0
+	}
+	return int(o.Duration / day)
+}
+
+// shift 当前时刻对应的历史时刻
+func (o SeasonalOffset) shift(t time.Time, loc *time.Location) time.Time {
+	if days := o.days(); days > 0 {
+		return t.In(loc).AddDate(0, 0, -days)
+	}
+	return t.Add(-o.Duration)
+}
+
+// align 历史时刻对齐到当前序列上的时刻
+func (o SeasonalOffset) align(t time.Time, loc *time.Location) time.Time {
+	if days := o.days(); days > 0 {
+		return t.In(loc).AddDate(0, 0, days)
+	}
+	return t.Add(o.Duration)
+}
+
+// GetSeasonalOffsets 查询配置优先，其次为数据源配置，默认与上周同一时间比较
+func GetSeasonalOffsets(q *models.Query) ([]SeasonalOffset, error) {
+	names := q.SeasonalOffsets
+	if len(names) == 0 {
+		var err error
+		if names, err = util.GetStringList(jsonDataMap(q), "seasonalOffsets"); err != nil {
+			log.DefaultLogger.Error("Read seasonal offsets error", "err", err)
+		}
+	}
+	if len(names) == 0 {
+		names = []string{defaultSeasonalOffset}
+	}
+	offsets := make([]SeasonalOffset, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		d, err := intervalv2.ParseIntervalStringToTimeDuration(name)
+		if err != nil || d <= 0 {
+			return nil, util.PluginError(backend.StatusBadRequest, fmt.Errorf("invalid seasonal offset %q", name))
+		}
+		offsets = append(offsets, SeasonalOffset{Name: name, Duration: d})
+	}
+	return offsets, nil
+}
+
+// getSeasonalThreshold 查询配置优先，其次为数据源配置
+func getSeasonalThreshold(q *models.Query) (float64, error) {
+	threshold := q.SeasonalThreshold
+	if threshold == 0 {
+		var err error
+		if threshold, err = util.GetFloat64Optional(jsonDataMap(q), "seasonalThreshold"); err != nil {
+			log.DefaultLogger.Error("Read seasonal threshold error", "err", err)
+		}
+	}
+	if threshold == 0 {
+		return defaultSeasonalThreshold, nil
+	}
+	if threshold < 0 || math.IsNaN(threshold) {
+		return 0, util.PluginError(backend.StatusBadRequest, fmt.Errorf("invalid seasonal threshold %v", threshold))
+	}
+	return threshold, nil
+}
+
+// ShiftQuery 查询偏移后的历史区间，只做范围查询
+func ShiftQuery(q *models.Query, o SeasonalOffset) *models.Query {
+	shifted := *q
+	loc := q.Location()
+	shifted.Start = o.shift(q.Start, loc)
+	shifted.End = o.shift(q.End, loc)
+	shifted.InstantQuery = false
+	shifted.RangeQuery = true
+	shifted.ExemplarQuery = false
+	return &shifted
+}
+
+// historySeries 一个偏移的历史序列，时间已对齐到当前序列
+type historySeries struct {
+	times  []int64
+	values []float64
+}
+
+// at 查找与ts相差不超过tolerance的最近点，偏移不是step整数倍时历史点与当前点不重合
+func (h *historySeries) at(ts int64, tolerance int64) (float64, bool) {
+	if h == nil || len(h.times) == 0 {
+		return 0, false
+	}
+	i := sort.Search(len(h.times), func(i int) bool { return h.times[i] >= ts })
+	best, found := int64(math.MaxInt64), -1
+	for _, j := range []int{i - 1, i} {
+		if j < 0 || j >= len(h.times) {
+			continue
+		}
+		if d := absInt64(h.times[j] - ts); d <= tolerance && d < best {
+			best, found = d, j
+		}
+	}
+	if found < 0 {
+		return 0, false
+	}
+	return h.values[found], true
+}
+
+func absInt64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// historyByLabels 按去掉__name__后的标签索引历史序列
+func historyByLabels(r *backend.DataResponse, o SeasonalOffset, loc *time.Location) map[string]*historySeries {
+	result := make(map[string]*historySeries)
+	if r == nil {
+		return result
+	}
+	for _, frame := range r.Frames {
+		if !isTimeSeries(frame) {
+			continue
+		}
+		h := &historySeries{}
+		for i := 0; i < frame.Fields[0].Len(); i++ {
+			ts, ok := timeAt(frame.Fields[0], i)
+			if !ok {
+				continue
+			}
+			if v, ok := valueAt(frame.Fields[1], i); ok {
+				h.times = append(h.times, o.align(ts, loc).UnixMilli())
+				h.values = append(h.values, v)
+			}
+		}
+		sort.Sort(h)
+		result[seriesKey(frame)] = h
+	}
+	return result
+}
+
+func (h *historySeries) Len() int           { return len(h.times) }
+func (h *historySeries) Less(i, j int) bool { return h.times[i] < h.times[j] }
+func (h *historySeries) Swap(i, j int) {
+	h.times[i], h.times[j] = h.times[j], h.times[i]
+	h.values[i], h.values[j] = h.values[j], h.values[i]
+}
+
+func seriesKey(frame *data.Frame) string {
+	labels := frame.Fields[1].Labels.Copy()
+	delete(labels, "__name__")
+	return labels.String()
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// SeasonalBaseline 本地同比：各偏移的历史值取中位数作为基线，偏离基线的比例超过阈值时标记为异常，不调用manager。
+// history与offsets一一对应
+func SeasonalBaseline(current *backend.DataResponse, history []*backend.DataResponse, offsets []SeasonalOffset,
+	q *models.Query) (*backend.DataResponse, error) {
+	threshold, err := getSeasonalThreshold(q)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := getAnomalyRules(q, util.SeasonalType)
+	if err != nil {
+		return nil, err
+	}
+	loc := q.Location()
+	indexes := make([]map[string]*historySeries, len(offsets))
+	for i, o := range offsets {
+		indexes[i] = historyByLabels(history[i], o, loc)
+	}
+	names := make([]string, len(offsets))
+	for i, o := range offsets {
+		names[i] = o.Name
+	}
+	// 历史点与当前点的时间最多相差半个step
+	tolerance := q.Step.Milliseconds() / 2
+
+	var frames data.Frames
+	matched := false
+	for _, frame := range current.Frames {
+		if !isTimeSeries(frame) {
+			continue
+		}
+		key := seriesKey(frame)
+		series := make([]*historySeries, 0, len(indexes))
+		for _, index := range indexes {
+			if h, ok := index[key]; ok {
+				series = append(series, h)
+			}
+		}
+		matched = matched || len(series) > 0
+		result := seasonalFrames(frame, series, threshold, tolerance, q)
+		result[0].Meta = converter.WithCustomMeta(result[0].Meta, map[string]string{
+			"seasonalOffsets":   strings.Join(names, ","),
+			"seasonalThreshold": strconv.FormatFloat(threshold, 'f', -1, 64),
+		})
+		byName := map[string]*data.Frame{"value": frame}
+		for _, f := range result {
+			byName[f.Name] = f
+		}
+		rules.ApplyFrames(byName, q.Series != "")
+		for _, f := range result {
+			if q.Series == "" || f.Name == q.Series {
+				frames = append(frames, f)
+			}
+		}
+	}
+	response := assembleResponse(current, frames, q)
+	if !matched && len(frames) > 0 {
+		response.Frames[0].Meta = converter.CopyFrameMeta(response.Frames[0].Meta)
+		response.Frames[0].AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("No historical data found at offsets %s.", strings.Join(names, ", ")),
+		})
+	}
+	return response, nil
+}
+
+// seasonalFrames 单条序列的基线、上下界、偏离比例和异常标记，没有历史值或基线为0的点基线和偏离比例为null
+func seasonalFrames(frame *data.Frame, series []*historySeries, threshold float64, tolerance int64,
+	q *models.Query) data.Frames {
+	timeField, valueField := frame.Fields[0], frame.Fields[1]
+	n := timeField.Len()
+	var (
+		times     = make([]time.Time, n)
+		baseline  = make([]*float64, n)
+		upper     = make([]*float64, n)
+		lower     = make([]*float64, n)
+		deviation = make([]*float64, n)
+		anomaly   = make([]float64, n)
+	)
+	values := make([]float64, 0, len(series))
+	for i := 0; i < n; i++ {
+		ts, ok := timeAt(timeField, i)
+		if !ok {
+			continue
+		}
+		times[i] = ts
+		values = values[:0]
+		for _, h := range series {
+			if v, ok := h.at(ts.UnixMilli(), tolerance); ok {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		b := median(values)
+		band := threshold * math.Abs(b)
+		u, l := b+band, b-band
+		baseline[i], upper[i], lower[i] = &b, &u, &l
+		v, ok := valueAt(valueField, i)
+		if !ok || b == 0 {
+			continue
+		}
+		d := (v - b) / math.Abs(b)
+		deviation[i] = &d
+		if math.Abs(d) >= threshold {
+			anomaly[i] = 1
+		}
+	}
+
+	labels := valueField.Labels.Copy()
+	interval := float64(q.Step.Milliseconds())
+	newFrame := func(name string, values interface{}) *data.Frame {
+		tf := data.NewField(data.TimeSeriesTimeFieldName, nil, times)
+		tf.Config = &data.FieldConfig{Interval: interval}
+		return data.NewFrame(name, tf, data.NewField(data.TimeSeriesValueFieldName, labels, values))
+	}
+	return data.Frames{
+		newFrame("baseline", baseline),
+		newFrame("upper", upper),
+		newFrame("lower", lower),
+		newFrame("deviation", deviation),
+		newFrame("anomaly", anomaly),
+	}
+}
@@ -0,0 +1,170 @@
+package algorithm
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestSeasonalOffsetShiftAlign(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("timezone data not available:", err)
+	}
+	local := func(s string) time.Time {
+		ts, err := time.ParseInLocation("2006-01-02 15:04", s, newYork)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	tests := []struct {
+		name   string
+		offset SeasonalOffset
+		t      time.Time
+		want   time.Time
+	}{
+		{name: "one day across DST start keeps local time", offset: SeasonalOffset{Name: "1d", Duration: day},
+			t: local("2022-03-13 12:00"), want: local("2022-03-12 12:00")},
+		{name: "one week across DST end keeps local time", offset: SeasonalOffset{Name: "7d", Duration: 7 * day},
+			t: local("2022-11-10 09:00"), want: local("2022-11-03 09:00")},
+		{name: "partial days are elapsed time", offset: SeasonalOffset{Name: "36h", Duration: 36 * time.Hour},
+			t: local("2022-03-13 12:00"), want: local("2022-03-13 12:00").Add(-36 * time.Hour)},
+		{name: "hours are elapsed time", offset: SeasonalOffset{Name: "1h", Duration: time.Hour},
+			t: local("2022-11-06 02:00"), want: local("2022-11-06 02:00").Add(-time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shifted := tt.offset.shift(tt.t, newYork)
+			if !shifted.Equal(tt.want) {
+				t.Errorf("shift = %s, want %s", shifted.In(newYork), tt.want)
+			}
+			if aligned := tt.offset.align(shifted, newYork); !aligned.Equal(tt.t) {
+				t.Errorf("align = %s, want %s", aligned.In(newYork), tt.t)
+			}
+		})
+	}
+}
+
+func TestHistorySeriesAt(t *testing.T) {
+	h := &historySeries{times: []int64{0, 60000, 120000}, values: []float64{1, 2, 3}}
+	tests := []struct {
+		name  string
+		h     *historySeries
+		ts    int64
+		want  float64
+		found bool
+	}{
+		{name: "exact", h: h, ts: 60000, want: 2, found: true},
+		{name: "nearest before", h: h, ts: 70000, want: 2, found: true},
+		{name: "nearest after", h: h, ts: 110000, want: 3, found: true},
+		{name: "at the tolerance", h: h, ts: 150000, want: 3, found: true},
+		{name: "beyond the tolerance", h: h, ts: 150001},
+		{name: "before the first point", h: h, ts: -30001},
+		{name: "equal distance takes the earlier point", h: h, ts: 30000, want: 1, found: true},
+		{name: "empty", h: &historySeries{}, ts: 0},
+		{name: "nil", ts: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, ok := tt.h.at(tt.ts, 30000)
+			if ok != tt.found || v != tt.want {
+				t.Errorf("at(%d) = %v, %v, want %v, %v", tt.ts, v, ok, tt.want, tt.found)
+			}
+		})
+	}
+}
+
+// shiftedFrame 每分钟一个点的序列，时间整体前移offset
+func shiftedFrame(labels data.Labels, offset time.Duration, values ...float64) *data.Frame {
+	frame := floatFrame(labels, values...)
+	times := make([]time.Time, len(values))
+	for i := range times {
+		times[i] = frame.Fields[0].At(i).(time.Time).Add(-offset)
+	}
+	frame.Fields[0] = data.NewField("Time", nil, times)
+	return frame
+}
+
+// frameByName 按结果名和host标签查找frame
+func frameByName(frames data.Frames, name, host string) *data.Frame {
+	for _, frame := range frames {
+		if frame.Name == name && frame.Fields[1].Labels["host"] == host {
+			return frame
+		}
+	}
+	return nil
+}
+
+func TestSeasonalBaseline(t *testing.T) {
+	hostA := data.Labels{"__name__": "requests", "host": "a"}
+	hostB := data.Labels{"__name__": "requests", "host": "b"}
+	current := &backend.DataResponse{Frames: data.Frames{
+		floatFrame(hostA, 10, 10, 30, 10),
+		floatFrame(hostB, 10, 10),
+	}}
+	offsets := []SeasonalOffset{{Name: "1d", Duration: day}, {Name: "2d", Duration: 2 * day}}
+	history := []*backend.DataResponse{
+		// 历史序列的指标名可以不同，只按其它标签匹配
+		{Frames: data.Frames{shiftedFrame(data.Labels{"__name__": "old", "host": "a"}, day, 10, 12, 10)}},
+		// 第二个偏移的点与当前点相差20s，在半个step内
+		{Frames: data.Frames{shiftedFrame(hostA, 2*day-20*time.Second, 10, 8, 10, 10)}},
+	}
+	q := &models.Query{Step: time.Minute, SeasonalThreshold: 0.5, JsonData: json.RawMessage(`{}`)}
+	r, err := SeasonalBaseline(current, history, offsets, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	baseline := frameByName(r.Frames, "baseline", "a")
+	anomaly := frameByName(r.Frames, "anomaly", "a")
+	deviation := frameByName(r.Frames, "deviation", "a")
+	if baseline == nil || anomaly == nil || deviation == nil {
+		t.Fatalf("result frames are missing: %v", r.Frames)
+	}
+	// 基线为各偏移历史值的中位数，只有一个偏移有值时取该值
+	for i, want := range []float64{10, 10, 10, 10} {
+		if v := baseline.Fields[1].At(i).(*float64); v == nil || *v != want {
+			t.Errorf("baseline[%d] = %v, want %v", i, v, want)
+		}
+	}
+	for i, want := range []float64{0, 0, 1, 0} {
+		if got := anomaly.Fields[1].At(i).(float64); got != want {
+			t.Errorf("anomaly[%d] = %v, want %v", i, got, want)
+		}
+	}
+	if d := deviation.Fields[1].At(2).(*float64); d == nil || *d != 2 {
+		t.Errorf("deviation[2] = %v, want 2", d)
+	}
+	if baseline.Meta == nil || baseline.Meta.Custom.(map[string]string)["seasonalOffsets"] != "1d,2d" {
+		t.Error("offsets are not recorded in the frame meta")
+	}
+
+	// 没有历史数据的序列基线为null，不标记异常
+	if b := frameByName(r.Frames, "baseline", "b"); b == nil || b.Fields[1].At(0).(*float64) != nil {
+		t.Error("series without history should have a null baseline")
+	}
+	if a := frameByName(r.Frames, "anomaly", "b"); a == nil || a.Fields[1].At(0).(float64) != 0 {
+		t.Error("series without history should not be anomalous")
+	}
+	if r.Frames[0].Meta != nil && len(r.Frames[0].Meta.Notices) > 0 {
+		t.Errorf("unexpected notices: %v", r.Frames[0].Meta.Notices)
+	}
+}
+
+func TestSeasonalBaselineNoHistory(t *testing.T) {
+	current := &backend.DataResponse{Frames: data.Frames{floatFrame(data.Labels{"host": "a"}, 10, 10)}}
+	offsets := []SeasonalOffset{{Name: "7d", Duration: 7 * day}}
+	q := &models.Query{Step: time.Minute, JsonData: json.RawMessage(`{}`)}
+	r, err := SeasonalBaseline(current, []*backend.DataResponse{{}}, offsets, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Frames[0].Meta == nil || len(r.Frames[0].Meta.Notices) != 1 {
+		t.Error("expected a notice when no history is found")
+	}
+}
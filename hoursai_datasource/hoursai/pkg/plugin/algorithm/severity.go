@@ -0,0 +1,94 @@
+package algorithm
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// severityDefaultKey 数据源severityTiers配置中对所有算法生效的默认阈值
+const severityDefaultKey = "default"
+
+var (
+	// managerSeverityTiers manager算法的默认阈值，显著性在0到1之间
+	managerSeverityTiers = models.SeverityTiers{
+		Warning:  models.SeverityThreshold{Significance: 0.8, Deviation: 0.2},
+		Critical: models.SeverityThreshold{Significance: 0.95, Deviation: 0.5},
+	}
+	// defaultSeverityTiers 本地计算的默认阈值，内置引擎的异常点显著性都为1，本地同比没有显著性，都只按偏离比例分级
+	defaultSeverityTiers = map[string]models.SeverityTiers{
+		util.EngineBuiltin: {
+			Warning:  models.SeverityThreshold{Deviation: 0.2},
+			Critical: models.SeverityThreshold{Deviation: 0.5},
+		},
+		util.SeasonalType: {
+			Warning:  models.SeverityThreshold{Deviation: 0.5},
+			Critical: models.SeverityThreshold{Deviation: 1},
+		},
+	}
+)
+
+// getSeverityTiers 查询配置优先，其次为数据源按算法名配置的阈值和default；都未配置时只有查询或数据源开启
+// classifySeverity才使用各算法的默认值，否则返回nil不分级。engine为内置引擎或本地同比时按其名称查找
+func getSeverityTiers(q *models.Query, engine string) (*models.SeverityTiers, error) {
+	if !q.Severity.Empty() {
+		if err := q.Severity.Validate(); err != nil {
+			return nil, util.PluginError(backend.StatusBadRequest, err)
+		}
+		return q.Severity, nil
+	}
+	name := q.Name
+	if _, ok := defaultSeverityTiers[engine]; ok {
+		name = engine
+	}
+	jsonData := jsonDataMap(q)
+	configured, err := getConfiguredSeverityTiers(jsonData)
+	if err != nil {
+		log.DefaultLogger.Error("Read severity tiers error", "err", err)
+	}
+	for _, key := range []string{name, severityDefaultKey} {
+		if tiers, ok := configured[key]; ok && !tiers.Empty() {
+			return &tiers, nil
+		}
+	}
+	requested := q.ClassifySeverity
+	if !requested {
+		if requested, err = util.GetBoolOptional(jsonData, "classifySeverity"); err != nil {
+			log.DefaultLogger.Error("Read classify severity error", "err", err)
+		}
+	}
+	if !requested {
+		return nil, nil
+	}
+	if tiers, ok := defaultSeverityTiers[name]; ok {
+		return &tiers, nil
+	}
+	tiers := managerSeverityTiers
+	return &tiers, nil
+}
+
+// getConfiguredSeverityTiers 读取数据源配置的severityTiers，键为算法名或default
+func getConfiguredSeverityTiers(jsonData map[string]interface{}) (map[string]models.SeverityTiers, error) {
+	raw, ok := jsonData["severityTiers"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var configured map[string]models.SeverityTiers
+	if err := json.Unmarshal(b, &configured); err != nil {
+		return nil, fmt.Errorf("the field 'severityTiers' should map algorithm names to tiers: %w", err)
+	}
+	for name, tiers := range configured {
+		if err := tiers.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid severity tiers of %s: %w", name, err)
+		}
+	}
+	return configured, nil
+}
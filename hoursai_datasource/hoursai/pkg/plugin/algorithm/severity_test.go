@@ -0,0 +1,52 @@
+package algorithm
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+)
+
+func TestGetSeverityTiers(t *testing.T) {
+	queryTiers := &models.SeverityTiers{Warning: models.SeverityThreshold{Significance: 0.5}}
+	builtin := defaultSeverityTiers[util.EngineBuiltin]
+	tests := []struct {
+		name     string
+		q        models.Query
+		engine   string
+		want     *models.SeverityTiers
+		hasError bool
+	}{
+		{name: "not configured", q: models.Query{Name: "lstm", JsonData: json.RawMessage(`{}`)}},
+		{name: "tiers of another algorithm",
+			q: models.Query{Name: "lstm", JsonData: json.RawMessage(
+				`{"severityTiers":{"arima":{"warning":{"deviation":0.1}}}}`)}},
+		{name: "requested by the query",
+			q:    models.Query{Name: "lstm", ClassifySeverity: true, JsonData: json.RawMessage(`{}`)},
+			want: &managerSeverityTiers},
+		{name: "requested by the datasource",
+			q:      models.Query{Name: "lstm", JsonData: json.RawMessage(`{"classifySeverity":true}`)},
+			engine: util.EngineBuiltin, want: &builtin},
+		{name: "configured by the datasource",
+			q: models.Query{Name: "lstm", JsonData: json.RawMessage(
+				`{"severityTiers":{"default":{"critical":{"deviation":2}}}}`)},
+			want: &models.SeverityTiers{Critical: models.SeverityThreshold{Deviation: 2}}},
+		{name: "configured by the query", q: models.Query{Name: "lstm", Severity: queryTiers,
+			JsonData: json.RawMessage(`{"severityTiers":{"default":{"critical":{"deviation":2}}}}`)},
+			want: queryTiers},
+		{name: "negative threshold", q: models.Query{Name: "lstm", JsonData: json.RawMessage(`{}`),
+			Severity: &models.SeverityTiers{Warning: models.SeverityThreshold{Deviation: -1}}}, hasError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiers, err := getSeverityTiers(&tt.q, tt.engine)
+			if (err != nil) != tt.hasError {
+				t.Fatalf("err = %v, want error %v", err, tt.hasError)
+			}
+			if (tiers == nil) != (tt.want == nil) || (tiers != nil && *tiers != *tt.want) {
+				t.Errorf("tiers = %+v, want %+v", tiers, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package algorithm
+
+import (
+	"sort"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util/converter"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// seriesRank 同一条序列的原始数据及算法结果frame，以及用于排序的统计值
+type seriesRank struct {
+	key             string
+	frames          data.Frames
+	anomalies       int64
+	maxSignificance float64
+}
+
+// SelectTopK 按异常点数或最大显著性对序列排序，只保留前k条序列的frame，其余序列汇总到summary表格中
+func SelectTopK(r *backend.DataResponse, k int, by string) *backend.DataResponse {
+	if r == nil || k <= 0 {
+		return r
+	}
+	var (
+		ranks  []*seriesRank
+		lookup = make(map[string]*seriesRank)
+		others data.Frames
+	)
+	for _, frame := range r.Frames {
+		if len(frame.Fields) < 2 {
+			others = append(others, frame)
+			continue
+		}
+		// 实时结果的各frame以指标名区分（如anomaly_task_1），去掉__name__后同一序列的结果才能合并
+		labels := frame.Fields[1].Labels.Copy()
+		delete(labels, "__name__")
+		key := labels.String()
+		rank, ok := lookup[key]
+		if !ok {
+			rank = &seriesRank{key: key}
+			lookup[key] = rank
+			ranks = append(ranks, rank)
+		}
+		rank.frames = append(rank.frames, frame)
+
+		field := frame.Fields[1]
+		switch converter.ResultName(frame.Name) {
+		case "anomaly":
+			for i := 0; i < field.Len(); i++ {
+				if v, ok := field.ConcreteAt(i); ok && v.(float64) != 0 {
+					rank.anomalies++
+				}
+			}
+		case "significance":
+			for i := 0; i < field.Len(); i++ {
+				if v, ok := field.ConcreteAt(i); ok && v.(float64) > rank.maxSignificance {
+					rank.maxSignificance = v.(float64)
+				}
+			}
+		}
+	}
+	if len(ranks) <= k {
+		return r
+	}
+
+	sort.SliceStable(ranks, func(i, j int) bool {
+		if by == util.TopKBySignificance {
+			if ranks[i].maxSignificance != ranks[j].maxSignificance {
+				return ranks[i].maxSignificance > ranks[j].maxSignificance
+			}
+			return ranks[i].anomalies > ranks[j].anomalies
+		}
+		if ranks[i].anomalies != ranks[j].anomalies {
+			return ranks[i].anomalies > ranks[j].anomalies
+		}
+		return ranks[i].maxSignificance > ranks[j].maxSignificance
+	})
+
+	result := &backend.DataResponse{Error: r.Error, Status: r.Status}
+	for _, rank := range ranks[:k] {
+		result.Frames = append(result.Frames, rank.frames...)
+	}
+	result.Frames = append(result.Frames, others...)
+	result.Frames = append(result.Frames, newTopKSummaryFrame(ranks[k:], k))
+	return result
+}
+
+// newTopKSummaryFrame 未展示序列的排名汇总
+func newTopKSummaryFrame(ranks []*seriesRank, offset int) *data.Frame {
+	rankField := data.NewField("rank", nil, make([]int64, 0, len(ranks)))
+	seriesField := data.NewField("series", nil, make([]string, 0, len(ranks)))
+	anomaliesField := data.NewField("anomalies", nil, make([]int64, 0, len(ranks)))
+	significanceField := data.NewField("maxSignificance", nil, make([]float64, 0, len(ranks)))
+	for i, rank := range ranks {
+		rankField.Append(int64(offset + i + 1))
+		seriesField.Append(rank.key)
+		anomaliesField.Append(rank.anomalies)
+		significanceField.Append(rank.maxSignificance)
+	}
+	frame := data.NewFrame("summary", rankField, seriesField, anomaliesField, significanceField)
+	frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeTable}
+	return frame
+}
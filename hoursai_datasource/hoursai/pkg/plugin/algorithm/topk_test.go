@@ -0,0 +1,119 @@
+package algorithm
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// previewFrames 同步预览的一条序列：原始序列和以结果名命名的结果frame
+func previewFrames(host string, anomalies []float64, significance []float64) data.Frames {
+	labels := data.Labels{"__name__": "requests", "host": host}
+	value := floatFrame(labels, make([]float64, len(anomalies))...)
+	anomaly := floatFrame(labels, anomalies...)
+	anomaly.Name = "anomaly"
+	sig := floatFrame(labels, significance...)
+	sig.Name = "significance"
+	return data.Frames{value, anomaly, sig}
+}
+
+// realtimeFrames 实时结果的一条序列：每种结果的frame以指标名命名，标签中的__name__各不相同
+func realtimeFrames(host string, anomalies []float64, significance []float64) data.Frames {
+	frame := func(metric string, values []float64) *data.Frame {
+		f := floatFrame(data.Labels{"__name__": metric, "host": host}, values...)
+		f.Name = metric
+		return f
+	}
+	return data.Frames{
+		frame("upper_task_1", make([]float64, len(anomalies))),
+		frame("anomaly_task_1", anomalies),
+		frame("significance_task_1", significance),
+	}
+}
+
+func TestSelectTopK(t *testing.T) {
+	type series struct {
+		host         string
+		anomalies    []float64
+		significance []float64
+	}
+	input := []series{
+		{"a", []float64{0, 1, 0}, []float64{0.1, 0.99, 0.1}},
+		{"b", []float64{1, 1, 1}, []float64{0.6, 0.7, 0.6}},
+		{"c", []float64{0, 0, 0}, []float64{0.1, 0.2, 0.1}},
+	}
+	tests := []struct {
+		name    string
+		build   func(host string, anomalies []float64, significance []float64) data.Frames
+		by      string
+		kept    []string
+		dropped []string
+		counts  []int64
+	}{
+		{name: "sync preview by anomalies", build: previewFrames, kept: []string{"b", "a"},
+			dropped: []string{"c"}, counts: []int64{0}},
+		{name: "sync preview by significance", build: previewFrames, by: util.TopKBySignificance,
+			kept: []string{"a", "b"}, dropped: []string{"c"}, counts: []int64{0}},
+		{name: "realtime by anomalies", build: realtimeFrames, kept: []string{"b", "a"},
+			dropped: []string{"c"}, counts: []int64{0}},
+		{name: "realtime by significance", build: realtimeFrames, by: util.TopKBySignificance,
+			kept: []string{"a", "b"}, dropped: []string{"c"}, counts: []int64{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &backend.DataResponse{}
+			for _, s := range input {
+				r.Frames = append(r.Frames, tt.build(s.host, s.anomalies, s.significance)...)
+			}
+			result := SelectTopK(r, len(tt.kept), tt.by)
+
+			// 保留的序列按排名输出全部frame
+			var hosts []string
+			for _, frame := range result.Frames[:len(result.Frames)-1] {
+				host := frame.Fields[1].Labels["host"]
+				if len(hosts) == 0 || hosts[len(hosts)-1] != host {
+					hosts = append(hosts, host)
+				}
+			}
+			if len(hosts) != len(tt.kept) || len(result.Frames)-1 != 3*len(tt.kept) {
+				t.Fatalf("kept series %v in %d frames, want %v with all their frames", hosts,
+					len(result.Frames)-1, tt.kept)
+			}
+			for i := range hosts {
+				if hosts[i] != tt.kept[i] {
+					t.Errorf("series %d = %s, want %s", i, hosts[i], tt.kept[i])
+				}
+			}
+
+			summary := result.Frames[len(result.Frames)-1]
+			if summary.Name != "summary" || summary.Rows() != len(tt.dropped) {
+				t.Fatalf("summary %q has %d rows, want %d", summary.Name, summary.Rows(), len(tt.dropped))
+			}
+			for i, host := range tt.dropped {
+				if got := summary.Fields[0].At(i).(int64); got != int64(len(tt.kept)+i+1) {
+					t.Errorf("dropped series %d has rank %d", i, got)
+				}
+				if got := summary.Fields[1].At(i).(string); got != (data.Labels{"host": host}).String() {
+					t.Errorf("dropped series %d = %s, want host=%s", i, got, host)
+				}
+				if got := summary.Fields[2].At(i).(int64); got != tt.counts[i] {
+					t.Errorf("dropped series %d has %d anomalies, want %d", i, got, tt.counts[i])
+				}
+				if got := summary.Fields[3].At(i).(float64); got != 0.2 {
+					t.Errorf("dropped series %d max significance = %v, want 0.2", i, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectTopKKeepsAll(t *testing.T) {
+	r := &backend.DataResponse{Frames: append(realtimeFrames("a", []float64{1}, []float64{1}),
+		realtimeFrames("b", []float64{0}, []float64{0})...)}
+	// 两条序列的6个frame不能被当作6条序列
+	if result := SelectTopK(r, 2, ""); len(result.Frames) != len(r.Frames) {
+		t.Errorf("got %d frames, want all %d frames without summary", len(result.Frames), len(r.Frames))
+	}
+}
@@ -0,0 +1,222 @@
+package algorithm
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util/converter"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// MetricTypeCounter prometheus metadata中计数器的类型
+const MetricTypeCounter = "counter"
+
+// getCounterHandling 查询配置优先，其次为数据源配置，默认只提示
+func getCounterHandling(q *models.Query) string {
+	if q.CounterHandling != "" {
+		return q.CounterHandling
+	}
+	handling, err := util.GetStringOptional(jsonDataMap(q), "counterHandling")
+	if err != nil {
+		log.DefaultLogger.Error("Read counter handling error", "err", err)
+	}
+	if handling == "" {
+		return util.CounterWarn
+	}
+	return handling
+}
+
+// NeedMetricTypes 是否需要查询指标类型，只有原始指标（带__name__标签）才可能是未经rate的计数器
+func NeedMetricTypes(r *backend.DataResponse, q *models.Query) bool {
+	if getCounterHandling(q) == util.CounterOff {
+		return false
+	}
+	return len(MetricNames(r)) > 0
+}
+
+// MetricNames 返回序列中去重后的指标名
+func MetricNames(r *backend.DataResponse) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, frame := range r.Frames {
+		if len(frame.Fields) < 2 {
+			continue
+		}
+		name := frame.Fields[1].Labels["__name__"]
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ApplyTransforms 识别计数器并按配置提示或自动转换为rate，检测计数器重置，再应用查询指定的变换。
+// metricTypes为prometheus metadata中的指标类型，查询不到时按_total后缀判断
+func ApplyTransforms(r *backend.DataResponse, q *models.Query,
+	metricTypes map[string]string) (*backend.DataResponse, error) {
+	switch q.Transform {
+	case "", util.TransformRate, util.TransformDelta, util.TransformDerivative, util.TransformLog:
+	default:
+		return nil, util.PluginError(backend.StatusBadRequest, fmt.Errorf("unknown transform %s", q.Transform))
+	}
+	handling := getCounterHandling(q)
+	switch handling {
+	case util.CounterWarn, util.CounterAuto, util.CounterOff:
+	default:
+		return nil, util.PluginError(backend.StatusBadRequest, fmt.Errorf("unknown counter handling %s", handling))
+	}
+
+	response := &backend.DataResponse{Error: r.Error, Status: r.Status}
+	for _, frame := range r.Frames {
+		if len(frame.Fields) < 2 || frame.Fields[0].Type().NonNullableType() != data.FieldTypeTime ||
+			!frame.Fields[1].Type().Numeric() {
+			response.Frames = append(response.Frames, frame)
+			continue
+		}
+		var notices []data.Notice
+		transform := q.Transform
+		name := frame.Fields[1].Labels["__name__"]
+		if handling != util.CounterOff && isCounter(name, metricTypes) {
+			if resets := countResets(frame); resets > 0 {
+				notices = append(notices, data.Notice{
+					Severity: data.NoticeSeverityInfo,
+					Text:     fmt.Sprintf("%d counter resets detected in %s.", resets, name),
+				})
+			}
+			if transform == "" {
+				switch handling {
+				case util.CounterAuto:
+					transform = util.TransformRate
+					notices = append(notices, data.Notice{
+						Severity: data.NoticeSeverityInfo,
+						Text:     fmt.Sprintf("%s is a counter and was converted to a per-second rate.", name),
+					})
+				case util.CounterWarn:
+					notices = append(notices, data.Notice{
+						Severity: data.NoticeSeverityWarning,
+						Text: fmt.Sprintf("%s is a counter; detection on raw counter values is rarely meaningful. "+
+							"Wrap it with rate() or set the transform to rate.", name),
+					})
+				}
+			}
+		}
+		if transform != "" {
+			frame = transformFrame(frame, transform)
+		} else if len(notices) > 0 {
+			// 只追加notice时不修改原始frame
+			copied := *frame
+			copied.Meta = converter.CopyFrameMeta(frame.Meta)
+			frame = &copied
+		}
+		if len(notices) > 0 {
+			frame.AppendNotices(notices...)
+		}
+		response.Frames = append(response.Frames, frame)
+	}
+	return response, nil
+}
+
+func isCounter(name string, metricTypes map[string]string) bool {
+	if name == "" {
+		return false
+	}
+	if t, ok := metricTypes[name]; ok {
+		return t == MetricTypeCounter
+	}
+	return strings.HasSuffix(name, "_total")
+}
+
+// countResets 计数器值下降的次数
+func countResets(frame *data.Frame) int {
+	var (
+		resets int
+		prev   float64
+		seen   bool
+	)
+	for i := 0; i < frame.Fields[1].Len(); i++ {
+		v, ok := valueAt(frame.Fields[1], i)
+		if !ok {
+			continue
+		}
+		if seen && v < prev {
+			resets++
+		}
+		prev, seen = v, true
+	}
+	return resets
+}
+
+// transformFrame 对单条序列做变换。rate、delta、derivative丢弃第一个点，rate在计数器重置时以当前值作为增量；
+// log使用ln(1+v)以兼容0值，负数为NaN
+func transformFrame(frame *data.Frame, transform string) *data.Frame {
+	timeField, valueField := frame.Fields[0], frame.Fields[1]
+	var (
+		times  = make([]time.Time, 0, timeField.Len())
+		values = make([]float64, 0, timeField.Len())
+		prevTs time.Time
+		prevV  float64
+		prevOk bool
+		first  = true
+	)
+	for i := 0; i < timeField.Len(); i++ {
+		ts, ok := timeAt(timeField, i)
+		if !ok {
+			continue
+		}
+		v, vok := valueAt(valueField, i)
+		if transform == util.TransformLog {
+			out := math.NaN()
+			if vok && v >= 0 {
+				out = math.Log1p(v)
+			}
+			times = append(times, ts)
+			values = append(values, out)
+			continue
+		}
+		if first {
+			first = false
+			prevTs, prevV, prevOk = ts, v, vok
+			continue
+		}
+		out := math.NaN()
+		if vok && prevOk {
+			diff := v - prevV
+			if transform == util.TransformRate && diff < 0 {
+				diff = v
+			}
+			dt := ts.Sub(prevTs).Seconds()
+			switch transform {
+			case util.TransformDelta:
+				out = diff
+			default:
+				if dt > 0 {
+					out = diff / dt
+				}
+			}
+		}
+		times = append(times, ts)
+		values = append(values, out)
+		prevTs, prevV, prevOk = ts, v, vok
+	}
+
+	labels := valueField.Labels.Copy()
+	if transform != util.TransformLog {
+		// 与prometheus一致，变换后的序列不再是原指标
+		delete(labels, "__name__")
+	}
+	newTimeField := data.NewField(timeField.Name, timeField.Labels, times)
+	newTimeField.Config = timeField.Config
+	newValueField := data.NewField(valueField.Name, labels, values)
+	newValueField.Config = valueField.Config
+	newFrame := data.NewFrame(frame.Name, newTimeField, newValueField)
+	newFrame.RefID = frame.RefID
+	newFrame.Meta = converter.WithCustomMeta(frame.Meta, map[string]string{"transform": transform})
+	return newFrame
+}
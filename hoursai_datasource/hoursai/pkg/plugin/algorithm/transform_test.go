@@ -0,0 +1,178 @@
+package algorithm
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+var nan = math.NaN()
+
+// equalSeries 逐点比较，NaN与NaN视为相等
+func equalSeries(got, want []float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if math.IsNaN(want[i]) {
+			if !math.IsNaN(got[i]) {
+				return false
+			}
+			continue
+		}
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+func frameFloats(frame *data.Frame) []float64 {
+	values := make([]float64, frame.Fields[1].Len())
+	for i := range values {
+		values[i] = frame.Fields[1].At(i).(float64)
+	}
+	return values
+}
+
+func TestCountResets(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []*float64
+		want   int
+	}{
+		{name: "monotonic", values: []*float64{fp(1), fp(2), fp(2), fp(5)}},
+		{name: "one reset", values: []*float64{fp(10), fp(20), fp(3), fp(8)}, want: 1},
+		{name: "reset on the second point", values: []*float64{fp(10), fp(0), fp(1)}, want: 1},
+		{name: "several resets", values: []*float64{fp(5), fp(1), fp(4), fp(2), fp(3)}, want: 2},
+		{name: "null points are skipped", values: []*float64{fp(10), nil, fp(12), nil, fp(1)}, want: 1},
+		{name: "leading null", values: []*float64{nil, fp(5), fp(6)}},
+		{name: "empty", values: []*float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countResets(seriesFrame(nil, 0, tt.values...)); got != tt.want {
+				t.Errorf("countResets = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformFrame(t *testing.T) {
+	// 点间隔为1s，只有log不丢弃第一个点
+	tests := []struct {
+		name      string
+		transform string
+		values    []*float64
+		want      []float64
+	}{
+		{name: "rate", transform: util.TransformRate, values: []*float64{fp(1), fp(3), fp(6)}, want: []float64{2, 3}},
+		{name: "rate uses the current value after a reset", transform: util.TransformRate,
+			values: []*float64{fp(10), fp(20), fp(4), fp(6)}, want: []float64{10, 4, 2}},
+		{name: "delta keeps negative differences", transform: util.TransformDelta,
+			values: []*float64{fp(10), fp(20), fp(4)}, want: []float64{10, -16}},
+		{name: "derivative keeps negative differences", transform: util.TransformDerivative,
+			values: []*float64{fp(10), fp(20), fp(4)}, want: []float64{10, -16}},
+		{name: "null points become NaN", transform: util.TransformDelta,
+			values: []*float64{fp(1), nil, fp(3), fp(6)}, want: []float64{nan, nan, 3}},
+		{name: "null first point", transform: util.TransformRate,
+			values: []*float64{nil, fp(3), fp(6)}, want: []float64{nan, 3}},
+		{name: "single point", transform: util.TransformRate, values: []*float64{fp(1)}, want: []float64{}},
+		{name: "log1p", transform: util.TransformLog, values: []*float64{fp(0), fp(math.E - 1), nil, fp(-1)},
+			want: []float64{0, 1, nan, nan}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := seriesFrame(data.Labels{"__name__": "requests_total", "host": "a"}, 0, tt.values...)
+			result := transformFrame(frame, tt.transform)
+			if got := frameFloats(result); !equalSeries(got, tt.want) {
+				t.Errorf("values = %v, want %v", got, tt.want)
+			}
+			if result.Fields[0].Len() != len(tt.want) {
+				t.Errorf("got %d time points, want %d", result.Fields[0].Len(), len(tt.want))
+			}
+			// 只有log保留指标名
+			_, hasName := result.Fields[1].Labels["__name__"]
+			if hasName != (tt.transform == util.TransformLog) || result.Fields[1].Labels["host"] != "a" {
+				t.Errorf("unexpected labels %v", result.Fields[1].Labels)
+			}
+			if custom, _ := result.Meta.Custom.(map[string]string); custom["transform"] != tt.transform {
+				t.Errorf("transform meta = %v, want %s", result.Meta.Custom, tt.transform)
+			}
+		})
+	}
+}
+
+func TestApplyTransformsCounterDetection(t *testing.T) {
+	tests := []struct {
+		name        string
+		metric      string
+		metricTypes map[string]string
+		counter     bool
+	}{
+		{name: "total suffix without metadata", metric: "requests_total", counter: true},
+		{name: "no suffix without metadata", metric: "requests"},
+		{name: "metadata counter without suffix", metric: "requests",
+			metricTypes: map[string]string{"requests": MetricTypeCounter}, counter: true},
+		{name: "metadata gauge overrides the suffix", metric: "requests_total",
+			metricTypes: map[string]string{"requests_total": "gauge"}},
+		{name: "suffix used for metrics missing from metadata", metric: "requests_total",
+			metricTypes: map[string]string{"other": "gauge"}, counter: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := seriesFrame(data.Labels{"__name__": tt.metric}, 0, fp(1), fp(3), fp(2))
+			r := &backend.DataResponse{Frames: data.Frames{frame}}
+			q := &models.Query{CounterHandling: util.CounterAuto, JsonData: json.RawMessage(`{}`)}
+			if !NeedMetricTypes(r, q) {
+				t.Fatal("raw metric should need metric types")
+			}
+			response, err := ApplyTransforms(r, q, tt.metricTypes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := response.Frames[0]
+			converted := result != frame
+			if converted != tt.counter {
+				t.Fatalf("converted = %v, want %v", converted, tt.counter)
+			}
+			if !tt.counter {
+				return
+			}
+			if got := frameFloats(result); !equalSeries(got, []float64{2, 2}) {
+				t.Errorf("rate = %v, want [2 2]", got)
+			}
+			var texts []string
+			for _, n := range result.Meta.Notices {
+				texts = append(texts, n.Text)
+			}
+			joined := strings.Join(texts, "\n")
+			if !strings.Contains(joined, "1 counter resets") || !strings.Contains(joined, "per-second rate") {
+				t.Errorf("unexpected notices: %v", texts)
+			}
+		})
+	}
+}
+
+func TestApplyTransformsCounterOff(t *testing.T) {
+	r := &backend.DataResponse{Frames: data.Frames{
+		seriesFrame(data.Labels{"__name__": "requests_total"}, 0, fp(1), fp(3)),
+	}}
+	q := &models.Query{CounterHandling: util.CounterOff, JsonData: json.RawMessage(`{}`)}
+	if NeedMetricTypes(r, q) {
+		t.Error("metric types are not needed when counter handling is off")
+	}
+	response, err := ApplyTransforms(r, q, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Frames[0] != r.Frames[0] {
+		t.Error("counter was converted although counter handling is off")
+	}
+}
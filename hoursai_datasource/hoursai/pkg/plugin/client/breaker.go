@@ -0,0 +1,126 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// ErrCircuitOpen 熔断打开期间直接失败，不再请求manager
+var ErrCircuitOpen = errors.New("HoursAI manager circuit breaker is open")
+
+// CircuitBreaker 数据源级别的熔断器：连续失败达到阈值后打开，冷却时间后放行一个探测请求
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	lastError error
+	probing   bool
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		threshold = defaultBreakerThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, state: BreakerClosed}
+}
+
+// Allow 判断当前是否允许请求manager
+func (b *CircuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return fmt.Errorf("%w: %d consecutive failures, last error: %v", ErrCircuitOpen, b.failures, b.lastError)
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		// 半开状态只放行一个探测请求
+		if b.probing {
+			return fmt.Errorf("%w: waiting for probe request", ErrCircuitOpen)
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *CircuitBreaker) Success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+	b.lastError = nil
+}
+
+func (b *CircuitBreaker) Failure(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastError = err
+	b.probing = false
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Release 请求被取消等未计入结果时释放探测名额，半开状态下后续请求可以重新探测
+func (b *CircuitBreaker) Release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// Status 熔断器当前状态的描述，用于健康检查
+func (b *CircuitBreaker) Status() (string, string) {
+	if b == nil {
+		return BreakerClosed, ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		retryIn := b.cooldown - time.Since(b.openedAt)
+		if retryIn < 0 {
+			retryIn = 0
+		}
+		return b.state, fmt.Sprintf("HoursAI manager circuit breaker is open after %d consecutive failures, "+
+			"retry in %s, last error: %v", b.failures, retryIn.Round(time.Second), b.lastError)
+	case BreakerHalfOpen:
+		return b.state, "HoursAI manager circuit breaker is half-open, probing the manager"
+	default:
+		return b.state, ""
+	}
+}
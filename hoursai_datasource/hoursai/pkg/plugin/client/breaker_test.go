@@ -0,0 +1,59 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	b := NewCircuitBreaker(2, time.Hour)
+	b.Failure(errors.New("boom"))
+	if err := b.Allow(); err != nil {
+		t.Fatalf("breaker opened before threshold: %v", err)
+	}
+	b.Failure(errors.New("boom"))
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if state, _ := b.Status(); state != BreakerOpen {
+		t.Errorf("state = %s, want %s", state, BreakerOpen)
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbe(t *testing.T) {
+	tests := []struct {
+		name  string
+		probe func(b *CircuitBreaker)
+		state string
+		allow bool
+	}{
+		{name: "success closes", probe: func(b *CircuitBreaker) { b.Success() }, state: BreakerClosed, allow: true},
+		{name: "failure reopens", probe: func(b *CircuitBreaker) { b.Failure(errors.New("boom")) },
+			state: BreakerOpen, allow: false},
+		{name: "release allows another probe", probe: func(b *CircuitBreaker) { b.Release() },
+			state: BreakerHalfOpen, allow: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker(1, time.Millisecond)
+			b.Failure(errors.New("boom"))
+			time.Sleep(2 * time.Millisecond)
+			if err := b.Allow(); err != nil {
+				t.Fatalf("probe not allowed after cooldown: %v", err)
+			}
+			if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+				t.Fatalf("second request allowed while probing: %v", err)
+			}
+			// 重新打开后冷却时间需要足够长，避免立即再次半开
+			b.cooldown = time.Hour
+			tt.probe(b)
+			if state, _ := b.Status(); state != tt.state {
+				t.Errorf("state = %s, want %s", state, tt.state)
+			}
+			if err := b.Allow(); (err == nil) != tt.allow {
+				t.Errorf("Allow() = %v, want allowed %v", err, tt.allow)
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package client
 import (
 	"bytes"
 	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"golang.org/x/net/context"
 	"net/http"
@@ -18,9 +20,12 @@ type doer interface {
 }
 
 type Client struct {
-	doer    doer
-	method  string
-	baseUrl string
+	doer     doer
+	method   string
+	baseUrl  string
+	retry    RetryOptions
+	policy   *ManagerPolicy
+	coalesce bool
 }
 
 func NewClient(d doer, method, baseUrl string) *Client {
@@ -35,6 +40,21 @@ func (c *Client) SetMethod(method string) {
 	c.method = method
 }
 
+// SetRetry 设置失败重试策略，只应用于幂等的请求
+func (c *Client) SetRetry(retry RetryOptions) {
+	c.retry = retry
+}
+
+// SetPolicy 设置数据源级别共享的熔断、限流和请求合并策略
+func (c *Client) SetPolicy(policy *ManagerPolicy) {
+	c.policy = policy
+}
+
+// SetCoalesce 相同的并发请求合并为一次上游调用，只应用于只读的请求，创建任务、生成token等请求每次都要发送
+func (c *Client) SetCoalesce(coalesce bool) {
+	c.coalesce = coalesce
+}
+
 func (c *Client) GetClientUrl() string {
 	return c.baseUrl
 }
@@ -101,58 +121,68 @@ func (c *Client) QueryExemplars(ctx context.Context, q *models.Query, headers ht
 	return c.doer.Do(req)
 }
 
-func (c *Client) QueryMetrics(ctx context.Context, start int64, end int64, headers http.Header) (*http.Response,
-	error) {
-	u, err := c.createUrl("api/v1/label/__name__/values", map[string]string{
-		"query": "__name__/values",
-		//"start": formatTime(time.Unix(start, 0)),
-		//"end":   formatTime(time.Unix(end, 0)),
-	})
-	if err != nil {
-		return nil, err
-	}
+// MetadataRange 标签、序列查询的时间范围、序列选择器和结果数量上限，时间为秒级时间戳，0表示不限制
+type MetadataRange struct {
+	Start   int64
+	End     int64
+	Matches []string
+	Limit   int64
+}
 
-	req, err := createRequest(ctx, http.MethodGet, u, nil, headers)
-	if err != nil {
-		return nil, err
+func (m MetadataRange) values() url.Values {
+	qs := url.Values{}
+	if m.Start > 0 {
+		qs.Set("start", formatTime(time.Unix(m.Start, 0)))
 	}
-	return c.doer.Do(req)
+	if m.End > 0 {
+		qs.Set("end", formatTime(time.Unix(m.End, 0)))
+	}
+	for _, match := range m.Matches {
+		qs.Add("match[]", match)
+	}
+	if m.Limit > 0 {
+		qs.Set("limit", strconv.FormatInt(m.Limit, 10))
+	}
+	return qs
 }
 
-func (c *Client) QueryLabelNames(ctx context.Context, start int64, end int64, headers http.Header) (*http.Response,
-	error) {
-	u, err := c.createUrl("api/v1/labels", map[string]string{
-		//"start": formatTime(time.Unix(start, 0)),
-		//"end":   formatTime(time.Unix(end, 0)),
-	})
-	if err != nil {
-		return nil, err
-	}
+// QueryMetrics 查询时间范围内存在的指标名
+func (c *Client) QueryMetrics(ctx context.Context, m MetadataRange, headers http.Header) (*http.Response, error) {
+	return c.QueryLabelValues(ctx, "__name__", m, headers)
+}
 
-	req, err := createRequest(ctx, http.MethodGet, u, nil, headers)
-	if err != nil {
-		return nil, err
+// QueryMetadata 查询指标的类型、帮助信息和单位，prometheus的metadata接口不支持时间范围
+func (c *Client) QueryMetadata(ctx context.Context, metric string, limit int64,
+	headers http.Header) (*http.Response, error) {
+	qs := url.Values{"limit_per_metric": []string{"1"}}
+	if metric != "" {
+		qs.Set("metric", metric)
 	}
-	return c.doer.Do(req)
+	if limit > 0 {
+		qs.Set("limit", strconv.FormatInt(limit, 10))
+	}
+	return c.getMetadata(ctx, "api/v1/metadata", qs, headers)
 }
 
-func (c *Client) QuerySeries(ctx context.Context, start int64, end int64, match string,
-	headers http.Header, needTime bool) (*http.Response, error) {
-	var (
-		u   *url.URL
-		err error
-	)
-	if needTime {
-		u, err = c.createUrl("api/v1/series", map[string]string{
-			"start":   formatTime(time.Unix(start, 0)),
-			"end":     formatTime(time.Unix(end, 0)),
-			"match[]": match,
-		})
-	} else {
-		u, err = c.createUrl("api/v1/series", map[string]string{
-			"match[]": match,
-		})
-	}
+// QueryLabelValues 查询时间范围内标签的取值，Matches为空时不过滤序列
+func (c *Client) QueryLabelValues(ctx context.Context, label string, m MetadataRange,
+	headers http.Header) (*http.Response, error) {
+	return c.getMetadata(ctx, "api/v1/label/"+label+"/values", m.values(), headers)
+}
+
+// QueryLabelNames 查询时间范围内的标签名
+func (c *Client) QueryLabelNames(ctx context.Context, m MetadataRange, headers http.Header) (*http.Response, error) {
+	return c.getMetadata(ctx, "api/v1/labels", m.values(), headers)
+}
+
+// QuerySeries 查询时间范围内匹配选择器的序列，prometheus要求至少一个match[]
+func (c *Client) QuerySeries(ctx context.Context, m MetadataRange, headers http.Header) (*http.Response, error) {
+	return c.getMetadata(ctx, "api/v1/series", m.values(), headers)
+}
+
+func (c *Client) getMetadata(ctx context.Context, endpoint string, qs url.Values,
+	headers http.Header) (*http.Response, error) {
+	u, err := c.createUrlWithValues(endpoint, qs)
 	if err != nil {
 		return nil, err
 	}
@@ -170,11 +200,26 @@ func (c *Client) CallAlgorithm(ctx context.Context, body []byte, headers http.He
 	if err != nil {
 		return nil, err
 	}
-	req, err := createRequest(ctx, c.method, u, body, headers)
-	if err != nil {
-		return nil, err
+	call := func(ctx context.Context) (*http.Response, error) {
+		release, err := c.policy.GetLimiter().Acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		if err := c.policy.GetBreaker().Allow(); err != nil {
+			return nil, util.DownstreamError(backend.StatusBadGateway, err)
+		}
+		resp, err := c.doWithRetry(ctx, func() (*http.Request, error) {
+			return createRequest(ctx, c.method, u, body, headers)
+		})
+		c.recordResult(resp, err)
+		return resp, err
 	}
-	return c.doer.Do(req)
+	if c.policy == nil || !c.coalesce {
+		return call(ctx)
+	}
+	// 相同的并发请求合并为一次上游调用
+	return c.policy.flights.do(ctx, flightKey(c.method, u.String(), headers, body), call)
 }
 
 func (c *Client) GetAlgorithmList(ctx context.Context, headers http.Header) (*http.Response, error) {
@@ -203,6 +248,15 @@ func (c *Client) CheckHealthy(ctx context.Context, headers http.Header) (*http.R
 
 // createUrl 构建prometheus查询url
 func (c *Client) createUrl(endpoint string, qs map[string]string) (*url.URL, error) {
+	values := url.Values{}
+	for key, val := range qs {
+		values.Set(key, val)
+	}
+	return c.createUrlWithValues(endpoint, values)
+}
+
+// createUrlWithValues 构建prometheus查询url，同一参数可以有多个值（如match[]）
+func (c *Client) createUrlWithValues(endpoint string, qs url.Values) (*url.URL, error) {
 	finalUrl, err := url.ParseRequestURI(c.baseUrl)
 	if err != nil {
 		return nil, err
@@ -211,8 +265,11 @@ func (c *Client) createUrl(endpoint string, qs map[string]string) (*url.URL, err
 	finalUrl.Path = path.Join(finalUrl.Path, endpoint)
 	urlQuery := finalUrl.Query()
 
-	for key, val := range qs {
-		urlQuery.Set(key, val)
+	for key, vals := range qs {
+		urlQuery.Del(key)
+		for _, val := range vals {
+			urlQuery.Add(key, val)
+		}
 	}
 	finalUrl.RawQuery = urlQuery.Encode()
 	log.DefaultLogger.Info("Final url is:", finalUrl)
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// flightResult 合并请求的共享结果，响应体已读入内存，便于每个调用方各自读取
+type flightResult struct {
+	status int
+	header http.Header
+	body   []byte
+	err    error
+}
+
+type flightCall struct {
+	done   chan struct{}
+	result flightResult
+}
+
+// flightGroup 相同的并发请求只向上游发送一次
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// flightKey 由请求方法、url、鉴权信息和请求体生成
+func flightKey(method, url string, headers http.Header, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(url))
+	h.Write([]byte{0})
+	h.Write([]byte(headers.Get("Authorization")))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// do 同一key只有第一个调用方发起fn，fn使用不随调用方取消的ctx执行，所有调用方在自己的ctx内等待并复用结果，
+// 某个调用方取消不会中断其它调用方共享的请求
+func (g *flightGroup) do(ctx context.Context, key string,
+	fn func(ctx context.Context) (*http.Response, error)) (*http.Response, error) {
+	g.mu.Lock()
+	call, ok := g.calls[key]
+	if !ok {
+		call = &flightCall{done: make(chan struct{})}
+		g.calls[key] = call
+		go func() {
+			call.result = readFlightResult(fn(detachedContext{ctx}))
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	g.mu.Unlock()
+	select {
+	case <-call.done:
+		return call.result.response()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// detachedContext 保留调用方ctx中的值，但不继承取消和超时，请求时长由http客户端的超时限制
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+func readFlightResult(resp *http.Response, err error) flightResult {
+	if err != nil {
+		return flightResult{err: err}
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	return flightResult{status: resp.StatusCode, header: resp.Header, body: body, err: err}
+}
+
+func (r flightResult) response() (*http.Response, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &http.Response{
+		Status:        http.StatusText(r.status),
+		StatusCode:    r.status,
+		Header:        r.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(r.body)),
+		ContentLength: int64(len(r.body)),
+	}, nil
+}
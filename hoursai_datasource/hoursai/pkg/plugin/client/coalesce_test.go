@@ -0,0 +1,115 @@
+package client
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// blockingDoer 在release关闭前阻塞所有请求，并记录请求次数
+type blockingDoer struct {
+	calls   int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (d *blockingDoer) Do(req *http.Request) (*http.Response, error) {
+	if atomic.AddInt32(&d.calls, 1) == 1 {
+		close(d.started)
+	}
+	select {
+	case <-d.release:
+		return statusResponse(http.StatusOK), nil
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+}
+
+func newBlockingDoer() *blockingDoer {
+	return &blockingDoer{started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func TestCallAlgorithmCoalesce(t *testing.T) {
+	tests := []struct {
+		name     string
+		coalesce bool
+		calls    int32
+	}{
+		{name: "read-only requests share one call", coalesce: true, calls: 1},
+		{name: "other requests are sent every time", coalesce: false, calls: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newBlockingDoer()
+			policy := NewManagerPolicy(nil, nil)
+			var wg sync.WaitGroup
+			for i := 0; i < 2; i++ {
+				c := NewClient(d, http.MethodPost, "http://manager/")
+				c.SetPolicy(policy)
+				c.SetCoalesce(tt.coalesce)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					resp, err := c.CallAlgorithm(context.Background(), []byte(`{}`), http.Header{})
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					_, _ = io.Copy(io.Discard, resp.Body)
+				}()
+			}
+			<-d.started
+			// 等待第二个调用方加入合并或发出自己的请求
+			time.Sleep(20 * time.Millisecond)
+			close(d.release)
+			wg.Wait()
+			if calls := atomic.LoadInt32(&d.calls); calls != tt.calls {
+				t.Errorf("upstream calls = %d, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestCallAlgorithmCoalesceLeaderCanceled(t *testing.T) {
+	d := newBlockingDoer()
+	policy := NewManagerPolicy(nil, nil)
+	newClient := func() *Client {
+		c := NewClient(d, http.MethodPost, "http://manager/")
+		c.SetPolicy(policy)
+		c.SetCoalesce(true)
+		return c
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := newClient().CallAlgorithm(ctx, []byte(`{}`), http.Header{})
+		leader <- err
+	}()
+	<-d.started
+	follower := make(chan *http.Response, 1)
+	go func() {
+		resp, err := newClient().CallAlgorithm(context.Background(), []byte(`{}`), http.Header{})
+		if err != nil {
+			t.Error(err)
+		}
+		follower <- resp
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-leader; err != context.Canceled {
+		t.Fatalf("leader error = %v, want context.Canceled", err)
+	}
+	close(d.release)
+	if resp := <-follower; resp == nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("follower did not get the shared response: %v", resp)
+	}
+	if calls := atomic.LoadInt32(&d.calls); calls != 1 {
+		t.Errorf("upstream calls = %d, want 1", calls)
+	}
+}
@@ -0,0 +1,26 @@
+package client
+
+// ManagerPolicy 同一数据源调用manager时共享的熔断、限流和请求合并状态
+type ManagerPolicy struct {
+	Breaker *CircuitBreaker
+	Limiter *RateLimiter
+	flights *flightGroup
+}
+
+func NewManagerPolicy(breaker *CircuitBreaker, limiter *RateLimiter) *ManagerPolicy {
+	return &ManagerPolicy{Breaker: breaker, Limiter: limiter, flights: newFlightGroup()}
+}
+
+func (p *ManagerPolicy) GetBreaker() *CircuitBreaker {
+	if p == nil {
+		return nil
+	}
+	return p.Breaker
+}
+
+func (p *ManagerPolicy) GetLimiter() *RateLimiter {
+	if p == nil {
+		return nil
+	}
+	return p.Limiter
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"golang.org/x/net/context"
+)
+
+const (
+	defaultRetryBaseDelay = 200 * time.Millisecond
+	defaultRetryMaxDelay  = 5 * time.Second
+)
+
+// RetryOptions 重试策略，MaxAttempts包含第一次请求，小于等于1时不重试
+type RetryOptions struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// doWithRetry 对网络错误、429以及502/503/504进行指数退避重试
+func (c *Client) doWithRetry(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.doer.Do(req)
+		if attempt >= c.retry.MaxAttempts || !shouldRetry(resp, err) || ctx.Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		delay := c.retry.backoff(attempt)
+		log.DefaultLogger.Info("Retry request", "url", req.URL.String(), "attempt", attempt, "delay", delay,
+			"err", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// backoff 指数退避，并在[delay/2, delay)之间加入随机抖动
+func (r RetryOptions) backoff(attempt int) time.Duration {
+	base, max := r.BaseDelay, r.MaxDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+	if max <= 0 {
+		max = defaultRetryMaxDelay
+	}
+	delay := base << uint(attempt-1)
+	if delay > max || delay <= 0 {
+		delay = max
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// recordResult 将请求结果计入熔断器，调用方主动取消的请求不计入，只释放探测名额
+func (c *Client) recordResult(resp *http.Response, err error) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		c.policy.GetBreaker().Release()
+	case err != nil:
+		c.policy.GetBreaker().Failure(err)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		c.policy.GetBreaker().Failure(fmt.Errorf("manager responded with status %d", resp.StatusCode))
+	default:
+		c.policy.GetBreaker().Success()
+	}
+}
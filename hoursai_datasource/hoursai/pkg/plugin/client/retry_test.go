@@ -0,0 +1,89 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+type doerFunc func(req *http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func statusResponse(code int) *http.Response {
+	return &http.Response{StatusCode: code, Body: io.NopCloser(strings.NewReader("{}"))}
+}
+
+func newRequestFunc(ctx context.Context) func() (*http.Request, error) {
+	return func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodPost, "http://manager/", nil)
+	}
+}
+
+func TestDoWithRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		want     int
+		calls    int
+	}{
+		{name: "retries unavailable", statuses: []int{503, 502, 200}, want: 200, calls: 3},
+		{name: "stops at max attempts", statuses: []int{503, 503, 503, 200}, want: 503, calls: 3},
+		{name: "no retry on client error", statuses: []int{400, 200}, want: 400, calls: 1},
+		{name: "no retry on internal error", statuses: []int{500, 200}, want: 500, calls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			c := NewClient(doerFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+				return statusResponse(tt.statuses[calls-1]), nil
+			}), http.MethodPost, "http://manager/")
+			c.SetRetry(RetryOptions{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+			resp, err := c.doWithRetry(context.Background(), newRequestFunc(context.Background()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want || calls != tt.calls {
+				t.Errorf("status %d after %d calls, want %d after %d", resp.StatusCode, calls, tt.want, tt.calls)
+			}
+		})
+	}
+}
+
+func TestDoWithRetryCanceledDuringBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := NewClient(doerFunc(func(req *http.Request) (*http.Response, error) {
+		time.AfterFunc(10*time.Millisecond, cancel)
+		return statusResponse(http.StatusServiceUnavailable), nil
+	}), http.MethodPost, "http://manager/")
+	c.SetRetry(RetryOptions{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour})
+	if _, err := c.doWithRetry(ctx, newRequestFunc(context.Background())); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRecordResultReleasesProbeOnCancel(t *testing.T) {
+	breaker := NewCircuitBreaker(1, time.Millisecond)
+	c := NewClient(nil, http.MethodPost, "http://manager/")
+	c.SetPolicy(NewManagerPolicy(breaker, nil))
+	breaker.Failure(errors.New("boom"))
+	time.Sleep(2 * time.Millisecond)
+	if err := breaker.Allow(); err != nil {
+		t.Fatalf("probe not allowed after cooldown: %v", err)
+	}
+	c.recordResult(nil, context.Canceled)
+	if state, _ := breaker.Status(); state != BreakerHalfOpen {
+		t.Errorf("state = %s, want %s", state, BreakerHalfOpen)
+	}
+	if err := breaker.Allow(); err != nil {
+		t.Errorf("canceled probe kept the breaker blocked: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+var adHocKeyRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// adHocOperators grafana即席过滤的操作符到prometheus标签匹配类型的映射
+var adHocOperators = map[string]labels.MatchType{
+	"=":  labels.MatchEqual,
+	"!=": labels.MatchNotEqual,
+	"=~": labels.MatchRegexp,
+	"!~": labels.MatchNotRegexp,
+}
+
+// AdHocFilter 仪表盘的即席过滤条件，作用于查询中的所有选择器
+type AdHocFilter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// matcher 将过滤条件转换为标签匹配器，正则在此编译校验
+func (f AdHocFilter) matcher() (*labels.Matcher, error) {
+	if !adHocKeyRegexp.MatchString(f.Key) {
+		return nil, fmt.Errorf("invalid ad-hoc filter key %q", f.Key)
+	}
+	matchType, ok := adHocOperators[f.Operator]
+	if !ok {
+		return nil, fmt.Errorf("unsupported ad-hoc filter operator %q for key %q, expected =, !=, =~ or !~",
+			f.Operator, f.Key)
+	}
+	m, err := labels.NewMatcher(matchType, f.Key, f.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ad-hoc filter %s%s%q: %w", f.Key, f.Operator, f.Value, err)
+	}
+	return m, nil
+}
+
+// AdHocMatchers 校验即席过滤条件并转换为标签匹配器
+func AdHocMatchers(filters []AdHocFilter) ([]*labels.Matcher, error) {
+	matchers := make([]*labels.Matcher, 0, len(filters))
+	for _, f := range filters {
+		m, err := f.matcher()
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+// ApplyAdHocFilters 解析表达式，将过滤条件加入每个向量选择器（包括区间向量和子查询中的选择器）后重新生成表达式，
+// 选择器中已有相同条件时不重复添加
+func ApplyAdHocFilters(expr string, matchers []*labels.Matcher) (string, error) {
+	if len(matchers) == 0 {
+		return expr, nil
+	}
+	parsed, err := parser.ParseExpr(expr)
+	if err != nil {
+		return "", err
+	}
+	parser.Inspect(parsed, func(node parser.Node, _ []parser.Node) error {
+		vs, ok := node.(*parser.VectorSelector)
+		if !ok {
+			return nil
+		}
+		for _, m := range matchers {
+			if !hasMatcher(vs.LabelMatchers, m) {
+				vs.LabelMatchers = append(vs.LabelMatchers, m)
+			}
+		}
+		return nil
+	})
+	return parsed.String(), nil
+}
+
+func hasMatcher(matchers []*labels.Matcher, m *labels.Matcher) bool {
+	for _, existing := range matchers {
+		if existing.Name == m.Name && existing.Type == m.Type && existing.Value == m.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// withAdHocFilters 表达式无法解析时保持原样，由查询前的校验返回带位置的语法错误
+func withAdHocFilters(expr string, matchers []*labels.Matcher) string {
+	filtered, err := ApplyAdHocFilters(expr, matchers)
+	if err != nil {
+		return expr
+	}
+	return filtered
+}
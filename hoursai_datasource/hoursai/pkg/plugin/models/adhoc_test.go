@@ -0,0 +1,51 @@
+package models
+
+import "testing"
+
+func TestApplyAdHocFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		filters []AdHocFilter
+		want    string
+	}{
+		{"no filters", `up`, nil, `up`},
+		{"metric name only", `up`, []AdHocFilter{{"job", "=", "api"}}, `up{job="api"}`},
+		{"every selector", `sum(rate(http_requests_total{code="500"}[5m])) / sum(rate(http_requests_total[5m]))`,
+			[]AdHocFilter{{"job", "=", "api"}, {"instance", "=~", "a.*"}},
+			`sum(rate(http_requests_total{code="500",instance=~"a.*",job="api"}[5m])) / ` +
+				`sum(rate(http_requests_total{instance=~"a.*",job="api"}[5m]))`},
+		{"subquery", `max_over_time(up[1h:5m])`, []AdHocFilter{{"env", "!=", "dev"}},
+			`max_over_time(up{env!="dev"}[1h:5m])`},
+		{"existing matcher not duplicated", `up{job="api"}`, []AdHocFilter{{"job", "=", "api"}}, `up{job="api"}`},
+		{"value is quoted", `up`, []AdHocFilter{{"path", "!~", `/a"b`}}, `up{path!~"/a\"b"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matchers, err := AdHocMatchers(tt.filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ApplyAdHocFilters(tt.expr, matchers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ApplyAdHocFilters(%q) = %q, want %q", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdHocMatchersInvalid(t *testing.T) {
+	tests := []AdHocFilter{
+		{"job", ">", "1"},
+		{"1job", "=", "api"},
+		{"job", "=~", "("},
+	}
+	for _, f := range tests {
+		if _, err := AdHocMatchers([]AdHocFilter{f}); err == nil {
+			t.Errorf("AdHocMatchers(%+v) should fail", f)
+		}
+	}
+}
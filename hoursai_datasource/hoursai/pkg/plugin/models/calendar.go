@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Calendar 查询时间范围内的节假日和维护窗口，节假日为查询时区的日期，算法据此按特殊日期处理季节性
+type Calendar struct {
+	Timezone string   `json:"timezone"`
+	Holidays []string `json:"holidays,omitempty"`
+	Windows  []Window `json:"windows,omitempty"`
+}
+
+// Window 维护窗口，来源为周期性维护配置或grafana注释，时间为毫秒时间戳，左闭右开
+type Window struct {
+	Name   string `json:"name,omitempty"`
+	Source string `json:"source"`
+	Start  int64  `json:"start"`
+	End    int64  `json:"end"`
+}
+
+// Empty 没有节假日和维护窗口时不需要发送给算法
+func (c *Calendar) Empty() bool {
+	return c == nil || (len(c.Holidays) == 0 && len(c.Windows) == 0)
+}
+
+// InWindow 时间点是否在任一维护窗口内
+func (c *Calendar) InWindow(t time.Time) bool {
+	if c == nil {
+		return false
+	}
+	ms := t.UnixMilli()
+	for _, w := range c.Windows {
+		if ms >= w.Start && ms < w.End {
+			return true
+		}
+	}
+	return false
+}
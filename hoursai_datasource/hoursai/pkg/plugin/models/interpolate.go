@@ -0,0 +1,268 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/intervalv2"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// variableRegexp 与grafana模板变量的写法一致：$var、[[var:format]]、${var.field:format}
+var variableRegexp = regexp.MustCompile(`\$(\w+)|\[\[(\w+?)(?::(\w+))?\]\]|\$\{(\w+)(?:\.([^:^\}]+))?(?::([^\}]+))?\}`)
+
+// momentTokens moment.js日期格式到go时间格式的映射，按长度从长到短匹配
+var momentTokens = []struct {
+	token  string
+	layout string
+}{
+	{"YYYY", "2006"}, {"YY", "06"},
+	{"MMMM", "January"}, {"MMM", "Jan"}, {"MM", "01"}, {"M", "1"},
+	{"dddd", "Monday"}, {"ddd", "Mon"},
+	{"DD", "02"}, {"D", "2"},
+	{"HH", "15"}, {"hh", "03"}, {"h", "3"},
+	{"mm", "04"}, {"m", "4"},
+	{"ss", "05"}, {"s", "5"},
+	{"SSS", "000"},
+	{"A", "PM"}, {"a", "pm"},
+	{"ZZ", "-0700"}, {"Z", "-07:00"},
+}
+
+// ScopedVar 前端传入的变量，多选变量的value为字符串数组
+type ScopedVar struct {
+	Text  interface{} `json:"text"`
+	Value interface{} `json:"value"`
+}
+
+// interpolation 替换promql变量所需的时间范围、step和前端变量
+type interpolation struct {
+	from           time.Time
+	to             time.Time
+	location       *time.Location
+	step           time.Duration
+	queryInterval  time.Duration
+	minStep        string
+	scrapeInterval string
+	scopedVars     map[string]ScopedVar
+}
+
+// newInterpolation queryInterval为grafana请求中的interval，即面板的$__interval，未传时使用计算出的step
+func newInterpolation(model *QueryModel, query backend.DataQuery, step time.Duration,
+	scrapeInterval string, location *time.Location) *interpolation {
+	queryInterval := query.Interval
+	if queryInterval <= 0 {
+		queryInterval = step
+	}
+	return &interpolation{
+		from:           query.TimeRange.From,
+		to:             query.TimeRange.To,
+		location:       location,
+		step:           step,
+		queryInterval:  queryInterval,
+		minStep:        model.Interval,
+		scrapeInterval: scrapeInterval,
+		scopedVars:     model.ScopedVars,
+	}
+}
+
+// interpolate 替换grafana内置变量和前端传入的变量，无法识别的变量保持原样
+func (v *interpolation) interpolate(expr string) string {
+	log.DefaultLogger.Info("Before expr process ", expr)
+	expr = variableRegexp.ReplaceAllStringFunc(expr, func(match string) string {
+		groups := variableRegexp.FindStringSubmatch(match)
+		name, field, format := groups[1], "", ""
+		switch {
+		case groups[2] != "":
+			name, format = groups[2], groups[3]
+		case groups[4] != "":
+			name, field, format = groups[4], groups[5], groups[6]
+		}
+		if value, ok := v.builtin(name, format); ok {
+			return value
+		}
+		if value, ok := v.scoped(name, field, format); ok {
+			return value
+		}
+		return match
+	})
+	log.DefaultLogger.Info("After expr process ", expr)
+	return expr
+}
+
+// builtin grafana prometheus数据源的内置变量
+func (v *interpolation) builtin(name string, format string) (string, bool) {
+	rangeMs := v.to.Sub(v.from).Milliseconds()
+	rangeSRounded := int64(math.Round(float64(rangeMs) / 1000.0))
+	switch name {
+	case "__interval":
+		return intervalv2.FormatDuration(v.step), true
+	case "__interval_ms":
+		return strconv.FormatInt(v.step.Milliseconds(), 10), true
+	case "__range":
+		return strconv.FormatInt(rangeSRounded, 10) + "s", true
+	case "__range_s":
+		return strconv.FormatInt(rangeSRounded, 10), true
+	case "__range_ms":
+		return strconv.FormatInt(rangeMs, 10), true
+	case "__rate_interval":
+		return v.rateInterval().String(), true
+	case "__from":
+		return formatTime(v.from.In(v.location), format), true
+	case "__to":
+		return formatTime(v.to.In(v.location), format), true
+	}
+	return "", false
+}
+
+// rateInterval max($__interval + scrape interval, 4 * scrape interval)，查询设置了min step时以其作为scrape interval，
+// min step为$__rate_interval时step已经是rate interval
+func (v *interpolation) rateInterval() time.Duration {
+	minStep := v.minStep
+	switch minStep {
+	case varRateInterval, varRateIntervalAlt:
+		return v.step
+	case varInterval, varIntervalAlt:
+		minStep = v.step.String()
+	case "":
+		minStep = v.scrapeInterval
+	}
+	return calculateRateInterval(v.queryInterval, minStep)
+}
+
+// formatTime $__from和$__to的格式：默认毫秒时间戳，date或date:iso为ISO 8601，date:seconds为秒级时间戳，
+// date:<moment格式>为自定义格式
+func formatTime(t time.Time, format string) string {
+	switch {
+	case format == "":
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	case format == "date" || format == "date:iso":
+		return t.UTC().Format("2006-01-02T15:04:05.000Z")
+	case format == "date:seconds":
+		return strconv.FormatInt(t.Unix(), 10)
+	case strings.HasPrefix(format, "date:"):
+		return t.Format(momentLayout(strings.TrimPrefix(format, "date:")))
+	}
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// momentLayout 将moment.js格式转换为go的时间格式，[]中的内容原样输出
+func momentLayout(format string) string {
+	var b strings.Builder
+	for i := 0; i < len(format); {
+		if format[i] == '[' {
+			if end := strings.IndexByte(format[i:], ']'); end > 0 {
+				b.WriteString(format[i+1 : i+end])
+				i += end + 1
+				continue
+			}
+		}
+		matched := false
+		for _, t := range momentTokens {
+			if strings.HasPrefix(format[i:], t.token) {
+				b.WriteString(t.layout)
+				i += len(t.token)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			b.WriteByte(format[i])
+			i++
+		}
+	}
+	return b.String()
+}
+
+// scoped 前端传入的变量，单值默认原样输出，多值默认输出为正则(a|b)
+func (v *interpolation) scoped(name string, field string, format string) (string, bool) {
+	sv, ok := v.scopedVars[name]
+	if !ok {
+		return "", false
+	}
+	value := sv.Value
+	if field == "text" || format == "text" {
+		value = sv.Text
+	}
+	values, multi := variableValues(value)
+	switch format {
+	case "regex":
+		return regexValues(values, multi), true
+	case "pipe":
+		return strings.Join(values, "|"), true
+	case "csv", "raw":
+		return strings.Join(values, ","), true
+	case "text":
+		return strings.Join(values, " + "), true
+	case "json":
+		var encoded interface{} = values
+		if !multi {
+			encoded = values[0]
+		}
+		b, err := json.Marshal(encoded)
+		if err != nil {
+			return "", false
+		}
+		return string(b), true
+	case "doublequote", "singlequote":
+		quote := `"`
+		if format == "singlequote" {
+			quote = "'"
+		}
+		quoted := make([]string, 0, len(values))
+		for _, s := range values {
+			quoted = append(quoted, quote+strings.ReplaceAll(s, quote, `\`+quote)+quote)
+		}
+		return strings.Join(quoted, ","), true
+	case "glob":
+		if multi {
+			return "{" + strings.Join(values, ",") + "}", true
+		}
+		return values[0], true
+	}
+	if multi {
+		return regexValues(values, multi), true
+	}
+	return values[0], true
+}
+
+// variableValues 变量值统一为字符串数组，数组类型的值视为多值
+func variableValues(value interface{}) ([]string, bool) {
+	switch val := value.(type) {
+	case []interface{}:
+		values := make([]string, 0, len(val))
+		for _, item := range val {
+			values = append(values, fmt.Sprint(item))
+		}
+		if len(values) == 0 {
+			return []string{""}, false
+		}
+		return values, len(values) > 1
+	case []string:
+		if len(val) == 0 {
+			return []string{""}, false
+		}
+		return val, len(val) > 1
+	case nil:
+		return []string{""}, false
+	default:
+		return []string{fmt.Sprint(val)}, false
+	}
+}
+
+// regexValues 与grafana prometheus数据源一致，正则元字符转义后放在promql字符串中，多值时输出(a|b)
+func regexValues(values []string, multi bool) string {
+	escaped := make([]string, 0, len(values))
+	for _, s := range values {
+		escaped = append(escaped, strings.ReplaceAll(regexp.QuoteMeta(s), `\`, `\\`))
+	}
+	if !multi {
+		return escaped[0]
+	}
+	return "(" + strings.Join(escaped, "|") + ")"
+}
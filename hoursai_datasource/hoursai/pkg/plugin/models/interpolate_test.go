@@ -0,0 +1,224 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/intervalv2"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+var (
+	testFrom = time.Unix(1700000000, 0).UTC()
+	testTo   = testFrom.Add(48 * time.Hour)
+)
+
+func newTestInterpolation(minStep string, scrapeInterval string, offset int64,
+	scopedVars map[string]ScopedVar) *interpolation {
+	model := &QueryModel{Interval: minStep, UtcOffsetSec: offset, ScopedVars: scopedVars}
+	query := backend.DataQuery{
+		TimeRange: backend.TimeRange{From: testFrom, To: testTo},
+		Interval:  time.Minute,
+	}
+	return newInterpolation(model, query, 5*time.Minute, scrapeInterval, LoadLocation("", offset))
+}
+
+func TestInterpolateBuiltinVariables(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want string
+	}{
+		{"interval", "rate(x[$__interval])", "rate(x[5m])"},
+		{"interval braces", "rate(x[${__interval}])", "rate(x[5m])"},
+		{"interval ms", "x / $__interval_ms", "x / 300000"},
+		{"interval ms braces", "x / ${__interval_ms}", "x / 300000"},
+		{"range", "increase(x[$__range])", "increase(x[172800s])"},
+		{"range seconds", "x / $__range_s", "x / 172800"},
+		{"range milliseconds", "x / ${__range_ms}", "x / 172800000"},
+		{"from milliseconds", "x @ $__from", "x @ 1700000000000"},
+		{"to seconds", "x @ ${__to:date:seconds}", "x @ 1700172800"},
+		{"from iso", `label_replace(x, "t", "${__from:date}", "", "")`,
+			`label_replace(x, "t", "2023-11-14T22:13:20.000Z", "", "")`},
+		{"from iso explicit", `"${__from:date:iso}"`, `"2023-11-14T22:13:20.000Z"`},
+		{"from custom format", `"${__from:date:YYYY-MM-DD HH:mm}"`, `"2023-11-14 22:13"`},
+		{"to custom format with literal", `"${__to:date:[day] D MMM}"`, `"day 16 Nov"`},
+		{"several variables", "rate(x[$__interval]) / $__interval_ms", "rate(x[5m]) / 300000"},
+		{"regex group reference untouched", `label_replace(x, "a", "$1", "b", "(.*)")`,
+			`label_replace(x, "a", "$1", "b", "(.*)")`},
+		{"unknown variable untouched", `x{job="$unknown"}`, `x{job="$unknown"}`},
+	}
+	vars := newTestInterpolation("", "15s", 0, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := vars.interpolate(tt.expr); got != tt.want {
+				t.Errorf("interpolate(%q) = %q, want %q", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterpolateFromWithUtcOffset(t *testing.T) {
+	vars := newTestInterpolation("", "15s", 8*3600, nil)
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"${__from:date:YYYY-MM-DD HH:mm}", "2023-11-15 06:13"},
+		// 毫秒时间戳和ISO格式与时区无关
+		{"$__from", "1700000000000"},
+		{"${__from:date}", "2023-11-14T22:13:20.000Z"},
+	}
+	for _, tt := range tests {
+		if got := vars.interpolate(tt.expr); got != tt.want {
+			t.Errorf("interpolate(%q) = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+// 与grafana一致：max($__interval + scrape interval, 4 * scrape interval)，设置了min step时以min step作为scrape interval
+func TestInterpolateRateInterval(t *testing.T) {
+	tests := []struct {
+		name           string
+		minStep        string
+		scrapeInterval string
+		want           string
+	}{
+		{"datasource scrape interval", "", "15s", "1m15s"},
+		{"default scrape interval", "", "", "1m15s"},
+		{"large scrape interval", "", "1m", "4m0s"},
+		{"min step overrides scrape interval", "30s", "15s", "2m0s"},
+		{"min step shorter than scrape interval", "5s", "15s", "1m5s"},
+		{"min step is interval variable", "$__interval", "15s", "20m0s"},
+		{"min step is rate interval", "$__rate_interval", "15s", "5m0s"},
+		{"min step is rate interval with braces", "${__rate_interval}", "15s", "5m0s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := newTestInterpolation(tt.minStep, tt.scrapeInterval, 0, nil)
+			if got := vars.interpolate("$__rate_interval"); got != tt.want {
+				t.Errorf("$__rate_interval = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterpolateScopedVariables(t *testing.T) {
+	scopedVars := map[string]ScopedVar{
+		"job":      {Text: "API server", Value: "api"},
+		"pattern":  {Text: "a.b", Value: "a.b"},
+		"instance": {Text: []interface{}{"a:9090", "b.local"}, Value: []interface{}{"a:9090", "b.local"}},
+		"single":   {Text: "only", Value: []interface{}{"only"}},
+		"quoted":   {Text: `say "hi"`, Value: `say "hi"`},
+	}
+	tests := []struct {
+		name string
+		expr string
+		want string
+	}{
+		{"dollar", `up{job="$job"}`, `up{job="api"}`},
+		{"brackets", `up{job="[[job]]"}`, `up{job="api"}`},
+		{"braces", `up{job="${job}"}`, `up{job="api"}`},
+		{"text field", `up{job="${job.text}"}`, `up{job="API server"}`},
+		{"text format", `up{job="${job:text}"}`, `up{job="API server"}`},
+		{"multi value default regex", `up{instance=~"$instance"}`, `up{instance=~"(a:9090|b\\.local)"}`},
+		{"multi value single item", `up{instance=~"$single"}`, `up{instance=~"only"}`},
+		{"regex format escapes single value", `up{job=~"${pattern:regex}"}`, `up{job=~"a\\.b"}`},
+		{"pipe", `up{instance=~"${instance:pipe}"}`, `up{instance=~"a:9090|b.local"}`},
+		{"csv", `"${instance:csv}"`, `"a:9090,b.local"`},
+		{"raw", `"${instance:raw}"`, `"a:9090,b.local"`},
+		{"brackets with format", `"[[instance:pipe]]"`, `"a:9090|b.local"`},
+		{"json multi", `${instance:json}`, `["a:9090","b.local"]`},
+		{"json single", `${job:json}`, `"api"`},
+		{"doublequote", `${instance:doublequote}`, `"a:9090","b.local"`},
+		{"doublequote escapes quotes", `${quoted:doublequote}`, `"say \"hi\""`},
+		{"singlequote", `${instance:singlequote}`, `'a:9090','b.local'`},
+		{"glob", `${instance:glob}`, `{a:9090,b.local}`},
+		{"builtin wins over scoped", `rate(x[$__interval])`, `rate(x[5m])`},
+	}
+	vars := newTestInterpolation("", "15s", 0, scopedVars)
+	vars.scopedVars["__interval"] = ScopedVar{Text: "1m", Value: "1m"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := vars.interpolate(tt.expr); got != tt.want {
+				t.Errorf("interpolate(%q) = %q, want %q", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMomentLayout(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"YYYY-MM-DD", "2006-01-02"},
+		{"YY/M/D", "06/1/2"},
+		{"HH:mm:ss.SSS", "15:04:05.000"},
+		{"h:mm A", "3:04 PM"},
+		{"dddd, MMMM D", "Monday, January 2"},
+		{"ddd MMM", "Mon Jan"},
+		{"YYYY-MM-DDTHH:mm:ssZ", "2006-01-02T15:04:05-07:00"},
+		{"[week of] YYYY", "week of 2006"},
+	}
+	for _, tt := range tests {
+		if got := momentLayout(tt.format); got != tt.want {
+			t.Errorf("momentLayout(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestParseInterpolatesExpressions(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		wantExpr string
+		wantStep time.Duration
+		wantMore []string
+	}{
+		{
+			name:     "rate interval uses min step as scrape interval",
+			json:     `{"expr":"rate(x[$__rate_interval])","interval":"30s"}`,
+			wantExpr: "rate(x[2m0s])",
+			wantStep: 30 * time.Second,
+		},
+		{
+			name:     "scoped variables",
+			json:     `{"expr":"up{job=~\"$job\"}","scopedVars":{"job":{"text":"All","value":["a","b"]}}}`,
+			wantExpr: `up{job=~"(a|b)"}`,
+			wantStep: 15 * time.Second,
+		},
+		{
+			name:     "multivariate expressions",
+			json:     `{"expr":"","exprs":["rate(x[$__interval])","y / $__range_s"],"interval":"1m"}`,
+			wantStep: time.Minute,
+			wantMore: []string{"rate(x[1m])", "y / 3600"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := backend.DataQuery{
+				JSON:          []byte(tt.json),
+				TimeRange:     backend.TimeRange{From: testFrom, To: testFrom.Add(time.Hour)},
+				Interval:      time.Minute,
+				MaxDataPoints: 1000,
+			}
+			q, err := Parse(query, "15s", intervalv2.NewCalculator(), []byte(`{}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.Expr != tt.wantExpr {
+				t.Errorf("Expr = %q, want %q", q.Expr, tt.wantExpr)
+			}
+			if q.Step != tt.wantStep {
+				t.Errorf("Step = %s, want %s", q.Step, tt.wantStep)
+			}
+			for i, want := range tt.wantMore {
+				if i >= len(q.Exprs) || q.Exprs[i] != want {
+					t.Errorf("Exprs = %q, want %q", q.Exprs, tt.wantMore)
+					break
+				}
+			}
+		})
+	}
+}
@@ -4,11 +4,10 @@ import (
 	"encoding/json"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"math"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/intervalv2"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
 
@@ -58,6 +57,37 @@ type QueryModel struct {
 	PanelId         int64    `json:"panelId"`
 	DashboardUID    string   `json:"dashboardUID"`
 	Series          string   `json:"series"`
+	Scene           string   `json:"scene"`
+	Exprs           []string `json:"exprs"`
+	Engine          string   `json:"engine"`
+	TopK            int      `json:"topK"`
+	TopKBy          string   `json:"topKBy"`
+	MaxSeries       int64    `json:"maxSeries"`
+	MaxPoints       int64    `json:"maxPoints"`
+	CardinalityMode string   `json:"cardinalityStrategy"`
+	MissingPolicy   string   `json:"missingPolicy"`
+	Transform       string   `json:"transform"`
+	CounterHandling string   `json:"counterHandling"`
+	PointBudget     int64    `json:"pointBudget"`
+	Downsample      string   `json:"downsampleMethod"`
+	MinConsecutive  int64    `json:"minConsecutive"`
+	MinSignificance float64  `json:"minSignificance"`
+	Cooldown        string   `json:"cooldown"`
+	Direction       string   `json:"anomalyDirection"`
+	// Severity 异常点严重级别的阈值，未设置时使用数据源配置的阈值
+	Severity *SeverityTiers `json:"severityTiers"`
+	// ClassifySeverity 没有配置阈值时按算法的默认阈值分级
+	ClassifySeverity bool `json:"classifySeverity"`
+	// SeasonalOffsets 本地同比查询的历史偏移，如1d、7d，多个偏移时取中位数作为基线
+	SeasonalOffsets []string `json:"seasonalOffsets"`
+	// SeasonalThreshold 本地同比查询判定异常的偏离比例
+	SeasonalThreshold float64 `json:"seasonalThreshold"`
+	// ScopedVars 前端传入的变量，如repeat面板的变量和仪表盘变量
+	ScopedVars map[string]ScopedVar `json:"scopedVars"`
+	// AdHocFilters 仪表盘的即席过滤条件
+	AdHocFilters []AdHocFilter `json:"adhocFilters"`
+	// Timezone 仪表盘的IANA时区，browser或未设置时使用UtcOffsetSec
+	Timezone string `json:"timezone"`
 }
 
 type TimeRange struct {
@@ -67,35 +97,74 @@ type TimeRange struct {
 }
 
 type Query struct {
-	Expr            string
-	Step            time.Duration
-	LegendFormat    string
-	Start           time.Time
-	End             time.Time
-	RefId           string
-	InstantQuery    bool
-	RangeQuery      bool
-	ExemplarQuery   bool
-	UtcOffsetSec    int64
-	Name            string
-	Version         string
-	Params          string
-	QueryType       string
-	JsonData        json.RawMessage
-	AlgorithmList   bool
-	TaskInfo        []string
-	AlertEnable     bool
-	AlertTemplateId int64
-	PanelId         int64
-	DashboardUID    string
-	Series          string
+	Expr              string
+	Step              time.Duration
+	LegendFormat      string
+	Start             time.Time
+	End               time.Time
+	RefId             string
+	InstantQuery      bool
+	RangeQuery        bool
+	ExemplarQuery     bool
+	UtcOffsetSec      int64
+	Name              string
+	Version           string
+	Params            string
+	QueryType         string
+	JsonData          json.RawMessage
+	AlgorithmList     bool
+	TaskInfo          []string
+	AlertEnable       bool
+	AlertTemplateId   int64
+	PanelId           int64
+	DashboardUID      string
+	Series            string
+	Scene             string
+	Exprs             []string
+	Engine            string
+	TopK              int
+	TopKBy            string
+	MaxSeries         int64
+	MaxPoints         int64
+	CardinalityMode   string
+	MissingPolicy     string
+	Transform         string
+	CounterHandling   string
+	PointBudget       int64
+	Downsample        string
+	MinConsecutive    int64
+	MinSignificance   float64
+	Cooldown          string
+	Direction         string
+	Severity          *SeverityTiers
+	ClassifySeverity  bool
+	SeasonalOffsets   []string
+	SeasonalThreshold float64
+	Timezone          string
+	location          *time.Location
+	// Calendar 数据源配置的节假日和维护窗口，解析查询后按时间范围生成
+	Calendar *Calendar
+}
+
+// Location 查询的时区，未指定IANA时区时为UtcOffsetSec对应的固定偏移
+func (query *Query) Location() *time.Location {
+	if query.location == nil {
+		query.location = LoadLocation(query.Timezone, query.UtcOffsetSec)
+	}
+	return query.location
+}
+
+// TimezoneName 传给算法的时区，IANA名称或+08:00形式的固定偏移
+func (query *Query) TimezoneName() string {
+	return query.Location().String()
 }
 
 func (query *Query) TimeRange() TimeRange {
+	loc := query.Location()
 	return TimeRange{
 		Step:  query.Step,
-		Start: AlignTimeRange(query.Start, query.Step, query.UtcOffsetSec),
-		End:   AlignTimeRange(query.End, query.Step, query.UtcOffsetSec),
+		Start: AlignTimeRangeIn(query.Start, query.Step, loc),
+		End:   AlignTimeRangeIn(query.End, query.Step, loc),
 	}
 }
 
@@ -123,72 +192,81 @@ func Parse(query backend.DataQuery, timeInterval string, intervalCalculator inte
 		return nil, err
 	}
 
-	timeRange := query.TimeRange.To.Sub(query.TimeRange.From)
-	expr := interpolateVariables(model, interval, timeRange, timeInterval)
+	timezone, err := resolveTimezone(model.Timezone, jsonData)
+	if err != nil {
+		return nil, err
+	}
+	location := LoadLocation(timezone, model.UtcOffsetSec)
+	matchers, err := AdHocMatchers(model.AdHocFilters)
+	if err != nil {
+		return nil, err
+	}
+	vars := newInterpolation(model, query, interval, timeInterval, location)
+	expr := withAdHocFilters(vars.interpolate(model.Expr), matchers)
+	// 多变量查询的每个表达式同样需要替换变量和加入即席过滤条件
+	exprs := make([]string, 0, len(model.Exprs))
+	for _, e := range model.Exprs {
+		exprs = append(exprs, withAdHocFilters(vars.interpolate(e), matchers))
+	}
 	rangeQuery := model.RangeQuery
 	if !model.InstantQuery && !model.RangeQuery {
 		rangeQuery = true
 	}
+	// 未指定场景时沿用异常检测场景
+	scene := model.Scene
+	if scene == "" {
+		scene = util.SceneAnomalyDetection
+	}
 
 	return &Query{
-		Expr:            expr,
-		Step:            interval,
-		LegendFormat:    model.LegendFormat,
-		Start:           query.TimeRange.From,
-		End:             query.TimeRange.To,
-		RefId:           query.RefID,
-		InstantQuery:    model.InstantQuery,
-		RangeQuery:      rangeQuery,
-		ExemplarQuery:   model.ExemplarQuery,
-		UtcOffsetSec:    model.UtcOffsetSec,
-		Name:            model.Name,
-		Version:         model.Version,
-		Params:          model.Params,
-		QueryType:       model.QueryType,
-		JsonData:        jsonData,
-		AlgorithmList:   model.AlgorithmList,
-		TaskInfo:        model.TaskInfo,
-		AlertEnable:     model.AlertEnable,
-		AlertTemplateId: model.AlertTemplateId,
-		PanelId:         model.PanelId,
-		DashboardUID:    model.DashboardUID,
-		Series:          model.Series,
+		Expr:              expr,
+		Step:              interval,
+		LegendFormat:      model.LegendFormat,
+		Start:             query.TimeRange.From,
+		End:               query.TimeRange.To,
+		RefId:             query.RefID,
+		InstantQuery:      model.InstantQuery,
+		RangeQuery:        rangeQuery,
+		ExemplarQuery:     model.ExemplarQuery,
+		UtcOffsetSec:      model.UtcOffsetSec,
+		Name:              model.Name,
+		Version:           model.Version,
+		Params:            model.Params,
+		QueryType:         model.QueryType,
+		JsonData:          jsonData,
+		AlgorithmList:     model.AlgorithmList,
+		TaskInfo:          model.TaskInfo,
+		AlertEnable:       model.AlertEnable,
+		AlertTemplateId:   model.AlertTemplateId,
+		PanelId:           model.PanelId,
+		DashboardUID:      model.DashboardUID,
+		Series:            model.Series,
+		Scene:             scene,
+		Exprs:             exprs,
+		Engine:            model.Engine,
+		TopK:              model.TopK,
+		TopKBy:            model.TopKBy,
+		MaxSeries:         model.MaxSeries,
+		MaxPoints:         model.MaxPoints,
+		CardinalityMode:   model.CardinalityMode,
+		MissingPolicy:     model.MissingPolicy,
+		Transform:         model.Transform,
+		CounterHandling:   model.CounterHandling,
+		PointBudget:       model.PointBudget,
+		Downsample:        model.Downsample,
+		MinConsecutive:    model.MinConsecutive,
+		MinSignificance:   model.MinSignificance,
+		Cooldown:          model.Cooldown,
+		Direction:         model.Direction,
+		Severity:          model.Severity,
+		ClassifySeverity:  model.ClassifySeverity,
+		SeasonalOffsets:   model.SeasonalOffsets,
+		SeasonalThreshold: model.SeasonalThreshold,
+		Timezone:          timezone,
+		location:          location,
 	}, nil
 }
 
-// interpolateVariables 按照单位替换promql中的interval占位符
-func interpolateVariables(model *QueryModel, interval time.Duration, timeRange time.Duration,
-	timeInterval string) string {
-	expr := model.Expr
-	log.DefaultLogger.Info("Before expr process ", expr)
-	rangeMs := timeRange.Milliseconds()
-	rangeSRounded := int64(math.Round(float64(rangeMs) / 1000.0))
-
-	var rateInterval time.Duration
-	if model.Interval == varRateInterval || model.Interval == varRateIntervalAlt {
-		rateInterval = interval
-	} else {
-		rateInterval = calculateRateInterval(interval, timeInterval)
-	}
-
-	expr = strings.ReplaceAll(expr, varIntervalMs, strconv.FormatInt(int64(interval/time.Millisecond), 10))
-	expr = strings.ReplaceAll(expr, varInterval, intervalv2.FormatDuration(interval))
-	expr = strings.ReplaceAll(expr, varRangeMs, strconv.FormatInt(rangeMs, 10))
-	expr = strings.ReplaceAll(expr, varRangeS, strconv.FormatInt(rangeSRounded, 10))
-	expr = strings.ReplaceAll(expr, varRange, strconv.FormatInt(rangeSRounded, 10)+"s")
-	expr = strings.ReplaceAll(expr, varRateInterval, rateInterval.String())
-
-	// Repetitive code, we should have functionality to unify these
-	expr = strings.ReplaceAll(expr, varIntervalMsAlt, strconv.FormatInt(int64(interval/time.Millisecond), 10))
-	expr = strings.ReplaceAll(expr, varIntervalAlt, intervalv2.FormatDuration(interval))
-	expr = strings.ReplaceAll(expr, varRangeMsAlt, strconv.FormatInt(rangeMs, 10))
-	expr = strings.ReplaceAll(expr, varRangeSAlt, strconv.FormatInt(rangeSRounded, 10))
-	expr = strings.ReplaceAll(expr, varRangeAlt, strconv.FormatInt(rangeSRounded, 10)+"s")
-	expr = strings.ReplaceAll(expr, varRateIntervalAlt, rateInterval.String())
-	log.DefaultLogger.Info("After expr process ", expr)
-	return expr
-}
-
 func calculatePrometheusInterval(model *QueryModel, timeInterval string, query backend.DataQuery,
 	intervalCalculator intervalv2.Calculator) (time.Duration, error) {
 	qInterval := model.Interval
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"errors"
+	"math"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+)
+
+// SeverityThreshold 达到任一阈值即满足该级别，0表示不使用该条件
+type SeverityThreshold struct {
+	Significance float64 `json:"significance"`
+	Deviation    float64 `json:"deviation"`
+}
+
+// SeverityTiers 异常点的严重级别阈值，deviation为相对基线的偏离比例|value-baseline|/|baseline|，
+// 两个级别都不满足的异常点为info
+type SeverityTiers struct {
+	Warning  SeverityThreshold `json:"warning"`
+	Critical SeverityThreshold `json:"critical"`
+}
+
+// Empty 未配置任何阈值
+func (t *SeverityTiers) Empty() bool {
+	return t == nil || *t == SeverityTiers{}
+}
+
+// Validate 阈值不能为负数
+func (t *SeverityTiers) Validate() error {
+	if t == nil {
+		return nil
+	}
+	for _, v := range []float64{t.Warning.Significance, t.Warning.Deviation, t.Critical.Significance,
+		t.Critical.Deviation} {
+		if v < 0 || math.IsNaN(v) {
+			return errors.New("severity thresholds should not be negative")
+		}
+	}
+	return nil
+}
+
+func (th SeverityThreshold) reached(significance, deviation *float64) bool {
+	return (th.Significance > 0 && significance != nil && *significance >= th.Significance) ||
+		(th.Deviation > 0 && deviation != nil && *deviation >= th.Deviation)
+}
+
+// Classify 异常点的严重级别，缺少显著性或偏离比例时对应条件不生效
+func (t *SeverityTiers) Classify(significance, deviation *float64) string {
+	if t == nil {
+		return util.SeverityInfo
+	}
+	if t.Critical.reached(significance, deviation) {
+		return util.SeverityCritical
+	}
+	if t.Warning.reached(significance, deviation) {
+		return util.SeverityWarning
+	}
+	return util.SeverityInfo
+}
+
+// Deviation 相对基线的偏离比例，基线为0时无法计算
+func Deviation(value, baseline float64) *float64 {
+	if baseline == 0 || math.IsNaN(value) || math.IsNaN(baseline) {
+		return nil
+	}
+	d := math.Abs(value-baseline) / math.Abs(baseline)
+	return &d
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+)
+
+func TestSeverityTiersClassify(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	tiers := &SeverityTiers{
+		Warning:  SeverityThreshold{Significance: 0.8, Deviation: 0.2},
+		Critical: SeverityThreshold{Significance: 0.95, Deviation: 0.5},
+	}
+	tests := []struct {
+		name         string
+		tiers        *SeverityTiers
+		significance *float64
+		deviation    *float64
+		want         string
+	}{
+		{"below warning", tiers, f(0.5), f(0.1), util.SeverityInfo},
+		{"warning by significance", tiers, f(0.85), f(0.1), util.SeverityWarning},
+		{"warning by deviation", tiers, f(0.5), f(0.3), util.SeverityWarning},
+		{"critical by significance", tiers, f(0.99), f(0.1), util.SeverityCritical},
+		{"critical by deviation", tiers, f(0.5), f(0.6), util.SeverityCritical},
+		{"missing deviation", tiers, f(0.9), nil, util.SeverityWarning},
+		{"missing both", tiers, nil, nil, util.SeverityInfo},
+		{"deviation only tiers ignore significance", &SeverityTiers{
+			Warning: SeverityThreshold{Deviation: 0.2}}, f(1), f(0.1), util.SeverityInfo},
+		{"nil tiers", nil, f(1), f(1), util.SeverityInfo},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.tiers.Classify(tt.significance, tt.deviation); got != tt.want {
+				t.Errorf("Classify() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeviation(t *testing.T) {
+	if d := Deviation(120, 100); d == nil || *d < 0.1999 || *d > 0.2001 {
+		t.Errorf("Deviation(120, 100) = %v, want 0.2", d)
+	}
+	if d := Deviation(80, -100); d == nil || *d < 1.7999 || *d > 1.8001 {
+		t.Errorf("Deviation(80, -100) = %v, want 1.8", d)
+	}
+	if d := Deviation(1, 0); d != nil {
+		t.Errorf("Deviation(1, 0) = %v, want nil", *d)
+	}
+}
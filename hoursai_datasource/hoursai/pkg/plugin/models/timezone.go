@@ -0,0 +1,85 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	// 插件可能运行在没有时区数据库的系统上
+	_ "time/tzdata"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const day = 24 * time.Hour
+
+// timezoneBrowser grafana仪表盘使用浏览器时区时传入browser，此时只能使用前端传入的utcOffsetSec
+const timezoneBrowser = "browser"
+
+// resolveTimezone 查询设置优先，其次为数据源配置；浏览器时区和未设置时返回空，使用固定的utcOffsetSec
+func resolveTimezone(timezone string, jsonData json.RawMessage) (string, error) {
+	if timezone == "" || timezone == timezoneBrowser {
+		var settings map[string]interface{}
+		if err := json.Unmarshal(jsonData, &settings); err != nil {
+			log.DefaultLogger.Error("Query json data to map error, error is: ", err)
+		}
+		var err error
+		if timezone, err = util.GetStringOptional(settings, "timezone"); err != nil {
+			return "", err
+		}
+	}
+	switch strings.ToLower(timezone) {
+	case "", timezoneBrowser:
+		return "", nil
+	case "utc":
+		return "UTC", nil
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return "", fmt.Errorf("unknown timezone %q, expected an IANA name such as Asia/Shanghai", timezone)
+	}
+	return timezone, nil
+}
+
+// LoadLocation IANA时区，browser或未指定时为utcOffsetSec对应的固定偏移时区，名称为+08:00的形式，偏移为0时为UTC
+func LoadLocation(timezone string, utcOffsetSec int64) *time.Location {
+	switch strings.ToLower(timezone) {
+	case "", timezoneBrowser:
+		timezone = ""
+	case "utc":
+		return time.UTC
+	}
+	if timezone != "" {
+		if loc, err := time.LoadLocation(timezone); err == nil {
+			return loc
+		}
+		log.DefaultLogger.Error("Load timezone error, fall back to utc offset", "timezone", timezone)
+	}
+	if utcOffsetSec == 0 {
+		return time.UTC
+	}
+	sign, offset := "+", utcOffsetSec
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	return time.FixedZone(fmt.Sprintf("%s%02d:%02d", sign, offset/3600, offset%3600/60), int(utcOffsetSec))
+}
+
+// AlignTimeRangeIn 按时区对齐时间，整天的step对齐到当地零点，夏令时切换的日期同样从当地零点开始；
+// 小于一天的step按该时刻的时区偏移对齐
+func AlignTimeRangeIn(t time.Time, step time.Duration, loc *time.Location) time.Time {
+	if step <= 0 {
+		return t.UTC()
+	}
+	local := t.In(loc)
+	if step%day != 0 {
+		_, offset := local.Zone()
+		return AlignTimeRange(t, step, int64(offset))
+	}
+	// 按当地日历从1970-01-01起的天数对齐，固定偏移时与AlignTimeRange的结果一致
+	y, m, d := local.Date()
+	days, n := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix()/86400, int64(step/day)
+	days -= ((days % n) + n) % n
+	aligned := time.Unix(days*86400, 0).UTC()
+	return time.Date(aligned.Year(), aligned.Month(), aligned.Day(), 0, 0, 0, 0, loc).UTC()
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlignTimeRangeIn(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		t    string
+		step time.Duration
+		loc  *time.Location
+		want string
+	}{
+		{"daily before spring forward", "2024-03-09T20:00:00Z", day, newYork, "2024-03-09T05:00:00Z"},
+		// 夏令时开始当天仍从当地零点（EST）开始
+		{"daily on spring forward", "2024-03-10T19:00:00Z", day, newYork, "2024-03-10T05:00:00Z"},
+		{"daily after spring forward", "2024-03-11T19:00:00Z", day, newYork, "2024-03-11T04:00:00Z"},
+		{"daily on fall back", "2024-11-03T20:00:00Z", day, newYork, "2024-11-03T04:00:00Z"},
+		{"weekly", "2024-03-13T19:00:00Z", 7 * day, newYork, "2024-03-07T05:00:00Z"},
+		{"hourly uses current offset", "2024-03-10T19:37:00Z", time.Hour, newYork, "2024-03-10T19:00:00Z"},
+		{"fixed offset daily", "2023-11-14T22:13:20Z", day, LoadLocation("", 8*3600), "2023-11-14T16:00:00Z"},
+		{"utc", "2023-11-14T22:13:20Z", 5 * time.Minute, time.UTC, "2023-11-14T22:10:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, _ := time.Parse(time.RFC3339, tt.t)
+			if got := AlignTimeRangeIn(ts, tt.step, tt.loc).Format(time.RFC3339); got != tt.want {
+				t.Errorf("AlignTimeRangeIn(%s, %s, %s) = %s, want %s", tt.t, tt.step, tt.loc, got, tt.want)
+			}
+		})
+	}
+}
+
+// 固定偏移时与AlignTimeRange的结果一致
+func TestAlignTimeRangeInFixedOffset(t *testing.T) {
+	for _, offset := range []int64{0, 8 * 3600, -5 * 3600, 5*3600 + 1800} {
+		for _, step := range []time.Duration{time.Minute, time.Hour, day, 7 * day} {
+			ts := time.Unix(1700000000, 0)
+			want := AlignTimeRange(ts, step, offset)
+			if got := AlignTimeRangeIn(ts, step, LoadLocation("", offset)); !got.Equal(want) {
+				t.Errorf("offset %d step %s: got %s, want %s", offset, step, got, want)
+			}
+		}
+	}
+}
+
+func TestResolveTimezone(t *testing.T) {
+	tests := []struct {
+		timezone string
+		jsonData string
+		want     string
+		wantErr  bool
+	}{
+		{"Europe/Berlin", `{"timezone":"Asia/Shanghai"}`, "Europe/Berlin", false},
+		{"browser", `{"timezone":"Asia/Shanghai"}`, "Asia/Shanghai", false},
+		{"", `{}`, "", false},
+		{"utc", `{}`, "UTC", false},
+		{"Mars/Olympus", `{}`, "", true},
+	}
+	for _, tt := range tests {
+		got, err := resolveTimezone(tt.timezone, []byte(tt.jsonData))
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("resolveTimezone(%q, %s) = %q, %v, want %q", tt.timezone, tt.jsonData, got, err, tt.want)
+		}
+	}
+	if name := LoadLocation("", -5*3600-1800).String(); name != "-05:30" {
+		t.Errorf("fixed offset name = %q, want -05:30", name)
+	}
+}
@@ -19,15 +19,25 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 )
 
+// defaultManagerMaxInFlight 单个数据源同时进行中的manager请求数上限
+const defaultManagerMaxInFlight = 10
+
 type Datasource struct {
 	settings        backend.DataSourceInstanceSettings
 	httpClient      *http.Client
 	resourceHandler backend.CallResourceHandler
+	managerPolicy   *client.ManagerPolicy
+	fetchGroup      *querydata.FetchGroup
+	metadataCache   *querydata.Cache
+	// annotationClient 读取grafana注释的客户端，沿用数据源的代理、TLS和超时配置，但不带prometheus的认证信息
+	annotationClient *http.Client
+	annotationCache  *querydata.Cache
 }
 
 func (d *Datasource) Dispose() {
 	// Clean up datasource instance resources.
 	d.httpClient.CloseIdleConnections()
+	d.annotationClient.CloseIdleConnections()
 }
 
 // NewSampleDatasource creates a new datasource instance.
@@ -40,12 +50,58 @@ func NewSampleDatasource(settings backend.DataSourceInstanceSettings) (instancem
 	if err != nil {
 		return nil, fmt.Errorf("httpclient new: %w", err)
 	}
+	annotationOpts := opts
+	annotationOpts.BasicAuth = nil
+	annotationOpts.SigV4 = nil
+	annotationOpts.Headers = nil
+	annotationClient, err := httpclient.New(annotationOpts)
+	if err != nil {
+		return nil, fmt.Errorf("annotation httpclient new: %w", err)
+	}
+	managerPolicy, err := newManagerPolicy(settings)
+	if err != nil {
+		return nil, err
+	}
 	return &Datasource{
-		settings:   settings,
-		httpClient: cl,
+		settings:         settings,
+		httpClient:       cl,
+		managerPolicy:    managerPolicy,
+		fetchGroup:       querydata.NewFetchGroup(),
+		metadataCache:    querydata.NewMetadataCache(),
+		annotationClient: annotationClient,
+		annotationCache:  querydata.NewAnnotationCache(),
 	}, nil
 }
 
+// newManagerPolicy 按数据源配置创建manager熔断器和限流器，同一数据源的所有请求共享
+func newManagerPolicy(settings backend.DataSourceInstanceSettings) (*client.ManagerPolicy, error) {
+	jsonData, err := util.GetJsonData(settings)
+	if err != nil {
+		return nil, err
+	}
+	options := map[string]int64{
+		"breakerThreshold":       0,
+		"breakerCooldownSeconds": 0,
+		"managerRateLimit":       0,
+		"managerBurst":           0,
+		"managerMaxInFlight":     defaultManagerMaxInFlight,
+	}
+	for key := range options {
+		v, err := util.GetInt64Optional(jsonData, key)
+		if err != nil {
+			return nil, err
+		}
+		if v > 0 {
+			options[key] = v
+		}
+	}
+	breaker := client.NewCircuitBreaker(int(options["breakerThreshold"]),
+		time.Duration(options["breakerCooldownSeconds"])*time.Second)
+	limiter := client.NewRateLimiter(float64(options["managerRateLimit"]), int(options["managerBurst"]),
+		int(options["managerMaxInFlight"]))
+	return client.NewManagerPolicy(breaker, limiter), nil
+}
+
 func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 
 	if len(req.Queries) == 0 {
@@ -53,9 +109,19 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 	}
 	instance, err := querydata.New(&http.Client{}, d.settings)
 	if err != nil {
+		// 数据源配置错误时每个查询都返回错误，而不是让整个请求失败
 		log.DefaultLogger.Error("Create query data instance error, error is: ", err)
-		return nil, err
+		response := backend.NewQueryDataResponse()
+		for _, q := range req.Queries {
+			response.Responses[q.RefID] = util.ErrorResponse(util.PluginError(backend.StatusInternal,
+				fmt.Errorf("invalid datasource settings: %w", err)), "")
+		}
+		return response, nil
 	}
+	instance.SetManagerPolicy(d.managerPolicy)
+	instance.SetFetchGroup(d.fetchGroup)
+	instance.SetMetadataCache(d.metadataCache)
+	instance.SetAnnotationSource(d.annotationClient, d.annotationCache)
 	result, err := instance.Execute(ctx, req)
 
 	return result, err
@@ -73,22 +139,45 @@ func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRe
 		status = backend.HealthStatusError
 		message = err.Error()
 		log.DefaultLogger.Error("CheckHealth error, error is ", err)
-	}
+	} else {
+		defer func() {
+			if err := result.Body.Close(); err != nil {
+				log.DefaultLogger.Error("Failed to close response body", "err", err)
+			}
+		}()
+		respJson, err := ioutil.ReadAll(result.Body)
+		if err != nil {
+			log.DefaultLogger.Error("Error is: ", err)
+		}
 
-	respJson, err := ioutil.ReadAll(result.Body)
-	if err != nil {
-		log.DefaultLogger.Error("Error is: ", err)
+		log.DefaultLogger.Info("CheckHealth result is: ", string(respJson))
+		if strings.Contains(string(respJson), "Healthy.") {
+			status = backend.HealthStatusOk
+			message = "Data source is working."
+		} else {
+			status = backend.HealthStatusError
+			message = fmt.Sprintf("Prometheus health check returned HTTP %d: %s", result.StatusCode,
+				strings.TrimSpace(string(respJson)))
+		}
 	}
 
-	log.DefaultLogger.Info("CheckHealth result is: ", string(respJson))
-	if strings.Contains(string(respJson), "Healthy.") {
-		status = backend.HealthStatusOk
-		message = "Data source is working."
+	// 展示manager熔断器状态
+	breakerState, breakerMessage := d.managerPolicy.GetBreaker().Status()
+	if breakerMessage != "" {
+		message = message + " " + breakerMessage
+		if breakerState == client.BreakerOpen && status == backend.HealthStatusOk {
+			status = backend.HealthStatusUnknown
+		}
+	}
+	details, err := json.Marshal(map[string]string{"managerCircuitBreaker": breakerState})
+	if err != nil {
+		log.DefaultLogger.Error("Health details to json error, error is: ", err)
 	}
 
 	return &backend.CheckHealthResult{
-		Status:  status,
-		Message: message,
+		Status:      status,
+		Message:     message,
+		JSONDetails: details,
 	}, nil
 }
 
@@ -161,61 +250,61 @@ func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResource
 	instance, err := querydata.New(&http.Client{}, d.settings)
 	if err != nil {
 		log.DefaultLogger.Error("Create query data instance error, error is: ", err)
-		return sender.Send(&backend.CallResourceResponse{
-			Status: http.StatusOK,
-			Body:   []byte(err.Error()),
-		})
+		return sendError(sender, util.PluginError(backend.StatusInternal,
+			fmt.Errorf("invalid datasource settings: %w", err)))
 	}
 
+	instance.SetManagerPolicy(d.managerPolicy)
+
 	// 解析出managerUrl字段
-	jsonMap := make(map[string]string)
-	if err := json.Unmarshal(instance.JsonData, &jsonMap); err != nil {
+	jsonMap, err := util.JsonDataToStringMap(instance.JsonData)
+	if err != nil {
 		log.DefaultLogger.Error("Query json data to map error, error is: ", err)
-		return sender.Send(&backend.CallResourceResponse{
-			Status: http.StatusOK,
-			Body:   []byte(err.Error()),
-		})
+		return sendError(sender, util.PluginError(backend.StatusInternal,
+			fmt.Errorf("invalid datasource settings: %w", err)))
 	}
 	// 处理json数据
 	var bodyMap map[string]interface{}
 	if err = json.Unmarshal(req.Body, &bodyMap); err != nil {
 		log.DefaultLogger.Error("Body to map error, error is: ", err)
-		return sender.Send(&backend.CallResourceResponse{
-			Status: http.StatusInternalServerError,
-			Body:   []byte(err.Error()),
-		})
+		return sendError(sender, util.PluginError(backend.StatusBadRequest, fmt.Errorf("invalid request body: %w", err)))
 	}
-	if manageUrl, ok := bodyMap["hoursAIUrl"]; ok {
-		jsonMap["managerUrl"] = manageUrl.(string)
+	if manageUrl, ok := bodyMap["hoursAIUrl"].(string); ok {
+		jsonMap["managerUrl"] = manageUrl
 	}
 
 	log.DefaultLogger.Info("Json map is: ", jsonMap)
+	// 资源请求查询prometheus时与查询请求一样转发认证请求头
+	headers := util.ResourceHeaders(req.Headers)
 	var response []byte
 	switch req.Path {
 	case util.AlgorithmListType:
-		response, err = instance.CallAlgorithmBackend(ctx, req.Body, jsonMap, util.AlgorithmListType)
+		response, err = instance.CallAlgorithmBackend(ctx, req.Body, jsonMap, util.AlgorithmListType, headers)
 	case util.GenerateTokenType:
-		response, err = instance.CallAlgorithmBackend(ctx, req.Body, jsonMap, util.GenerateTokenType)
+		response, err = instance.CallAlgorithmBackend(ctx, req.Body, jsonMap, util.GenerateTokenType, headers)
 	case util.RealtimeInitType:
-		response, err = instance.CallAlgorithmBackend(ctx, req.Body, jsonMap, util.RealtimeInitType)
+		response, err = instance.CallAlgorithmBackend(ctx, req.Body, jsonMap, util.RealtimeInitType, headers)
 
 	case util.MetricsType:
-		response, err = instance.CallPrometheus(ctx, req.Body, util.MetricsType)
+		response, err = instance.CallPrometheus(ctx, req.Body, util.MetricsType, headers)
 	case util.LabelNamesType:
-		response, err = instance.CallPrometheus(ctx, req.Body, util.LabelNamesType)
+		response, err = instance.CallPrometheus(ctx, req.Body, util.LabelNamesType, headers)
+	case util.LabelValuesType:
+		response, err = instance.CallPrometheus(ctx, req.Body, util.LabelValuesType, headers)
 	case util.SeriesType:
-		response, err = instance.CallPrometheus(ctx, req.Body, util.SeriesType)
+		response, err = instance.CallPrometheus(ctx, req.Body, util.SeriesType, headers)
+	case util.MetadataType:
+		response, err = instance.CallPrometheus(ctx, req.Body, util.MetadataType, headers)
+	case util.RootCauseType:
+		response, err = instance.RootCause(ctx, req.Body, headers)
+	case util.VariableType:
+		response, err = instance.MetricFindQuery(ctx, req.Body, headers)
 	default:
-		return sender.Send(&backend.CallResourceResponse{
-			Status: http.StatusOK,
-			Body:   []byte(req.Path),
-		})
+		return sendError(sender, util.PluginError(backend.StatusNotFound,
+			fmt.Errorf("unknown resource path %s", req.Path)))
 	}
 	if err != nil {
-		return sender.Send(&backend.CallResourceResponse{
-			Status: http.StatusInternalServerError,
-			Body:   []byte(err.Error()),
-		})
+		return sendError(sender, err)
 	}
 	log.DefaultLogger.Info("Metric response is: ", string(response))
 	return sender.Send(&backend.CallResourceResponse{
@@ -223,3 +312,17 @@ func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResource
 		Body:   response,
 	})
 }
+
+// sendError 按错误类型返回对应的HTTP状态码，响应体包含错误来源和中英文信息
+func sendError(sender backend.CallResourceResponseSender, err error) error {
+	body, marshalErr := json.Marshal(util.ErrorBody(err))
+	if marshalErr != nil {
+		log.DefaultLogger.Error("Error body to json error, error is: ", marshalErr)
+		body = []byte(err.Error())
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status:  int(util.StatusFromError(err)),
+		Headers: map[string][]string{"Content-Type": {"application/json"}},
+		Body:    body,
+	})
+}
@@ -0,0 +1,282 @@
+package querydata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// annotationLimit 单次查询读取的grafana注释数量上限
+	annotationLimit = 1000
+	// annotationTTL 同一时间范围的注释在数据源级别缓存的有效期，同一仪表盘的多个面板共享
+	annotationTTL = time.Minute
+	// annotationTimeout 读取grafana注释的超时时间
+	annotationTimeout = 10 * time.Second
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// maintenanceWindow 周期性维护窗口，start为当地时间HH:mm，weekdays为空时每天生效
+type maintenanceWindow struct {
+	Name     string   `json:"name"`
+	Start    string   `json:"start"`
+	Duration string   `json:"duration"`
+	Weekdays []string `json:"weekdays"`
+
+	hour     int
+	minute   int
+	duration time.Duration
+	days     map[time.Weekday]bool
+}
+
+// calendarSettings 数据源配置的节假日、周期性维护窗口，以及作为维护窗口的grafana注释标签
+type calendarSettings struct {
+	holidays       []string
+	maintenance    []maintenanceWindow
+	annotationTags []string
+	grafanaUrl     string
+	grafanaToken   string
+}
+
+// getCalendarSettings 读取并校验日历配置，grafana地址未配置时使用grafana传给插件的GF_APP_URL
+func getCalendarSettings(jsonData map[string]interface{}, secure map[string]string) (calendarSettings, error) {
+	var (
+		settings calendarSettings
+		err      error
+	)
+	if settings.holidays, err = util.GetStringList(jsonData, "holidays"); err != nil {
+		return settings, err
+	}
+	for _, holiday := range settings.holidays {
+		if _, err := time.Parse("2006-01-02", holiday); err != nil {
+			return settings, fmt.Errorf("invalid holiday %q, expected YYYY-MM-DD", holiday)
+		}
+	}
+	if settings.maintenance, err = getMaintenanceWindows(jsonData); err != nil {
+		return settings, err
+	}
+	if settings.annotationTags, err = util.GetStringList(jsonData, "annotationTags"); err != nil {
+		return settings, err
+	}
+	if settings.grafanaUrl, err = util.GetStringOptional(jsonData, "grafanaUrl"); err != nil {
+		return settings, err
+	}
+	if settings.grafanaUrl == "" {
+		settings.grafanaUrl = os.Getenv("GF_APP_URL")
+	}
+	settings.grafanaToken = secure["grafanaToken"]
+	return settings, nil
+}
+
+func getMaintenanceWindows(jsonData map[string]interface{}) ([]maintenanceWindow, error) {
+	raw, ok := jsonData["maintenanceWindows"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var windows []maintenanceWindow
+	if err := json.Unmarshal(b, &windows); err != nil {
+		return nil, fmt.Errorf("the field 'maintenanceWindows' should be a list of windows: %w", err)
+	}
+	for i := range windows {
+		w := &windows[i]
+		start, err := time.Parse("15:04", w.Start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window start %q, expected HH:mm", w.Start)
+		}
+		w.hour, w.minute = start.Hour(), start.Minute()
+		if w.duration, err = time.ParseDuration(w.Duration); err != nil || w.duration <= 0 {
+			return nil, fmt.Errorf("invalid maintenance window duration %q", w.Duration)
+		}
+		for _, name := range w.Weekdays {
+			day, ok := weekdays[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("invalid maintenance window weekday %q, expected mon to sun", name)
+			}
+			if w.days == nil {
+				w.days = make(map[time.Weekday]bool)
+			}
+			w.days[day] = true
+		}
+	}
+	return windows, nil
+}
+
+// expand 生成与[from, to]相交的窗口，按当地日期计算，夏令时切换日同样从当地时间开始
+func (w maintenanceWindow) expand(from time.Time, to time.Time, loc *time.Location) []models.Window {
+	var windows []models.Window
+	// 前一天开始的窗口可能跨过零点
+	first := from.In(loc).Add(-w.duration)
+	day := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, loc)
+	for ; !day.After(to); day = day.AddDate(0, 0, 1) {
+		if w.days != nil && !w.days[day.Weekday()] {
+			continue
+		}
+		start := time.Date(day.Year(), day.Month(), day.Day(), w.hour, w.minute, 0, 0, loc)
+		end := start.Add(w.duration)
+		if end.After(from) && !start.After(to) {
+			windows = append(windows, models.Window{
+				Name:   w.Name,
+				Source: util.WindowMaintenance,
+				Start:  start.UnixMilli(),
+				End:    end.UnixMilli(),
+			})
+		}
+	}
+	return windows
+}
+
+// NewAnnotationCache 数据源级别共享的grafana注释缓存，按查询时间范围区分
+func NewAnnotationCache() *Cache {
+	return NewCache(annotationTTL)
+}
+
+// queryCalendar 生成查询时间范围内的节假日和维护窗口，读取grafana注释失败时只使用配置的日历并返回错误，
+// 注释数量达到上限时返回提示
+func (s *QueryData) queryCalendar(ctx context.Context, q *models.Query) (*models.Calendar, []data.Notice, error) {
+	loc := q.Location()
+	c := &models.Calendar{Timezone: q.TimezoneName()}
+	first, last := q.Start.In(loc).Format("2006-01-02"), q.End.In(loc).Format("2006-01-02")
+	for _, holiday := range s.calendar.holidays {
+		if holiday >= first && holiday <= last {
+			c.Holidays = append(c.Holidays, holiday)
+		}
+	}
+	for _, w := range s.calendar.maintenance {
+		c.Windows = append(c.Windows, w.expand(q.Start, q.End, loc)...)
+	}
+	if len(s.calendar.annotationTags) == 0 {
+		return c, nil, nil
+	}
+	annotations, err := s.annotations(ctx, q)
+	if err != nil {
+		return c, nil, err
+	}
+	c.Windows = append(c.Windows, annotationWindows(annotations, q.Step)...)
+	var notices []data.Notice
+	if len(annotations) >= annotationLimit {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text: fmt.Sprintf("Only the first %d annotations in the time range are used as maintenance windows.",
+				annotationLimit),
+		})
+	}
+	return c, notices, nil
+}
+
+// grafanaAnnotation grafana注释接口返回的字段，时间为毫秒时间戳
+type grafanaAnnotation struct {
+	Text    string `json:"text"`
+	Time    int64  `json:"time"`
+	TimeEnd int64  `json:"timeEnd"`
+}
+
+// annotations 读取带任一配置标签的grafana注释，相同时间范围的结果在数据源级别缓存
+func (s *QueryData) annotations(ctx context.Context, q *models.Query) ([]grafanaAnnotation, error) {
+	key := strconv.FormatInt(q.Start.UnixMilli(), 10) + "-" + strconv.FormatInt(q.End.UnixMilli(), 10)
+	if cached, ok := s.annotationCache.get(key); ok {
+		return cached.([]grafanaAnnotation), nil
+	}
+	annotations, err := s.queryAnnotations(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	s.annotationCache.set(key, annotations)
+	return annotations, nil
+}
+
+// queryAnnotations 使用数据源的http配置（代理、TLS、超时）请求grafana注释接口
+func (s *QueryData) queryAnnotations(ctx context.Context, q *models.Query) ([]grafanaAnnotation, error) {
+	if s.calendar.grafanaUrl == "" {
+		return nil, fmt.Errorf("grafanaUrl is required to read annotations")
+	}
+	u, err := url.Parse(strings.TrimSuffix(s.calendar.grafanaUrl, "/") + "/api/annotations")
+	if err != nil {
+		return nil, err
+	}
+	qs := url.Values{
+		"from":     []string{strconv.FormatInt(q.Start.UnixMilli(), 10)},
+		"to":       []string{strconv.FormatInt(q.End.UnixMilli(), 10)},
+		"tags":     s.calendar.annotationTags,
+		"matchAny": []string{"true"},
+		"type":     []string{"annotation"},
+		"limit":    []string{strconv.Itoa(annotationLimit)},
+	}
+	u.RawQuery = qs.Encode()
+	ctx, cancel := context.WithTimeout(ctx, annotationTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.calendar.grafanaToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.calendar.grafanaToken)
+	}
+	httpClient := s.annotationClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			log.DefaultLogger.Error("Close annotation response body error, error is: ", err)
+		}
+	}()
+	body, err := util.ReadJSONBody(res, "grafana")
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		return nil, util.DownstreamError(util.StatusFromHTTP(res.StatusCode),
+			fmt.Errorf("grafana annotations request failed (HTTP %d): %s", res.StatusCode, util.BodyPreview(body)))
+	}
+	var annotations []grafanaAnnotation
+	if err := json.Unmarshal(body, &annotations); err != nil {
+		return nil, fmt.Errorf("grafana returned invalid annotations: %w", err)
+	}
+	return annotations, nil
+}
+
+// annotationWindows 注释转换为维护窗口，单点注释覆盖一个step
+func annotationWindows(annotations []grafanaAnnotation, step time.Duration) []models.Window {
+	windows := make([]models.Window, 0, len(annotations))
+	for _, a := range annotations {
+		end := a.TimeEnd
+		if end <= a.Time {
+			end = a.Time + step.Milliseconds()
+		}
+		windows = append(windows, models.Window{
+			Name:   a.Text,
+			Source: util.WindowAnnotation,
+			Start:  a.Time,
+			End:    end,
+		})
+	}
+	return windows
+}
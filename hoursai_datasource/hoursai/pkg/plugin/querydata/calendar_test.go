@@ -0,0 +1,157 @@
+package querydata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+)
+
+func parseWindow(t *testing.T, raw string) maintenanceWindow {
+	t.Helper()
+	var jsonData map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"maintenanceWindows":[`+raw+`]}`), &jsonData); err != nil {
+		t.Fatal(err)
+	}
+	windows, err := getMaintenanceWindows(jsonData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return windows[0]
+}
+
+func TestMaintenanceWindowExpand(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("timezone data not available:", err)
+	}
+	local := func(s string) time.Time {
+		ts, err := time.ParseInLocation("2006-01-02 15:04", s, newYork)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	tests := []struct {
+		name   string
+		window string
+		from   string
+		to     string
+		starts []string
+		ends   []string
+	}{
+		{
+			name:   "daily window keeps local time across DST",
+			window: `{"start":"04:00","duration":"1h"}`,
+			from:   "2022-03-12 00:00", to: "2022-03-14 23:00",
+			starts: []string{"2022-03-12 04:00", "2022-03-13 04:00", "2022-03-14 04:00"},
+			ends:   []string{"2022-03-12 05:00", "2022-03-13 05:00", "2022-03-14 05:00"},
+		},
+		{
+			name:   "window started the day before crosses midnight",
+			window: `{"start":"23:00","duration":"2h"}`,
+			from:   "2022-06-01 00:30", to: "2022-06-01 02:00",
+			starts: []string{"2022-05-31 23:00"},
+			ends:   []string{"2022-06-01 01:00"},
+		},
+		{
+			name:   "window ending before the range is skipped",
+			window: `{"start":"23:00","duration":"1h"}`,
+			from:   "2022-06-01 00:30", to: "2022-06-01 02:00",
+		},
+		{
+			name:   "weekdays only",
+			window: `{"start":"02:00","duration":"30m","weekdays":["sat","Sun"]}`,
+			from:   "2022-06-01 00:00", to: "2022-06-07 23:59",
+			starts: []string{"2022-06-04 02:00", "2022-06-05 02:00"},
+			ends:   []string{"2022-06-04 02:30", "2022-06-05 02:30"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := parseWindow(t, tt.window)
+			windows := w.expand(local(tt.from), local(tt.to), newYork)
+			if len(windows) != len(tt.starts) {
+				t.Fatalf("got %d windows, want %d: %v", len(windows), len(tt.starts), windows)
+			}
+			for i, window := range windows {
+				if want := local(tt.starts[i]).UnixMilli(); window.Start != want {
+					t.Errorf("window %d starts at %s, want %s", i, time.UnixMilli(window.Start).In(newYork),
+						tt.starts[i])
+				}
+				if want := local(tt.ends[i]).UnixMilli(); window.End != want {
+					t.Errorf("window %d ends at %s, want %s", i, time.UnixMilli(window.End).In(newYork), tt.ends[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGetMaintenanceWindowsInvalid(t *testing.T) {
+	for _, raw := range []string{
+		`{"start":"25:00","duration":"1h"}`,
+		`{"start":"02:00","duration":"-1h"}`,
+		`{"start":"02:00","duration":"1h","weekdays":["someday"]}`,
+	} {
+		var jsonData map[string]interface{}
+		if err := json.Unmarshal([]byte(`{"maintenanceWindows":[`+raw+`]}`), &jsonData); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := getMaintenanceWindows(jsonData); err == nil {
+			t.Errorf("expected error for %s", raw)
+		}
+	}
+}
+
+func TestQueryCalendarAnnotations(t *testing.T) {
+	var requests int32
+	count := 2
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		annotations := make([]grafanaAnnotation, count)
+		for i := range annotations {
+			annotations[i] = grafanaAnnotation{Text: fmt.Sprintf("deploy %d", i), Time: int64(i) * 60000}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(annotations)
+	}))
+	defer server.Close()
+
+	s := &QueryData{calendar: calendarSettings{annotationTags: []string{"deploy"}, grafanaUrl: server.URL}}
+	s.SetAnnotationSource(server.Client(), NewAnnotationCache())
+	q := &models.Query{Start: time.UnixMilli(0), End: time.UnixMilli(3600000), Step: time.Minute}
+
+	c, notices, err := s.queryCalendar(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Windows) != 2 || len(notices) != 0 {
+		t.Fatalf("got %d windows and %d notices, want 2 windows and no notice", len(c.Windows), len(notices))
+	}
+	// 单点注释覆盖一个step
+	if c.Windows[1].End-c.Windows[1].Start != time.Minute.Milliseconds() {
+		t.Errorf("point annotation covers %dms, want one step", c.Windows[1].End-c.Windows[1].Start)
+	}
+	if _, _, err := s.queryCalendar(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("annotations requested %d times for the same time range, want 1", n)
+	}
+
+	// 注释数量达到上限时提示可能有遗漏
+	count = annotationLimit
+	q.End = time.UnixMilli(7200000)
+	if _, notices, err = s.queryCalendar(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	if len(notices) != 1 {
+		t.Errorf("got %d notices, want a notice for the annotation limit", len(notices))
+	}
+}
@@ -0,0 +1,138 @@
+package querydata
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+type fetchCall struct {
+	done     chan struct{}
+	response *backend.DataResponse
+	err      error
+}
+
+// FetchGroup 数据源级别共享，相同的Prometheus查询在进行中时只发送一次，其余调用方复用解析后的frame
+type FetchGroup struct {
+	mu    sync.Mutex
+	calls map[string]*fetchCall
+}
+
+func NewFetchGroup() *FetchGroup {
+	return &FetchGroup{calls: make(map[string]*fetchCall)}
+}
+
+// fetchKey 由表达式、时间范围、step、查询类型、legend格式和请求头生成，请求头不同的用户不会共享结果
+func fetchKey(q *models.Query, headers map[string]string) string {
+	h := sha256.New()
+	write := func(s string) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	write(q.Expr)
+	write(strconv.FormatInt(q.Start.UnixNano(), 10))
+	write(strconv.FormatInt(q.End.UnixNano(), 10))
+	write(q.Step.String())
+	write(strconv.FormatBool(q.InstantQuery))
+	write(strconv.FormatBool(q.RangeQuery))
+	write(strconv.FormatBool(q.ExemplarQuery))
+	write(q.LegendFormat)
+	write(headersKey(headers))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// headersKey 按名称排序后的请求头摘要，用于区分不同用户的共享结果
+func headersKey(headers map[string]string) string {
+	h := sha256.New()
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(headers[k]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// do 同一key只有第一个调用方执行fn，每个调用方拿到的都是frame的副本，后续处理互不影响
+func (g *FetchGroup) do(ctx context.Context, key string,
+	fn func() (*backend.DataResponse, error)) (*backend.DataResponse, error) {
+	if g == nil {
+		return fn()
+	}
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// 发起请求的调用方被取消时，不把它的取消错误传给其他调用方
+		if isContextError(call.err) && ctx.Err() == nil {
+			return fn()
+		}
+		log.DefaultLogger.Debug("Reuse in-flight prometheus query", "key", key)
+		return copyResponse(call.response), call.err
+	}
+	call := &fetchCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.response, call.err = fn()
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(call.done)
+	return copyResponse(call.response), call.err
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// copyResponse 深拷贝frame，后续的裁剪、附加notice和meta修改不会影响共享结果
+func copyResponse(r *backend.DataResponse) *backend.DataResponse {
+	if r == nil {
+		return nil
+	}
+	frames := make(data.Frames, 0, len(r.Frames))
+	for _, frame := range r.Frames {
+		frames = append(frames, copyFrame(frame))
+	}
+	return &backend.DataResponse{Frames: frames, Error: r.Error, Status: r.Status}
+}
+
+func copyFrame(frame *data.Frame) *data.Frame {
+	newFrame := frame.EmptyCopy()
+	if frame.Meta != nil {
+		meta := *frame.Meta
+		meta.Notices = append([]data.Notice(nil), frame.Meta.Notices...)
+		newFrame.Meta = &meta
+	}
+	for i, field := range frame.Fields {
+		newField := newFrame.Fields[i]
+		if field.Config != nil {
+			config := *field.Config
+			newField.Config = &config
+		}
+		newField.Extend(field.Len())
+		for j := 0; j < field.Len(); j++ {
+			newField.Set(j, field.CopyAt(j))
+		}
+	}
+	return newFrame
+}
@@ -0,0 +1,208 @@
+package querydata
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// sharedResponse 带notice的单序列结果，用于检查调用方拿到的是否为副本
+func sharedResponse() *backend.DataResponse {
+	frame := data.NewFrame("up",
+		data.NewField("Time", nil, []time.Time{time.Unix(0, 0), time.Unix(60, 0)}),
+		data.NewField("Value", data.Labels{"host": "a"}, []float64{1, 2}))
+	frame.Meta = &data.FrameMeta{Notices: []data.Notice{{Text: "shared"}}}
+	return &backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+// waitFollowers 等待跟随的调用方进入等待状态
+func waitFollowers() {
+	time.Sleep(50 * time.Millisecond)
+}
+
+func TestFetchGroupCoalesces(t *testing.T) {
+	g := NewFetchGroup()
+	var calls int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fn := func() (*backend.DataResponse, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return sharedResponse(), nil
+	}
+
+	const n = 5
+	responses := make([]*backend.DataResponse, n)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		responses[0], _ = g.do(context.Background(), "key", fn)
+	}()
+	<-started
+	for i := 1; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i], _ = g.do(context.Background(), "key", fn)
+		}(i)
+	}
+	waitFollowers()
+	close(release)
+	wg.Wait()
+
+	if c := atomic.LoadInt32(&calls); c != 1 {
+		t.Fatalf("got %d calls, want 1", c)
+	}
+	// 修改其中一个结果不影响其它调用方
+	responses[0].Frames[0].Fields[1].Set(0, 100.0)
+	responses[0].Frames[0].Fields[1].Labels["host"] = "b"
+	responses[0].Frames[0].Meta.Notices[0].Text = "changed"
+	for i, r := range responses[1:] {
+		frame := r.Frames[0]
+		if v := frame.Fields[1].At(0).(float64); v != 1 {
+			t.Errorf("response %d value = %v, want 1", i+1, v)
+		}
+		if host := frame.Fields[1].Labels["host"]; host != "a" {
+			t.Errorf("response %d host = %q, want a", i+1, host)
+		}
+		if text := frame.Meta.Notices[0].Text; text != "shared" {
+			t.Errorf("response %d notice = %q, want shared", i+1, text)
+		}
+	}
+	if len(g.calls) != 0 {
+		t.Errorf("finished calls are not removed: %d left", len(g.calls))
+	}
+}
+
+func TestFetchGroupDifferentHeaders(t *testing.T) {
+	q := seasonalQuery()
+	userA := map[string]string{"Authorization": "Bearer a", "X-Org": "1"}
+	userB := map[string]string{"Authorization": "Bearer b", "X-Org": "1"}
+	if fetchKey(q, userA) == fetchKey(q, userB) {
+		t.Fatal("queries with different headers share the same key")
+	}
+	if fetchKey(q, userA) != fetchKey(q, map[string]string{"X-Org": "1", "Authorization": "Bearer a"}) {
+		t.Fatal("same headers produce different keys")
+	}
+
+	g := NewFetchGroup()
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (*backend.DataResponse, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return sharedResponse(), nil
+	}
+	var wg sync.WaitGroup
+	for _, headers := range []map[string]string{userA, userB} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			_, _ = g.do(context.Background(), key, fn)
+		}(fetchKey(q, headers))
+	}
+	waitFollowers()
+	close(release)
+	wg.Wait()
+	if c := atomic.LoadInt32(&calls); c != 2 {
+		t.Errorf("got %d calls, want 2", c)
+	}
+}
+
+func TestFetchGroupCanceledLeader(t *testing.T) {
+	g := NewFetchGroup()
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	var calls int32
+
+	var leaderErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, leaderErr = g.do(leaderCtx, "key", func() (*backend.DataResponse, error) {
+			atomic.AddInt32(&calls, 1)
+			close(started)
+			<-leaderCtx.Done()
+			return nil, leaderCtx.Err()
+		})
+	}()
+	<-started
+
+	var followerResponse *backend.DataResponse
+	var followerErr error
+	followerDone := make(chan struct{})
+	go func() {
+		defer close(followerDone)
+		followerResponse, followerErr = g.do(context.Background(), "key", func() (*backend.DataResponse, error) {
+			atomic.AddInt32(&calls, 1)
+			return sharedResponse(), nil
+		})
+	}()
+	waitFollowers()
+	cancel()
+	<-done
+	<-followerDone
+
+	if leaderErr != context.Canceled {
+		t.Errorf("leader error = %v, want context canceled", leaderErr)
+	}
+	// 跟随者没有被取消，应重新执行查询而不是拿到发起方的取消错误
+	if followerErr != nil || followerResponse == nil || len(followerResponse.Frames) != 1 {
+		t.Fatalf("follower got %v, %v, want a response", followerResponse, followerErr)
+	}
+	if c := atomic.LoadInt32(&calls); c != 2 {
+		t.Errorf("got %d calls, want 2", c)
+	}
+}
+
+func TestFetchReusesCopies(t *testing.T) {
+	var requests int32
+	s := newSeasonalQueryData(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/metadata") {
+			_, _ = w.Write([]byte(`{"status":"success","data":{}}`))
+			return
+		}
+		atomic.AddInt32(&requests, 1)
+		_, _ = w.Write([]byte(matrixResponse))
+	})
+	q := seasonalQuery()
+	q.SeasonalOffsets = nil
+
+	first, err := s.fetch(context.Background(), s.client, q, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 修改第一次的结果，同一请求内后续复用的结果不受影响
+	first.Frames[0].Name = "changed"
+	first.Frames[0].Fields[1].Labels["host"] = "b"
+	first.Frames = first.Frames[:0]
+
+	for i := 0; i < 2; i++ {
+		r, err := s.fetch(context.Background(), s.client, q, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Frames) != 1 {
+			t.Fatalf("got %d frames, want 1", len(r.Frames))
+		}
+		frame := r.Frames[0]
+		if frame.Name == "changed" || frame.Fields[1].Labels["host"] != "a" {
+			t.Errorf("reused frame was modified: %s %v", frame.Name, frame.Fields[1].Labels)
+		}
+		frame.Fields[1].Labels["host"] = "c"
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("got %d prometheus requests, want 1", n)
+	}
+}
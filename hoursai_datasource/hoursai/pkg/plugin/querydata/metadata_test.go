@@ -0,0 +1,42 @@
+package querydata
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+)
+
+func TestCallPrometheusForwardsHeaders(t *testing.T) {
+	var (
+		mu            sync.Mutex
+		authorization string
+	)
+	s := newSeasonalQueryData(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		authorization = r.Header.Get("Authorization")
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"success","data":[]}`))
+	})
+	body := []byte(`{"from":0,"to":60000,"match[]":"up","label":"job","metric":"up"}`)
+	headers := util.ResourceHeaders(map[string][]string{"authorization": {"Bearer a"}, "Accept": {"*/*"}})
+	for _, operationType := range []string{util.MetricsType, util.LabelNamesType, util.LabelValuesType,
+		util.SeriesType, util.MetadataType} {
+		t.Run(operationType, func(t *testing.T) {
+			mu.Lock()
+			authorization = ""
+			mu.Unlock()
+			if _, err := s.CallPrometheus(context.Background(), body, operationType, headers); err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if authorization != "Bearer a" {
+				t.Errorf("authorization header = %q, want Bearer a", authorization)
+			}
+		})
+	}
+}
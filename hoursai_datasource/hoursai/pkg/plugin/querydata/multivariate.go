@@ -0,0 +1,39 @@
+package querydata
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/algorithm"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// executeMultivariate 分别查询每个表达式的范围数据，联合后进行多变量异常检测
+func (s *QueryData) executeMultivariate(ctx context.Context, q *models.Query,
+	headers map[string]string) (*backend.DataResponse, error) {
+	if len(q.Exprs) < 2 {
+		return nil, util.PluginError(backend.StatusBadRequest,
+			fmt.Errorf("multivariate query requires at least 2 expressions, got %d", len(q.Exprs)))
+	}
+	responses := make([]*backend.DataResponse, 0, len(q.Exprs))
+	for _, expr := range q.Exprs {
+		dq := *q
+		dq.Expr = expr
+		dq.InstantQuery = false
+		dq.RangeQuery = true
+		dq.ExemplarQuery = false
+		r, err := s.fetch(ctx, s.client, &dq, headers)
+		if err != nil {
+			return nil, err
+		}
+		if r.Error != nil {
+			log.DefaultLogger.Error("Multivariate expression query error", "expr", expr, "err", r.Error)
+			return nil, util.DownstreamError(0, fmt.Errorf("expression %q: %w", expr, r.Error))
+		}
+		responses = append(responses, r)
+	}
+	return algorithm.CallMultivariate(ctx, responses, q, s.managerPolicy)
+}
@@ -3,6 +3,7 @@ package querydata
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/algorithm"
 	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/client"
 	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/intervalv2"
@@ -13,10 +14,14 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"net/http"
 	"regexp"
+	"sync"
 )
 
 const legendFormatAuto = "__auto"
 
+// defaultMetadataLimit 数据源未配置时标签、序列和元数据接口返回的最大结果数
+const defaultMetadataLimit = 10000
+
 var legendFormatRegexp = regexp.MustCompile(`\{\{\s*(.+?)\s*\}\}`)
 
 type QueryData struct {
@@ -27,6 +32,18 @@ type QueryData struct {
 	TimeInterval       string
 	enableWideSeries   bool
 	JsonData           json.RawMessage
+	Locale             string
+	cardinalityLimit   algorithm.CardinalityLimit
+	managerPolicy      *client.ManagerPolicy
+	fetchGroup         *FetchGroup
+	metadataCache      *Cache
+	annotationCache    *Cache
+	annotationClient   *http.Client
+	metadataLimit      int64
+	calendar           calendarSettings
+	// fetched 本次请求内已完成的查询，同一请求中不同refId的相同表达式只查询一次，本地同比并发查询时由fetchedMu保护
+	fetched   map[string]*backend.DataResponse
+	fetchedMu sync.Mutex
 }
 
 func New(httpClient *http.Client, settings backend.DataSourceInstanceSettings) (*QueryData, error) {
@@ -41,6 +58,30 @@ func New(httpClient *http.Client, settings backend.DataSourceInstanceSettings) (
 		return nil, err
 	}
 
+	// 错误信息的语言，zh时优先使用manager返回的中文信息
+	locale, err := util.GetStringOptional(jsonData, "locale")
+	if err != nil {
+		return nil, err
+	}
+
+	cardinalityLimit, err := getCardinalityLimit(jsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataLimit, err := util.GetInt64Optional(jsonData, "metadataLimit")
+	if err != nil {
+		return nil, err
+	}
+	if metadataLimit <= 0 {
+		metadataLimit = defaultMetadataLimit
+	}
+
+	calendar, err := getCalendarSettings(jsonData, settings.DecryptedSecureJSONData)
+	if err != nil {
+		return nil, err
+	}
+
 	promClient := client.NewClient(httpClient, httpMethod, settings.URL)
 	log.DefaultLogger.Info("Query data info is", "url:", settings.URL,
 		"TimeInterval:", timeInterval, "ID: ", settings.ID)
@@ -52,24 +93,120 @@ func New(httpClient *http.Client, settings backend.DataSourceInstanceSettings) (
 		URL:                settings.URL,
 		enableWideSeries:   false,
 		JsonData:           settings.JSONData,
+		Locale:             locale,
+		cardinalityLimit:   cardinalityLimit,
+		fetched:            make(map[string]*backend.DataResponse),
+		metadataLimit:      metadataLimit,
+		calendar:           calendar,
 	}, nil
 }
 
+// getCardinalityLimit 读取数据源级别的序列数、点数限制及超限策略，未配置时不限制
+func getCardinalityLimit(jsonData map[string]interface{}) (algorithm.CardinalityLimit, error) {
+	var (
+		limit algorithm.CardinalityLimit
+		err   error
+	)
+	if limit.MaxSeries, err = util.GetInt64Optional(jsonData, "maxSeries"); err != nil {
+		return limit, err
+	}
+	if limit.MaxPoints, err = util.GetInt64Optional(jsonData, "maxPoints"); err != nil {
+		return limit, err
+	}
+	limit.Strategy, err = util.GetStringOptional(jsonData, "cardinalityStrategy")
+	return limit, err
+}
+
+// SetManagerPolicy 设置数据源级别共享的manager熔断、限流和请求合并策略
+func (s *QueryData) SetManagerPolicy(policy *client.ManagerPolicy) {
+	s.managerPolicy = policy
+}
+
+// SetFetchGroup 设置数据源级别共享的Prometheus查询合并组
+func (s *QueryData) SetFetchGroup(group *FetchGroup) {
+	s.fetchGroup = group
+}
+
+// SetMetadataCache 设置数据源级别共享的指标类型缓存
+func (s *QueryData) SetMetadataCache(cache *Cache) {
+	s.metadataCache = cache
+}
+
+// SetAnnotationSource 设置读取grafana注释的http客户端和数据源级别共享的注释缓存
+func (s *QueryData) SetAnnotationSource(httpClient *http.Client, cache *Cache) {
+	s.annotationClient = httpClient
+	s.annotationCache = cache
+}
+
 func (s *QueryData) Execute(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 	result := backend.QueryDataResponse{Responses: backend.Responses{}}
 	log.DefaultLogger.Info("The request contains ", "queries length:", len(req.Queries))
-	for _, query := range req.Queries {
-		log.DefaultLogger.Info("The current query is", query)
+	// 单个查询出错只影响该查询的结果，错误按来源和类型映射为对应的状态码
+	for _, dataQuery := range req.Queries {
+		log.DefaultLogger.Info("The current query is", dataQuery)
 		// 把query的json解析成QueryData结构体
-		query, err := models.Parse(query, s.TimeInterval, s.intervalCalculator, s.JsonData)
+		query, err := models.Parse(dataQuery, s.TimeInterval, s.intervalCalculator, s.JsonData)
 		if err != nil {
-			return &result, err
+			log.DefaultLogger.Error("Parse query error, err is: ", err)
+			result.Responses[dataQuery.RefID] = util.ErrorResponse(util.PluginError(backend.StatusBadRequest, err),
+				s.Locale)
+			continue
 		}
-		r := &backend.DataResponse{}
-		r, err = s.fetch(ctx, s.client, query, req.Headers)
+		// 查询prometheus前校验表达式，语法错误时不发送请求
+		hints, err := validateQuery(query)
+		if err != nil {
+			log.DefaultLogger.Error("Validate query error, err is: ", err)
+			result.Responses[query.RefId] = util.ErrorResponse(err, s.Locale)
+			continue
+		}
+		// 节假日和维护窗口随请求发送给算法，注释读取失败不影响查询
+		var calendarNotices []data.Notice
+		if query.Calendar, calendarNotices, err = s.queryCalendar(ctx, query); err != nil {
+			log.DefaultLogger.Error("Read calendar annotations error, err is: ", err)
+			hints = append(hints, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("Annotations could not be read as maintenance windows: %v", err),
+			})
+		}
+		hints = append(hints, calendarNotices...)
+		if query.QueryType == util.MultivariateType {
+			r, err := s.executeMultivariate(ctx, query, req.Headers)
+			if err != nil {
+				log.DefaultLogger.Error("Multivariate query error, err is: ", err)
+				result.Responses[query.RefId] = util.ErrorResponse(err, s.Locale)
+				continue
+			}
+			r = algorithm.SuppressMaintenance(r, query.Calendar)
+			appendHints(r, hints)
+			result.Responses[query.RefId] = *r
+			continue
+		}
+		// 本地同比不调用manager，同样做维护窗口抑制和TopK
+		if query.QueryType == util.SeasonalType {
+			r, err := s.executeSeasonal(ctx, query, req.Headers)
+			if err != nil {
+				log.DefaultLogger.Error("Seasonal query error, err is: ", err)
+				result.Responses[query.RefId] = util.ErrorResponse(err, s.Locale)
+				continue
+			}
+			r = algorithm.SuppressMaintenance(r, query.Calendar)
+			if query.TopK > 0 {
+				r = algorithm.SelectTopK(r, query.TopK, query.TopKBy)
+			}
+			appendHints(r, hints)
+			result.Responses[query.RefId] = *r
+			continue
+		}
+		r, err := s.fetch(ctx, s.client, query, req.Headers)
 		if err != nil {
 			log.DefaultLogger.Error("Fetch data from prometheus error, error is: ", err)
-			return &result, err
+			result.Responses[query.RefId] = util.ErrorResponse(util.DownstreamError(0, err), s.Locale)
+			continue
+		}
+		if r.Error != nil {
+			log.DefaultLogger.Error("Prometheus returned error, error is: ", r.Error)
+			result.Responses[query.RefId] = util.ErrorResponse(util.DownstreamError(0, r.Error), s.Locale)
+			continue
 		}
 		if len(r.Frames) == 0 {
 			log.DefaultLogger.Error("Received nil response from runQuery", "query", query.Expr)
@@ -77,24 +214,75 @@ func (s *QueryData) Execute(ctx context.Context, req *backend.QueryDataRequest)
 			result.Responses[query.RefId] = *r
 			continue
 		}
+		// 序列数或点数超限时按策略拒绝或裁剪，避免一次性发送过多数据给算法
+		r, err = s.guardCardinality(r, query)
+		if err != nil {
+			log.DefaultLogger.Error("Cardinality limit exceeded, err is: ", err)
+			result.Responses[query.RefId] = util.ErrorResponse(util.PluginError(backend.StatusBadRequest, err),
+				s.Locale)
+			continue
+		}
+		// 识别计数器并应用查询指定的变换
+		r, err = s.transform(ctx, r, query, req.Headers)
+		if err != nil {
+			log.DefaultLogger.Error("Transform series error, err is: ", err)
+			result.Responses[query.RefId] = util.ErrorResponse(err, s.Locale)
+			continue
+		}
 		// 调用算法接口
-		r, err = algorithm.CallAlgorithm(ctx, r, query)
+		r, err = algorithm.CallAlgorithm(ctx, r, query, s.managerPolicy)
 		if err != nil {
-			r = &backend.DataResponse{
-				Status: backend.StatusInternal,
-				Error:  err,
-			}
-			result.Responses[query.RefId] = *r
 			log.DefaultLogger.Error("Call algorithm error, err is: ", err)
-			return &result, err
+			result.Responses[query.RefId] = util.ErrorResponse(err, s.Locale)
+			continue
+		}
+		// 维护窗口内的异常点不参与排序和告警
+		r = algorithm.SuppressMaintenance(r, query.Calendar)
+		if query.TopK > 0 {
+			r = algorithm.SelectTopK(r, query.TopK, query.TopKBy)
 		}
+		appendHints(r, hints)
 		result.Responses[query.RefId] = *r
 	}
 	log.DefaultLogger.Info("Final result is: ", result)
 	return &result, nil
 }
 
+// guardCardinality 查询配置的上限与数据源配置合并后限制序列数和点数
+func (s *QueryData) guardCardinality(r *backend.DataResponse, q *models.Query) (*backend.DataResponse, error) {
+	return algorithm.GuardCardinality(r, s.cardinalityLimit.Merge(algorithm.CardinalityLimit{
+		MaxSeries: q.MaxSeries,
+		MaxPoints: q.MaxPoints,
+		Strategy:  q.CardinalityMode,
+	}))
+}
+
+// fetch 同一请求内的相同查询复用已有结果，不同请求间进行中的相同查询合并为一次Prometheus请求
 func (s *QueryData) fetch(ctx context.Context, client *client.Client, q *models.Query,
+	headers map[string]string) (*backend.DataResponse, error) {
+	key := fetchKey(q, headers)
+	s.fetchedMu.Lock()
+	r, ok := s.fetched[key]
+	s.fetchedMu.Unlock()
+	if ok {
+		log.DefaultLogger.Debug("Reuse prometheus query result of the same request", "query", q.Expr)
+		return copyResponse(r), nil
+	}
+	r, err := s.fetchGroup.do(ctx, key, func() (*backend.DataResponse, error) {
+		return s.fetchOnce(ctx, client, q, headers)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.fetchedMu.Lock()
+	if s.fetched != nil {
+		s.fetched[key] = copyResponse(r)
+	}
+	s.fetchedMu.Unlock()
+	return r, nil
+}
+
+func (s *QueryData) fetchOnce(ctx context.Context, client *client.Client, q *models.Query,
 	headers map[string]string) (*backend.DataResponse, error) {
 	log.DefaultLogger.Info("Sending query",
 		"start", q.Start, "end", q.End, "step", q.Step, "query", q.Expr)
@@ -167,10 +355,13 @@ func (s *QueryData) exemplarQuery(ctx context.Context, c *client.Client, q *mode
 }
 
 func (s *QueryData) CallAlgorithmBackend(ctx context.Context, body []byte, jsonMap map[string]string,
-	operationType string) ([]byte, error) {
-	return algorithm.CallCore(ctx, body, jsonMap, operationType, s.client)
+	operationType string, headers map[string]string) ([]byte, error) {
+	return algorithm.CallCore(ctx, body, jsonMap, operationType, s.client, util.SdkHeaderToHttpHeader(headers),
+		s.managerPolicy)
 }
 
-func (s *QueryData) CallPrometheus(ctx context.Context, body []byte, operationType string) ([]byte, error) {
-	return algorithm.CallPrometheusMetadata(ctx, body, operationType, s.client, false)
+func (s *QueryData) CallPrometheus(ctx context.Context, body []byte, operationType string,
+	headers map[string]string) ([]byte, error) {
+	return algorithm.CallPrometheusMetadata(ctx, body, operationType, s.client, util.SdkHeaderToHttpHeader(headers),
+		s.metadataLimit)
 }
@@ -1,8 +1,10 @@
 package querydata
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
 	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util/converter"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
@@ -14,6 +16,42 @@ import (
 	"time"
 )
 
+// prometheusAPIResponse prometheus标签、元数据等接口的通用响应格式
+type prometheusAPIResponse struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	ErrorType string          `json:"errorType"`
+	Error     string          `json:"error"`
+}
+
+// readAPIResponse 读取prometheus接口响应中的data，status为error或HTTP错误码时返回下游错误
+func readAPIResponse(res *http.Response) (json.RawMessage, error) {
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			log.DefaultLogger.Error("Failed to close response body", "err", err)
+		}
+	}()
+
+	body, err := util.ReadJSONBody(res, "prometheus")
+	if err != nil {
+		return nil, err
+	}
+	var api prometheusAPIResponse
+	if err := json.Unmarshal(body, &api); err != nil {
+		return nil, util.DownstreamError(backend.StatusBadGateway,
+			fmt.Errorf("prometheus returned an invalid response: %v; body starts with: %q", err, util.BodyPreview(body)))
+	}
+	if api.Status == "error" {
+		return nil, util.DownstreamError(converter.PrometheusErrorStatus(api.ErrorType),
+			fmt.Errorf("%s: %s", api.ErrorType, api.Error))
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		return nil, util.DownstreamError(util.StatusFromHTTP(res.StatusCode),
+			fmt.Errorf("prometheus returned HTTP %d", res.StatusCode))
+	}
+	return api.Data, nil
+}
+
 // parseResponse 解析prometheus返回的response
 func (s *QueryData) parseResponse(q *models.Query, res *http.Response) (*backend.DataResponse, error) {
 	defer func() {
@@ -22,13 +60,29 @@ func (s *QueryData) parseResponse(q *models.Query, res *http.Response) (*backend
 		}
 	}()
 
-	iter := jsoniter.Parse(jsoniter.ConfigDefault, res.Body, 1024)
+	body, err := util.ReadJSONBody(res, "prometheus")
+	if err != nil {
+		return nil, err
+	}
+	iter := jsoniter.ParseBytes(jsoniter.ConfigDefault, body)
 	r := converter.ReadPrometheusStyleResult(iter, converter.Options{
 		MatrixWideSeries: s.enableWideSeries,
 		VectorWideSeries: s.enableWideSeries,
 	})
+	if err := util.CheckIterator(iter, body, "prometheus"); err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= http.StatusBadRequest && r != nil && r.Error == nil {
+		r.Error = util.DownstreamError(util.StatusFromHTTP(res.StatusCode),
+			fmt.Errorf("prometheus returned HTTP %d", res.StatusCode))
+	}
 	if r == nil {
-		return nil, fmt.Errorf("received empty response from prometheus")
+		if res.StatusCode >= http.StatusBadRequest {
+			return nil, util.DownstreamError(util.StatusFromHTTP(res.StatusCode),
+				fmt.Errorf("prometheus returned HTTP %d", res.StatusCode))
+		}
+		return nil, util.DownstreamError(backend.StatusBadGateway,
+			fmt.Errorf("received empty response from prometheus; body starts with: %q", util.BodyPreview(body)))
 	}
 
 	for _, frame := range r.Frames {
@@ -0,0 +1,81 @@
+package querydata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/algorithm"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/intervalv2"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// defaultRootCauseInterval 根因排序未指定step且数据源未配置采集间隔时的最小step
+const defaultRootCauseInterval = 15 * time.Second
+
+// RootCause 按标签维度拉取候选序列，计算其与整体序列的相关性和对异常窗口的贡献并排序；
+// 同时对related中的其他指标按与整体序列的相关性和异常窗口内的变化排序。headers为转发给prometheus的认证信息
+func (s *QueryData) RootCause(ctx context.Context, body []byte, headers map[string]string) ([]byte, error) {
+	var req algorithm.RootCauseRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		log.DefaultLogger.Error("Root cause body to struct error, error is: ", err)
+		return nil, util.PluginError(backend.StatusBadRequest, err)
+	}
+	if err := req.Validate(); err != nil {
+		return nil, util.PluginError(backend.StatusBadRequest, err)
+	}
+
+	step := time.Duration(req.Step) * time.Second
+	if step <= 0 {
+		minInterval, err := intervalv2.GetIntervalFrom(s.TimeInterval, "", 0, defaultRootCauseInterval)
+		if err != nil {
+			return nil, err
+		}
+		tr := backend.TimeRange{From: time.Unix(req.Start, 0), To: time.Unix(req.End, 0)}
+		step = s.intervalCalculator.Calculate(tr, minInterval, 0).Value
+	}
+	query := func(expr string) (*backend.DataResponse, error) {
+		r, err := s.rangeQuery(ctx, s.client, &models.Query{
+			Expr:       expr,
+			Step:       step,
+			Start:      time.Unix(req.Start, 0),
+			End:        time.Unix(req.End, 0),
+			RangeQuery: true,
+		}, headers)
+		if err != nil {
+			return nil, err
+		}
+		if r.Error != nil {
+			return nil, fmt.Errorf("query %q: %w", expr, r.Error)
+		}
+		return r, nil
+	}
+
+	total, err := query(req.TotalExpr())
+	if err != nil {
+		return nil, err
+	}
+	candidates := make(map[string]*backend.DataResponse, len(req.Dimensions))
+	for _, dimension := range req.Dimensions {
+		if candidates[dimension], err = query(req.DimensionExpr(dimension)); err != nil {
+			return nil, err
+		}
+	}
+
+	related := make(map[string]*backend.DataResponse, len(req.Related))
+	for _, expr := range req.Related {
+		if related[expr], err = query(expr); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"status":  "success",
+		"data":    algorithm.RankRootCause(total, candidates, &req),
+		"related": algorithm.RankRelated(total, related, &req),
+	})
+}
@@ -0,0 +1,85 @@
+package querydata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/algorithm"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// executeSeasonal 查询当前区间和各偏移的历史区间，在插件内计算同比基线，不调用manager
+func (s *QueryData) executeSeasonal(ctx context.Context, q *models.Query,
+	headers map[string]string) (*backend.DataResponse, error) {
+	offsets, err := algorithm.GetSeasonalOffsets(q)
+	if err != nil {
+		return nil, err
+	}
+	cq := *q
+	cq.InstantQuery = false
+	cq.RangeQuery = true
+	cq.ExemplarQuery = false
+
+	// 各偏移的历史区间与当前区间并发查询，任一查询失败时取消其它查询
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg      sync.WaitGroup
+		history = make([]*backend.DataResponse, len(offsets))
+		errs    = make([]error, len(offsets))
+	)
+	for i, o := range offsets {
+		wg.Add(1)
+		go func(i int, hq *models.Query) {
+			defer wg.Done()
+			if history[i], errs[i] = s.fetchSeasonal(ctx, hq, headers); errs[i] != nil {
+				cancel()
+			}
+		}(i, algorithm.ShiftQuery(q, o))
+	}
+	current, err := s.fetchSeasonal(ctx, &cq, headers)
+	if err == nil {
+		if current, err = s.guardCardinality(current, q); err != nil {
+			err = util.PluginError(backend.StatusBadRequest, err)
+		}
+	}
+	if err != nil {
+		cancel()
+	}
+	wg.Wait()
+
+	// 被取消的查询不是失败原因，优先返回引起取消的错误
+	for i, o := range offsets {
+		if errs[i] != nil && !errors.Is(errs[i], context.Canceled) {
+			log.DefaultLogger.Error("Seasonal history query error", "offset", o.Name, "err", errs[i])
+			return nil, fmt.Errorf("offset %s: %w", o.Name, errs[i])
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, o := range offsets {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("offset %s: %w", o.Name, errs[i])
+		}
+	}
+	return algorithm.SeasonalBaseline(current, history, offsets, q)
+}
+
+// fetchSeasonal 范围查询并按查询配置变换，当前序列和历史序列使用相同的变换
+func (s *QueryData) fetchSeasonal(ctx context.Context, q *models.Query,
+	headers map[string]string) (*backend.DataResponse, error) {
+	r, err := s.fetch(ctx, s.client, q, headers)
+	if err != nil {
+		return nil, util.DownstreamError(0, err)
+	}
+	if r.Error != nil {
+		return nil, util.DownstreamError(0, r.Error)
+	}
+	return s.transform(ctx, r, q, headers)
+}
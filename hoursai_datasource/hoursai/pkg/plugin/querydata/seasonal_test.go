@@ -0,0 +1,100 @@
+package querydata
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const matrixResponse = `{"status":"success","data":{"resultType":"matrix","result":[` +
+	`{"metric":{"__name__":"up","host":"a"},"values":[[0,"1"],[60,"1"]]}]}}`
+
+func seasonalQuery() *models.Query {
+	start := time.Unix(7*86400, 0)
+	return &models.Query{
+		Expr:            "up",
+		Start:           start,
+		End:             start.Add(10 * time.Minute),
+		Step:            time.Minute,
+		RangeQuery:      true,
+		SeasonalOffsets: []string{"1d", "2d"},
+		JsonData:        json.RawMessage(`{}`),
+	}
+}
+
+func newSeasonalQueryData(t *testing.T, handler http.HandlerFunc) *QueryData {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	s, err := New(server.Client(), backend.DataSourceInstanceSettings{URL: server.URL, JSONData: []byte(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestExecuteSeasonalConcurrent(t *testing.T) {
+	// 当前区间和两个偏移的查询都到达后才返回，串行查询时会等到超时
+	var arrived, timedOut int32
+	all := make(chan struct{})
+	s := newSeasonalQueryData(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/metadata") {
+			_, _ = w.Write([]byte(`{"status":"success","data":{}}`))
+			return
+		}
+		if atomic.AddInt32(&arrived, 1) == 3 {
+			close(all)
+		}
+		select {
+		case <-all:
+		case <-time.After(2 * time.Second):
+			atomic.StoreInt32(&timedOut, 1)
+		}
+		_, _ = w.Write([]byte(matrixResponse))
+	})
+	if _, err := s.executeSeasonal(context.Background(), seasonalQuery(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&timedOut) == 1 {
+		t.Error("history offsets were not fetched concurrently")
+	}
+	if n := atomic.LoadInt32(&arrived); n != 3 {
+		t.Errorf("got %d prometheus requests, want 3", n)
+	}
+}
+
+func TestExecuteSeasonalHistoryError(t *testing.T) {
+	// 2d偏移的查询失败，其它查询一直等待直到被取消
+	historyStart := strconv.Itoa(5 * 86400)
+	s := newSeasonalQueryData(t, func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if strings.HasPrefix(r.Form.Get("start"), historyStart) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"invalid query"}`))
+			return
+		}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	start := time.Now()
+	_, err := s.executeSeasonal(context.Background(), seasonalQuery(), nil)
+	if err == nil || !strings.Contains(err.Error(), "offset 2d") {
+		t.Fatalf("expected the error of offset 2d, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Errorf("other queries were not canceled, took %s", elapsed)
+	}
+}
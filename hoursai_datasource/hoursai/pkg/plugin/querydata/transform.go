@@ -0,0 +1,89 @@
+package querydata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/algorithm"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// metadataTTL 指标类型很少变化，数据源级别缓存的有效期
+const metadataTTL = 5 * time.Minute
+
+type metadataResponse struct {
+	Status string                         `json:"status"`
+	Data   map[string][]metricMetadataRow `json:"data"`
+}
+
+type metricMetadataRow struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+// transform 查询原始指标的类型后识别计数器并应用变换
+func (s *QueryData) transform(ctx context.Context, r *backend.DataResponse, q *models.Query,
+	headers map[string]string) (*backend.DataResponse, error) {
+	var metricTypes map[string]string
+	if algorithm.NeedMetricTypes(r, q) {
+		metricTypes = s.metricTypes(ctx, headers)
+	}
+	return algorithm.ApplyTransforms(r, q, metricTypes)
+}
+
+// NewMetadataCache 数据源级别共享的指标类型缓存，按请求头区分，不同用户看到的metadata可能不同
+func NewMetadataCache() *Cache {
+	return NewCache(metadataTTL)
+}
+
+// metricTypes 一次查询/api/v1/metadata得到所有指标的类型并缓存，查询失败时返回nil，由调用方按名称后缀判断
+func (s *QueryData) metricTypes(ctx context.Context, headers map[string]string) map[string]string {
+	key := headersKey(headers)
+	if cached, ok := s.metadataCache.get(key); ok {
+		return cached.(map[string]string)
+	}
+	types, err := s.queryMetricTypes(ctx, headers)
+	if err != nil {
+		log.DefaultLogger.Error("Query metric metadata error", "err", err)
+		return nil
+	}
+	s.metadataCache.set(key, types)
+	return types
+}
+
+func (s *QueryData) queryMetricTypes(ctx context.Context, headers map[string]string) (map[string]string, error) {
+	res, err := s.client.QueryMetadata(ctx, "", 0, util.SdkHeaderToHttpHeader(headers))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			log.DefaultLogger.Error("Failed to close response body", "err", err)
+		}
+	}()
+	body, err := util.ReadJSONBody(res, "prometheus")
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("prometheus returned HTTP %d", res.StatusCode)
+	}
+	var metadata metadataResponse
+	if err := json.Unmarshal(body, &metadata); err != nil {
+		return nil, err
+	}
+	types := make(map[string]string, len(metadata.Data))
+	for name, rows := range metadata.Data {
+		if len(rows) > 0 && rows[0].Type != "" {
+			types[name] = rows[0].Type
+		}
+	}
+	return types, nil
+}
@@ -0,0 +1,72 @@
+package querydata
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func counterResponse() *backend.DataResponse {
+	frame := data.NewFrame("",
+		data.NewField("Time", nil, []time.Time{time.Unix(0, 0), time.Unix(60, 0), time.Unix(120, 0)}),
+		data.NewField("Value", data.Labels{"__name__": "requests_total", "host": "a"}, []float64{0, 60, 180}))
+	return &backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+func TestTransformMetadataTypes(t *testing.T) {
+	tests := []struct {
+		name      string
+		metadata  func(w http.ResponseWriter)
+		converted bool
+	}{
+		{name: "metadata error falls back to the name suffix", metadata: func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"status":"error","errorType":"internal","error":"unavailable"}`))
+		}, converted: true},
+		{name: "metadata type overrides the name suffix", metadata: func(w http.ResponseWriter) {
+			_, _ = w.Write([]byte(`{"status":"success","data":{"requests_total":[{"type":"gauge"}]}}`))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			s := newSeasonalQueryData(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if !strings.HasSuffix(r.URL.Path, "/metadata") {
+					t.Errorf("unexpected request %s", r.URL.Path)
+					return
+				}
+				calls++
+				tt.metadata(w)
+			})
+			r := counterResponse()
+			q := &models.Query{CounterHandling: util.CounterAuto, JsonData: json.RawMessage(`{}`)}
+			response, err := s.transform(context.Background(), r, q, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if calls != 1 {
+				t.Errorf("got %d metadata requests, want 1", calls)
+			}
+			if converted := response.Frames[0] != r.Frames[0]; converted != tt.converted {
+				t.Fatalf("converted = %v, want %v", converted, tt.converted)
+			}
+			if !tt.converted {
+				return
+			}
+			for i, want := range []float64{1, 2} {
+				if v := response.Frames[0].Fields[1].At(i).(float64); v != want {
+					t.Errorf("rate[%d] = %v, want %v", i, v, want)
+				}
+			}
+		})
+	}
+}
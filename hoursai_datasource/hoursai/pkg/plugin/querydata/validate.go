@@ -0,0 +1,193 @@
+package querydata
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util/converter"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// rateFunctions 以计数器的区间向量为参数的函数
+var rateFunctions = map[string]bool{
+	"rate":     true,
+	"irate":    true,
+	"increase": true,
+	"resets":   true,
+}
+
+// counterSuffixes 按命名规范判断计数器，直方图和摘要的_count、_sum、_bucket同样是计数器
+var counterSuffixes = []string{"_total", "_count", "_sum", "_bucket"}
+
+// validateQuery 在查询prometheus前解析表达式，语法错误和算法无法处理的结果类型直接返回，
+// 同时根据表达式结构生成查询建议
+func validateQuery(q *models.Query) ([]data.Notice, error) {
+	exprs, rangeQuery := []string{q.Expr}, q.RangeQuery
+	if q.QueryType == util.MultivariateType {
+		exprs, rangeQuery = q.Exprs, true
+	}
+	var notices []data.Notice
+	for _, expr := range exprs {
+		if strings.TrimSpace(expr) == "" {
+			continue
+		}
+		parsed, err := validateExpr(expr, rangeQuery)
+		if err != nil {
+			return nil, err
+		}
+		notices = append(notices, queryHints(parsed, q.Step)...)
+	}
+	return notices, nil
+}
+
+// validateExpr 用PromQL解析器检查表达式，语法错误带有行列位置；字符串结果不能用于算法，区间向量不能用于范围查询
+func validateExpr(expr string, rangeQuery bool) (parser.Expr, error) {
+	parsed, err := parser.ParseExpr(expr)
+	if err != nil {
+		return nil, parseError(expr, err)
+	}
+	switch parsed.Type() {
+	case parser.ValueTypeString:
+		return nil, util.PluginError(backend.StatusBadRequest,
+			fmt.Errorf("expression %q returns a string, algorithms require an instant vector or scalar", expr)).
+			WithMessageCn(fmt.Sprintf("表达式 %q 的结果为字符串，算法只支持瞬时向量或标量", expr))
+	case parser.ValueTypeMatrix:
+		if rangeQuery {
+			return nil, util.PluginError(backend.StatusBadRequest,
+				fmt.Errorf("expression %q returns a range vector, which cannot be used in a range query; "+
+					"wrap it with a function such as rate()", expr)).
+				WithMessageCn(fmt.Sprintf("表达式 %q 的结果为区间向量，不能用于范围查询，请使用rate()等函数", expr))
+		}
+	}
+	return parsed, nil
+}
+
+// parseError 将解析错误转换为带行列位置的错误
+func parseError(expr string, err error) error {
+	var errs parser.ParseErrors
+	if !errors.As(err, &errs) || len(errs) == 0 {
+		return util.PluginError(backend.StatusBadRequest, fmt.Errorf("invalid PromQL expression: %w", err))
+	}
+	pe := errs[0]
+	line, col := position(expr, int(pe.PositionRange.Start))
+	return util.PluginError(backend.StatusBadRequest,
+		fmt.Errorf("invalid PromQL expression at line %d, column %d: %v", line, col, pe.Err)).
+		WithMessageCn(fmt.Sprintf("PromQL表达式第%d行第%d列存在语法错误：%v", line, col, pe.Err))
+}
+
+// position 将字节偏移转换为从1开始的行号和列号
+func position(expr string, pos int) (int, int) {
+	if pos < 0 {
+		pos = 0
+	}
+	if pos > len(expr) {
+		pos = len(expr)
+	}
+	line, lastLineBreak := 1, -1
+	for i, c := range expr[:pos] {
+		if c == '\n' {
+			line++
+			lastLineBreak = i
+		}
+	}
+	return line, pos - lastLineBreak
+}
+
+// queryHints 根据表达式结构给出建议：计数器缺少rate()、选择器没有标签过滤、rate的区间小于step
+func queryHints(expr parser.Expr, step time.Duration) []data.Notice {
+	var (
+		notices []data.Notice
+		seen    = make(map[string]bool)
+	)
+	add := func(severity data.NoticeSeverity, text string) {
+		if !seen[text] {
+			seen[text] = true
+			notices = append(notices, data.Notice{Severity: severity, Text: text})
+		}
+	}
+	parser.Inspect(expr, func(node parser.Node, path []parser.Node) error {
+		switch n := node.(type) {
+		case *parser.VectorSelector:
+			// 整个表达式只是一个选择器时，计数器由变换步骤提示
+			if len(path) > 0 && isCounterName(n.Name) && !insideRate(path) {
+				add(data.NoticeSeverityWarning, fmt.Sprintf("%s looks like a counter but is used without rate(); "+
+					"apply rate() or increase() before aggregating or detecting anomalies.", n.Name))
+			}
+			if !hasLabelFilter(n) {
+				add(data.NoticeSeverityInfo, fmt.Sprintf("Selector %s has no label matchers and may return many "+
+					"series; add label filters to reduce cardinality.", n.String()))
+			}
+		case *parser.Call:
+			if !rateFunctions[n.Func.Name] || step <= 0 {
+				return nil
+			}
+			for _, arg := range n.Args {
+				ms, ok := arg.(*parser.MatrixSelector)
+				if ok && ms.Range < step {
+					add(data.NoticeSeverityWarning, fmt.Sprintf("The range [%s] in %s() is shorter than the query "+
+						"step %s, so samples between steps are ignored; use $__rate_interval instead.",
+						ms.Range, n.Func.Name, step))
+				}
+			}
+		}
+		return nil
+	})
+	return notices
+}
+
+func isCounterName(name string) bool {
+	for _, suffix := range counterSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func insideRate(path []parser.Node) bool {
+	for _, node := range path {
+		if call, ok := node.(*parser.Call); ok && rateFunctions[call.Func.Name] {
+			return true
+		}
+	}
+	return false
+}
+
+// hasLabelFilter 除指标名外至少有一个能缩小范围的匹配条件
+func hasLabelFilter(vs *parser.VectorSelector) bool {
+	for _, m := range vs.LabelMatchers {
+		if m.Name == labels.MetricName {
+			continue
+		}
+		switch m.Type {
+		case labels.MatchEqual:
+			return true
+		case labels.MatchRegexp:
+			if m.Value != ".*" && m.Value != ".+" {
+				return true
+			}
+		case labels.MatchNotEqual, labels.MatchNotRegexp:
+			if m.Value != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// appendHints 查询建议附加在第一个frame上，复制meta避免共享meta的frame重复展示
+func appendHints(r *backend.DataResponse, notices []data.Notice) {
+	if r == nil || len(notices) == 0 || len(r.Frames) == 0 {
+		return
+	}
+	frame := r.Frames[0]
+	frame.Meta = converter.CopyFrameMeta(frame.Meta)
+	frame.AppendNotices(notices...)
+}
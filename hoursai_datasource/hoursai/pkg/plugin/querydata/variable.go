@@ -0,0 +1,174 @@
+package querydata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/client"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+var (
+	labelValuesRegexp = regexp.MustCompile(`^label_values\((?:(.+),\s*)?([a-zA-Z_][a-zA-Z0-9_]*)\)\s*$`)
+	metricNamesRegexp = regexp.MustCompile(`^metrics\((.+)\)\s*$`)
+	queryResultRegexp = regexp.MustCompile(`^query_result\((.+)\)\s*$`)
+)
+
+// VariableRequest 模板变量查询，from和to为仪表盘时间范围的秒级时间戳
+type VariableRequest struct {
+	Query string `json:"query"`
+	From  int64  `json:"from"`
+	To    int64  `json:"to"`
+}
+
+// MetricFindValue 与grafana前端metricFindQuery的返回格式一致
+type MetricFindValue struct {
+	Text string `json:"text"`
+}
+
+// MetricFindQuery 执行prometheus风格的模板变量查询，支持label_values(label)、label_values(metric, label)、
+// metrics(regex)和query_result(expr)，headers为需要转发给prometheus的认证信息
+func (s *QueryData) MetricFindQuery(ctx context.Context, body []byte, headers map[string]string) ([]byte, error) {
+	var req VariableRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		log.DefaultLogger.Error("Variable body to struct error, error is: ", err)
+		return nil, util.PluginError(backend.StatusBadRequest, fmt.Errorf("invalid variable request: %w", err))
+	}
+
+	var (
+		texts []string
+		err   error
+		query = strings.TrimSpace(req.Query)
+	)
+	if m := labelValuesRegexp.FindStringSubmatch(query); m != nil {
+		texts, err = s.labelValues(ctx, m[2], strings.TrimSpace(m[1]), req, headers)
+	} else if m := metricNamesRegexp.FindStringSubmatch(query); m != nil {
+		texts, err = s.metricNames(ctx, strings.TrimSpace(m[1]), req, headers)
+	} else if m := queryResultRegexp.FindStringSubmatch(query); m != nil {
+		texts, err = s.queryResult(ctx, strings.TrimSpace(m[1]), req, headers)
+	} else if query != "" {
+		err = util.PluginError(backend.StatusBadRequest, fmt.Errorf("unsupported variable query %q, expected "+
+			"label_values(label), label_values(metric, label), metrics(regex) or query_result(expr)", query))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]MetricFindValue, 0, len(texts))
+	for _, text := range texts {
+		values = append(values, MetricFindValue{Text: text})
+	}
+	return json.Marshal(values)
+}
+
+// labelValues 查询时间范围内标签的取值，指定metric时只返回该指标序列上的取值
+func (s *QueryData) labelValues(ctx context.Context, label string, metric string, req VariableRequest,
+	headers map[string]string) ([]string, error) {
+	m := client.MetadataRange{Start: req.From, End: req.To}
+	if metric != "" {
+		m.Matches = []string{metric}
+	}
+	res, err := s.client.QueryLabelValues(ctx, label, m, util.SdkHeaderToHttpHeader(headers))
+	if err != nil {
+		return nil, util.DownstreamError(0, err)
+	}
+	raw, err := readAPIResponse(res)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, util.DownstreamError(backend.StatusBadGateway,
+			fmt.Errorf("prometheus returned invalid label values: %w", err))
+	}
+	return values, nil
+}
+
+// metricNames 查询时间范围内的指标名，按正则过滤，与grafana一致不要求完整匹配
+func (s *QueryData) metricNames(ctx context.Context, pattern string, req VariableRequest,
+	headers map[string]string) ([]string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, util.PluginError(backend.StatusBadRequest, fmt.Errorf("invalid metric regex %q: %w", pattern,
+			err))
+	}
+	names, err := s.labelValues(ctx, "__name__", "", req, headers)
+	if err != nil {
+		return nil, err
+	}
+	var matched []string
+	for _, name := range names {
+		if re.MatchString(name) {
+			matched = append(matched, name)
+		}
+	}
+	return matched, nil
+}
+
+// queryResult 在时间范围结束时执行即时查询，每条序列输出为 name{labels} value timestamp
+func (s *QueryData) queryResult(ctx context.Context, expr string, req VariableRequest,
+	headers map[string]string) ([]string, error) {
+	// 即时查询的时间按秒对齐，step不能为0
+	q := &models.Query{Expr: expr, InstantQuery: true, Step: time.Second}
+	if req.To > 0 {
+		q.Start = time.Unix(req.To, 0)
+		q.End = q.Start
+	}
+	res, err := s.client.QueryInstant(ctx, q, util.SdkHeaderToHttpHeader(headers))
+	if err != nil {
+		return nil, util.DownstreamError(0, err)
+	}
+	r, err := s.parseResponse(q, res)
+	if err != nil {
+		return nil, err
+	}
+	if r.Error != nil {
+		return nil, util.DownstreamError(0, r.Error)
+	}
+
+	var texts []string
+	for _, frame := range r.Frames {
+		if len(frame.Fields) < 2 || frame.Fields[0].Len() == 0 {
+			continue
+		}
+		last := frame.Fields[0].Len() - 1
+		value, err := frame.Fields[1].NullableFloatAt(last)
+		if err != nil || value == nil {
+			continue
+		}
+		text := formatSeries(frame.Fields[1].Labels) + " " + strconv.FormatFloat(*value, 'f', -1, 64)
+		if v, ok := frame.Fields[0].ConcreteAt(last); ok {
+			if ts, ok := v.(time.Time); ok {
+				text += " " + strconv.FormatInt(ts.UnixMilli(), 10)
+			}
+		}
+		texts = append(texts, text)
+	}
+	return texts, nil
+}
+
+// formatSeries 按prometheus的格式输出指标名和排序后的标签
+func formatSeries(labels data.Labels) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		if k != "__name__" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, labels[k]))
+	}
+	return labels["__name__"] + "{" + strings.Join(pairs, ",") + "}"
+}
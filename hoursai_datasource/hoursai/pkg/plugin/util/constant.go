@@ -9,6 +9,7 @@ const (
 	RealtimeInitPath    = TaskPathPrefix + "init/"
 	RealtimeRunPath     = TaskPathPrefix + "run/"
 	RealtimeResultPath  = TaskPathPrefix + "result/"
+	MultivariatePath    = TaskPathPrefix + "multivariate/preview/"
 	GenerateTokenPath   = TokenPathPrefix
 
 	SyncPreviewType    = "syncPreview"
@@ -17,10 +18,60 @@ const (
 	RealtimeInitType   = "generateTaskId"
 	RealtimeResultType = "realtimeResult"
 	GenerateTokenType  = "generateToken"
+	MultivariateType   = "multivariate"
+	SeasonalType       = "seasonal"
 
-	MetricsType    = "metrics"
-	LabelNamesType = "labelNames"
-	SeriesType     = "series"
+	MetricsType     = "metrics"
+	LabelNamesType  = "labelNames"
+	LabelValuesType = "labelValues"
+	SeriesType      = "series"
+	MetadataType    = "metadata"
+	RootCauseType   = "rootCause"
+	VariableType    = "variable"
 
 	ProjectType = "grafana"
+
+	SceneAnomalyDetection = "timeseries_anomaly_detection"
+	SceneForecast         = "timeseries_forecast"
+	SceneChangePoint      = "timeseries_change_point_detection"
+	SceneLogClustering    = "log_clustering"
+
+	TopKByCount        = "count"
+	TopKBySignificance = "significance"
+
+	CardinalityReject      = "reject"
+	CardinalityTopVariance = "topVariance"
+	CardinalityHashSample  = "hashSample"
+
+	EngineManager = "manager"
+	EngineBuiltin = "builtin"
+
+	MissingDrop        = "drop"
+	MissingLinear      = "linear"
+	MissingForwardFill = "forwardFill"
+	MissingMark        = "mark"
+
+	TransformRate       = "rate"
+	TransformDelta      = "delta"
+	TransformDerivative = "derivative"
+	TransformLog        = "log"
+
+	CounterWarn = "warn"
+	CounterAuto = "auto"
+	CounterOff  = "off"
+
+	DownsampleLTTB   = "lttb"
+	DownsampleAvg    = "avg"
+	DownsampleMinMax = "minmax"
+
+	WindowMaintenance = "maintenance"
+	WindowAnnotation  = "annotation"
+
+	DirectionBoth  = "both"
+	DirectionAbove = "above"
+	DirectionBelow = "below"
+
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
 )
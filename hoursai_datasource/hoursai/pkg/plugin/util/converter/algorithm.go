@@ -3,6 +3,7 @@ package converter
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
@@ -20,11 +21,104 @@ type CoreResponse struct {
 	Code    int         `json:"code"`
 }
 
+// sceneFrameNames 各场景算法结果需要生成的frame，按顺序输出
+var sceneFrameNames = map[string][]string{
+	util.SceneAnomalyDetection: {"upper", "lower", "baseline", "anomaly", "significance"},
+	util.SceneForecast:         {"forecast", "upper", "lower"},
+	util.SceneChangePoint:      {"changePoint", "score"},
+}
+
+// algorithmFields 单条序列的算法结果，按返回的字段名保存
+type algorithmFields struct {
+	time   *data.Field
+	values map[string]*data.Field
+	names  []string
+}
+
+// frameNames 返回当前场景需要输出的字段名，未知场景输出算法返回的全部字段
+func (a *algorithmFields) frameNames(scene string) []string {
+	if names, ok := sceneFrameNames[scene]; ok {
+		return names
+	}
+	names := make([]string, 0, len(a.names))
+	for _, name := range a.names {
+		if name != "value" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// SeriesFailure 单条序列的算法执行失败信息，Index为该序列在本次请求中的下标
+type SeriesFailure struct {
+	Index     int
+	Code      int
+	Message   string
+	MessageCn string
+}
+
+// Notice 失败信息转换为frame上的notice，同时展示中英文信息
+func (f SeriesFailure) Notice(severity data.NoticeSeverity) data.Notice {
+	text := "Algorithm failed for this series"
+	if f.Code != 0 {
+		text = fmt.Sprintf("%s (code %d)", text, f.Code)
+	}
+	if f.Message != "" {
+		text += ": " + f.Message
+	}
+	if f.MessageCn != "" && f.MessageCn != f.Message {
+		text += " / " + f.MessageCn
+	}
+	return data.Notice{Severity: severity, Text: text}
+}
+
+// seriesFailed 单条序列的status为error或failed时表示该序列计算失败
+func seriesFailed(status string) bool {
+	return status == "error" || status == "failed"
+}
+
+// CopyFrameMeta 复制frame meta，避免多个frame共享同一个meta时互相追加notice
+func CopyFrameMeta(meta *data.FrameMeta) *data.FrameMeta {
+	if meta == nil {
+		return nil
+	}
+	m := *meta
+	m.Notices = append([]data.Notice(nil), meta.Notices...)
+	return &m
+}
+
+// WithCustomMeta 复制meta并在Custom中追加处理信息，Custom保持map[string]string以便识别resultType
+func WithCustomMeta(meta *data.FrameMeta, values map[string]string) *data.FrameMeta {
+	newMeta := CopyFrameMeta(meta)
+	if newMeta == nil {
+		newMeta = &data.FrameMeta{}
+	}
+	custom := map[string]string{}
+	switch c := newMeta.Custom.(type) {
+	case map[string]string:
+		for k, v := range c {
+			custom[k] = v
+		}
+	case map[string]interface{}:
+		for k, v := range c {
+			custom[k] = fmt.Sprint(v)
+		}
+	}
+	for k, v := range values {
+		custom[k] = v
+	}
+	newMeta.Custom = custom
+	return newMeta
+}
+
+// ReadAlgorithmStyleResult 解析算法结果，按规则过滤异常点，同时返回计算失败的序列
 func ReadAlgorithmStyleResult(iter *jsoniter.Iterator, result *backend.DataResponse, responseType string,
-	metaInfos []map[string]string, series string) *backend.DataResponse {
+	metaInfos []map[string]string, series string, scene string, rules AnomalyRules) (*backend.DataResponse,
+	[]SeriesFailure) {
 	var (
 		rsp       *backend.DataResponse
-		code      backend.Status
+		failures  []SeriesFailure
+		code      int
 		status    = "unknown"
 		message   = ""
 		messageCn = ""
@@ -37,9 +131,11 @@ func ReadAlgorithmStyleResult(iter *jsoniter.Iterator, result *backend.DataRespo
 		case "data":
 			switch responseType {
 			case util.RealtimeResultType:
-				rsp = readRealtimeResultData(iter, result)
+				rsp = readRealtimeResultData(iter, result, scene, rules)
+			case util.MultivariateType:
+				rsp, failures = readMultivariateData(iter, result, metaInfos)
 			default:
-				rsp = readAlgorithmData(iter, result, metaInfos, series)
+				rsp, failures = readAlgorithmData(iter, result, metaInfos, series, scene, rules)
 			}
 			log.DefaultLogger.Debug("Case data: ", "key", l1Field, "value", rsp)
 		case "message":
@@ -49,7 +145,7 @@ func ReadAlgorithmStyleResult(iter *jsoniter.Iterator, result *backend.DataRespo
 			messageCn = iter.ReadString()
 			log.DefaultLogger.Info("Case msg: ", "key", l1Field, "value", messageCn)
 		case "code":
-			code = backend.Status(iter.ReadInt())
+			code = iter.ReadInt()
 			log.DefaultLogger.Info("Case code: ", "key", l1Field, "value", code)
 		default:
 			v := iter.Read()
@@ -58,16 +154,38 @@ func ReadAlgorithmStyleResult(iter *jsoniter.Iterator, result *backend.DataRespo
 	}
 
 	if status == "error" {
-		rsp.Error = errors.New(message)
-		rsp.Status = code
+		// 出错时manager可能不返回data
+		if rsp == nil {
+			rsp = &backend.DataResponse{}
+		}
+		// code是manager的业务码，只有在HTTP状态码范围内时才有意义，否则按下游错误处理
+		err := util.DownstreamError(util.StatusFromHTTP(code), errors.New(message)).WithMessageCn(messageCn)
+		rsp.Error = err
+		rsp.Status = err.Status
 	}
-	return rsp
+	return rsp, failures
 }
 
-func readAlgorithmData(iter *jsoniter.Iterator, result *backend.DataResponse, metaInfos []map[string]string, series string) *backend.DataResponse {
-	labels := data.Labels{}
+func readAlgorithmData(iter *jsoniter.Iterator, result *backend.DataResponse, metaInfos []map[string]string,
+	series string, scene string, rules AnomalyRules) (*backend.DataResponse, []SeriesFailure) {
+	var (
+		meta     *data.FrameMeta
+		selected data.Frames
+		failures []SeriesFailure
+	)
+	if len(result.Frames) > 0 {
+		meta = result.Frames[0].Meta
+	}
 	for i := 0; iter.ReadArray(); i++ {
+		// manager返回的结果比请求的序列多时，多出的结果无法对应到序列
+		if i >= len(metaInfos) {
+			log.DefaultLogger.Error("Algorithm result has more items than requested series", "requested",
+				len(metaInfos))
+			iter.Skip()
+			continue
+		}
 		metaInfo := metaInfos[i]
+		labels := data.Labels{}
 		if err := json.Unmarshal([]byte(metaInfo["labels"]), &labels); err != nil {
 			log.DefaultLogger.Error("Label string to map error, ", err)
 		}
@@ -76,17 +194,11 @@ func readAlgorithmData(iter *jsoniter.Iterator, result *backend.DataResponse, me
 			log.DefaultLogger.Error("Interval to float error, ", err)
 		}
 		var (
-			code              int
-			status            = "unknown"
-			message           = ""
-			messageCn         = ""
-			labels            data.Labels
-			timeField         *data.Field
-			upperField        *data.Field
-			lowerField        *data.Field
-			baselineField     *data.Field
-			anomalyField      *data.Field
-			significanceField *data.Field
+			code      int
+			status    = "unknown"
+			message   = ""
+			messageCn = ""
+			fields    *algorithmFields
 		)
 		for l1Field := iter.ReadObject(); l1Field != ""; l1Field = iter.ReadObject() {
 			switch l1Field {
@@ -97,89 +209,129 @@ func readAlgorithmData(iter *jsoniter.Iterator, result *backend.DataResponse, me
 				log.DefaultLogger.Info("Case message: ", "key", l1Field, "value", message)
 				log.DefaultLogger.Info("Case messageCn: ", "key", l1Field, "value", messageCn)
 			case "data":
-				timeField, _, upperField, lowerField, baselineField, anomalyField,
-					significanceField = readData(iter, labels, interval)
+				fields = readData(iter, labels, interval)
 			default:
 				log.DefaultLogger.Info("Case default: ", "key", l1Field, "value", iter.Read())
 			}
 		}
-		switch series {
-		case "anomaly":
-			anomalyFrame := data.NewFrame("anomaly", timeField, anomalyField)
-			anomalyFrame.Meta = result.Frames[0].Meta
-			frames := data.Frames{anomalyFrame}
-			result = &backend.DataResponse{
-				Frames: frames,
+		var failure *SeriesFailure
+		if seriesFailed(status) {
+			failure = &SeriesFailure{Index: i, Code: code, Message: message, MessageCn: messageCn}
+			failures = append(failures, *failure)
+		}
+		if fields == nil {
+			continue
+		}
+		// 同一序列的显著性、原始值、基线和上下界都在fields中，按规则过滤异常点并分级后再选取输出的frame
+		frames := make(map[string]*data.Frame, len(fields.values))
+		for name, field := range fields.values {
+			frame := data.NewFrame(name, fields.time, field)
+			if series != "" {
+				frame.Meta = CopyFrameMeta(meta)
 			}
-		default:
-			upperFrame := data.NewFrame("upper", timeField, upperField)
-			result.Frames = append(result.Frames, upperFrame)
-			lowerFrame := data.NewFrame("lower", timeField, lowerField)
-			result.Frames = append(result.Frames, lowerFrame)
-			baselineFrame := data.NewFrame("baseline", timeField, baselineField)
-			result.Frames = append(result.Frames, baselineFrame)
-			anomalyFrame := data.NewFrame("anomaly", timeField, anomalyField)
-			result.Frames = append(result.Frames, anomalyFrame)
-			significanceFrame := data.NewFrame("significance", timeField, significanceField)
-			result.Frames = append(result.Frames, significanceFrame)
+			frames[name] = frame
+		}
+		rules.ApplyFrames(frames, series != "")
+		for _, name := range fields.frameNames(scene) {
+			frame, ok := frames[name]
+			if !ok {
+				continue
+			}
+			// series指定了输出的frame时只返回该frame（如告警只需要anomaly）
+			if series != "" && name != series {
+				continue
+			}
+			// 失败序列仍返回的部分结果上附加说明
+			if failure != nil {
+				frame.AppendNotices(failure.Notice(data.NoticeSeverityWarning))
+			}
+			if series != "" {
+				selected = append(selected, frame)
+				continue
+			}
+			result.Frames = append(result.Frames, frame)
 		}
-
 	}
-	return result
+	if series != "" {
+		return &backend.DataResponse{
+			Frames: selected,
+		}, failures
+	}
+	return result, failures
+}
+
+// markerFields 取值为标记的算法结果，点缺失时按未标记补0，使告警在缺点时仍得到数值
+var markerFields = map[string]bool{
+	"anomaly":     true,
+	"changePoint": true,
 }
 
-func readData(iter *jsoniter.Iterator, labels data.Labels, interval float64) (*data.Field, *data.Field, *data.Field,
-	*data.Field, *data.Field, *data.Field, *data.Field) {
+// readData 读取单条序列的算法结果，除timestamp外的数值字段均按字段名生成field；标记字段为float64，
+// 其余字段为可空float64，点缺失的字段为null，避免把缺失的上下界、基线画成0
+func readData(iter *jsoniter.Iterator, labels data.Labels, interval float64) *algorithmFields {
 	timeField := data.NewFieldFromFieldType(data.FieldTypeTime, 0)
 	timeField.Name = data.TimeSeriesTimeFieldName
 	timeField.Config = &data.FieldConfig{Interval: interval * 1000}
 
-	valueField := data.NewFieldFromFieldType(data.FieldTypeFloat64, 0)
-	valueField.Name = data.TimeSeriesValueFieldName
-	valueField.Labels = labels
-
-	upperField := data.NewFieldFromFieldType(data.FieldTypeFloat64, 0)
-	upperField.Name = data.TimeSeriesValueFieldName
-	upperField.Labels = labels
-
-	lowerField := data.NewFieldFromFieldType(data.FieldTypeFloat64, 0)
-	lowerField.Name = data.TimeSeriesValueFieldName
-	lowerField.Labels = labels
-
-	baselineField := data.NewFieldFromFieldType(data.FieldTypeFloat64, 0)
-	baselineField.Name = data.TimeSeriesValueFieldName
-	baselineField.Labels = labels
-
-	anomalyField := data.NewFieldFromFieldType(data.FieldTypeFloat64, 0)
-	anomalyField.Name = data.TimeSeriesValueFieldName
-	anomalyField.Labels = labels
-
-	significanceField := data.NewFieldFromFieldType(data.FieldTypeFloat64, 0)
-	significanceField.Name = data.TimeSeriesValueFieldName
-	significanceField.Labels = labels
+	fields := &algorithmFields{
+		time:   timeField,
+		values: make(map[string]*data.Field),
+	}
 	for iter.ReadArray() {
+		var (
+			ts     *time.Time
+			values = make(map[string]float64)
+			order  []string
+		)
 		for l1Field := iter.ReadObject(); l1Field != ""; l1Field = iter.ReadObject() {
-			switch l1Field {
-			case "timestamp":
-				timeField.Append(time.UnixMilli(iter.ReadInt64()))
-			case "value":
-				valueField.Append(iter.ReadFloat64())
-			case "upper":
-				upperField.Append(iter.ReadFloat64())
-			case "lower":
-				lowerField.Append(iter.ReadFloat64())
-			case "baseline":
-				baselineField.Append(iter.ReadFloat64())
-			case "anomaly":
-				anomalyField.Append(iter.ReadFloat64())
-			case "significance":
-				significanceField.Append(iter.ReadFloat64())
-			default:
+			if l1Field == "timestamp" {
+				t := time.UnixMilli(iter.ReadInt64())
+				ts = &t
+				continue
+			}
+			if iter.WhatIsNext() != jsoniter.NumberValue {
 				log.DefaultLogger.Info("Algorithm result case default", "key", l1Field, "value", iter.Read())
+				continue
+			}
+			values[l1Field] = iter.ReadFloat64()
+			order = append(order, l1Field)
+		}
+		if ts == nil {
+			log.DefaultLogger.Error("Algorithm result point without timestamp is skipped", "values", values)
+			continue
+		}
+		points := timeField.Len()
+		timeField.Append(*ts)
+		for _, name := range order {
+			if _, ok := fields.values[name]; ok {
+				continue
+			}
+			// 字段在后续点中才出现时，前面的点为null，标记字段为0
+			fieldType := data.FieldTypeNullableFloat64
+			if markerFields[name] {
+				fieldType = data.FieldTypeFloat64
+			}
+			field := data.NewFieldFromFieldType(fieldType, points)
+			field.Name = data.TimeSeriesValueFieldName
+			field.Labels = labels
+			fields.values[name] = field
+			fields.names = append(fields.names, name)
+		}
+		// 已知字段在当前点缺失时同样补齐，保证所有字段与时间字段等长
+		for _, name := range fields.names {
+			field := fields.values[name]
+			v, ok := values[name]
+			switch {
+			case field.Type() == data.FieldTypeFloat64:
+				field.Append(v)
+			case ok:
+				field.Append(&v)
+			default:
+				field.Append((*float64)(nil))
 			}
 		}
 	}
-	return timeField, valueField, upperField, lowerField, baselineField, anomalyField, significanceField
+	return fields
 }
 
 func readStatus(iter *jsoniter.Iterator) (int, string, string, string) {
@@ -204,13 +356,42 @@ func readStatus(iter *jsoniter.Iterator) (int, string, string, string) {
 			messageCn = iter.ReadString()
 			log.DefaultLogger.Info("Algorithm result case messageCn", "key", l1Field, "value", messageCn)
 		default:
-			log.DefaultLogger.Info("Algorithm result case default", "key", l1Field, "value", iter.ReadString())
+			log.DefaultLogger.Info("Algorithm result case default", "key", l1Field, "value", iter.Read())
 		}
 	}
 	return code, status, message, messageCn
 }
 
-func readRealtimeResultData(iter *jsoniter.Iterator, result *backend.DataResponse) *backend.DataResponse {
+// ResultName frame对应的算法结果名，实时结果的frame以指标名命名（如anomaly_task_1），按前缀得到结果名，
+// 不是已知结果时返回frame名
+func ResultName(frameName string) string {
+	for _, names := range sceneFrameNames {
+		for _, name := range names {
+			if frameName == name || strings.HasPrefix(frameName, name+"_") {
+				return name
+			}
+		}
+	}
+	return frameName
+}
+
+// realtimeFrameName 根据指标名前缀匹配当前场景需要输出的frame
+func realtimeFrameName(metricName string, scene string) string {
+	names, ok := sceneFrameNames[scene]
+	if !ok {
+		return metricName
+	}
+	for _, name := range names {
+		if strings.HasPrefix(metricName, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+func readRealtimeResultData(iter *jsoniter.Iterator, result *backend.DataResponse, scene string,
+	rules AnomalyRules) *backend.DataResponse {
+	first := len(result.Frames)
 	for iter.ReadArray() {
 		for l1Field := iter.ReadObject(); l1Field != ""; l1Field = iter.ReadObject() {
 			switch l1Field {
@@ -245,22 +426,10 @@ func readRealtimeResultData(iter *jsoniter.Iterator, result *backend.DataRespons
 									}
 								}
 							}
-							switch {
-							case strings.HasPrefix(labels["__name__"], "upper"):
-								upperFrame := data.NewFrame(labels["__name__"], timeField, valueField)
-								result.Frames = append(result.Frames, upperFrame)
-							case strings.HasPrefix(labels["__name__"], "lower"):
-								lowerFrame := data.NewFrame(labels["__name__"], timeField, valueField)
-								result.Frames = append(result.Frames, lowerFrame)
-							case strings.HasPrefix(labels["__name__"], "baseline"):
-								baselineFrame := data.NewFrame(labels["__name__"], timeField, valueField)
-								result.Frames = append(result.Frames, baselineFrame)
-							case strings.HasPrefix(labels["__name__"], "anomaly"):
-								anomalyFrame := data.NewFrame(labels["__name__"], timeField, valueField)
-								result.Frames = append(result.Frames, anomalyFrame)
-							case strings.HasPrefix(labels["__name__"], "significance"):
-								significanceFrame := data.NewFrame(labels["__name__"], timeField, valueField)
-								result.Frames = append(result.Frames, significanceFrame)
+							// frame名保持为指标名，面板的override按该名称匹配
+							if name := realtimeFrameName(labels["__name__"], scene); name != "" {
+								frame := data.NewFrame(labels["__name__"], timeField, valueField)
+								result.Frames = append(result.Frames, frame)
 							}
 						}
 					default:
@@ -273,6 +442,7 @@ func readRealtimeResultData(iter *jsoniter.Iterator, result *backend.DataRespons
 			}
 		}
 	}
+	rules.applyRealtime(result.Frames[first:], scene)
 	return result
 }
 
@@ -380,29 +550,22 @@ func readRealtimeInitData(iter *jsoniter.Iterator) []string {
 	return taskInfos
 }
 
+// readAlgorithmListData 读取全部场景下的算法列表，每个算法附带所属场景
 func readAlgorithmListData(iter *jsoniter.Iterator) []string {
 	algorithmList := make([]string, 0)
 	for iter.ReadArray() {
 		var (
-			algorithmByte []byte
-			err           error
-			name          string
+			scene      string
+			algorithms []map[string]string
 		)
-		algorithm := make(map[string]string)
-
 		for l1Field := iter.ReadObject(); l1Field != ""; l1Field = iter.ReadObject() {
 			switch l1Field {
 			case "name":
-				name = iter.ReadString()
-				log.DefaultLogger.Info("Case scene name: ", "key", l1Field, "value", name)
-				if name != "timeseries_anomaly_detection" {
-					continue
-				}
+				scene = iter.ReadString()
+				log.DefaultLogger.Info("Case scene name: ", "key", l1Field, "value", scene)
 			case "algorithms":
-				if name != "timeseries_anomaly_detection" {
-					continue
-				}
 				for iter.ReadArray() {
+					algorithm := make(map[string]string)
 					for l2Field := iter.ReadObject(); l2Field != ""; l2Field = iter.ReadObject() {
 						switch l2Field {
 						case "name":
@@ -421,13 +584,21 @@ func readAlgorithmListData(iter *jsoniter.Iterator) []string {
 							log.DefaultLogger.Info("Case default: ", "key", l2Field, "value", iter.Read())
 						}
 					}
-					if algorithmByte, err = json.Marshal(algorithm); err != nil {
-						log.DefaultLogger.Error("Algorithm to json error,", err)
-						return algorithmList
-					}
-					algorithmList = append(algorithmList, string(algorithmByte))
+					algorithms = append(algorithms, algorithm)
 				}
+			default:
+				log.DefaultLogger.Info("Case default: ", "key", l1Field, "value", iter.Read())
+			}
+		}
+		// 场景名可能出现在algorithms之后，读完整个场景后再补充
+		for _, algorithm := range algorithms {
+			algorithm["scene"] = scene
+			algorithmByte, err := json.Marshal(algorithm)
+			if err != nil {
+				log.DefaultLogger.Error("Algorithm to json error,", err)
+				return algorithmList
 			}
+			algorithmList = append(algorithmList, string(algorithmByte))
 		}
 	}
 	return algorithmList
@@ -0,0 +1,76 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	jsoniter "github.com/json-iterator/go"
+)
+
+func TestReadDataPadsMissingFields(t *testing.T) {
+	body := `[
+		{"timestamp": 1000, "value": 1, "upper": 2},
+		{"timestamp": 2000, "value": 3, "anomaly": 1},
+		{"value": 9},
+		{"timestamp": 3000, "upper": 4}
+	]`
+	iter := jsoniter.ParseString(jsoniter.ConfigDefault, body)
+	fields := readData(iter, data.Labels{"a": "b"}, 60)
+
+	if got := fields.time.Len(); got != 3 {
+		t.Fatalf("time field has %d points, want 3", got)
+	}
+	// 缺失的上下界和原始值为null，缺失的标记为0
+	want := map[string][]*float64{
+		"value":   {fp(1), fp(3), nil},
+		"upper":   {fp(2), nil, fp(4)},
+		"anomaly": {fp(0), fp(1), fp(0)},
+	}
+	for name, values := range want {
+		field, ok := fields.values[name]
+		if !ok {
+			t.Fatalf("field %s is missing", name)
+		}
+		if field.Len() != len(values) {
+			t.Fatalf("field %s has %d points, want %d", name, field.Len(), len(values))
+		}
+		for i, v := range values {
+			got, err := field.NullableFloatAt(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == nil) != (v == nil) || (got != nil && *got != *v) {
+				t.Errorf("%s[%d] = %v, want %v", name, i, got, v)
+			}
+		}
+	}
+	if field := fields.values["anomaly"]; field.Type() != data.FieldTypeFloat64 {
+		t.Errorf("anomaly field type = %s, want float64", field.Type())
+	}
+	if got, want := fields.names, []string{"value", "upper", "anomaly"}; len(got) != len(want) ||
+		got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("names = %v, want %v", got, want)
+	}
+}
+
+func fp(v float64) *float64 {
+	return &v
+}
+
+func TestRealtimeFrameName(t *testing.T) {
+	tests := []struct {
+		metric string
+		scene  string
+		want   string
+	}{
+		{"anomaly_task_1", "timeseries_anomaly_detection", "anomaly"},
+		{"upper_task_1", "timeseries_anomaly_detection", "upper"},
+		{"unknown_task_1", "timeseries_anomaly_detection", ""},
+		{"custom_task_1", "custom", "custom_task_1"},
+	}
+	for _, tt := range tests {
+		if got := realtimeFrameName(tt.metric, tt.scene); got != tt.want {
+			t.Errorf("realtimeFrameName(%s, %s) = %q, want %q", tt.metric, tt.scene, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package converter
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// DimensionLabel 多变量结果中贡献度frame用于区分维度的标签
+const DimensionLabel = "dimension"
+
+// MultivariateResult 一组联合序列的多变量检测结果
+type MultivariateResult struct {
+	Timestamps    []time.Time
+	Score         []float64
+	Anomaly       []float64
+	Contributions [][]float64
+}
+
+// NewMultivariateFrames 生成联合异常分数、异常点以及每个维度贡献度的frame
+func NewMultivariateFrames(r MultivariateResult, labels data.Labels, dimensions []string,
+	interval float64) data.Frames {
+	// 每个frame使用独立的时间字段，避免后续修改某个frame时影响其他frame
+	newTimeField := func() *data.Field {
+		timeField := data.NewField(data.TimeSeriesTimeFieldName, nil, append([]time.Time(nil), r.Timestamps...))
+		timeField.Config = &data.FieldConfig{Interval: interval * 1000}
+		return timeField
+	}
+
+	scoreField := data.NewField(data.TimeSeriesValueFieldName, labels, r.Score)
+	anomalyField := data.NewField(data.TimeSeriesValueFieldName, labels, r.Anomaly)
+	frames := data.Frames{
+		data.NewFrame("score", newTimeField(), scoreField),
+		data.NewFrame("anomaly", newTimeField(), anomalyField),
+	}
+	for i, dimension := range dimensions {
+		if i >= len(r.Contributions) {
+			break
+		}
+		dimensionLabels := labels.Copy()
+		dimensionLabels[DimensionLabel] = dimension
+		field := data.NewField(data.TimeSeriesValueFieldName, dimensionLabels, r.Contributions[i])
+		frames = append(frames, data.NewFrame("contribution", newTimeField(), field))
+	}
+	return frames
+}
+
+// readMultivariateData 读取多变量检测结果，每个元素对应一组联合序列
+func readMultivariateData(iter *jsoniter.Iterator, result *backend.DataResponse,
+	metaInfos []map[string]string) (*backend.DataResponse, []SeriesFailure) {
+	var failures []SeriesFailure
+	for i := 0; iter.ReadArray(); i++ {
+		// manager返回的结果比请求的序列多时，多出的结果无法对应到序列
+		if i >= len(metaInfos) {
+			log.DefaultLogger.Error("Algorithm result has more items than requested series", "requested",
+				len(metaInfos))
+			iter.Skip()
+			continue
+		}
+		metaInfo := metaInfos[i]
+		labels := data.Labels{}
+		if err := json.Unmarshal([]byte(metaInfo["labels"]), &labels); err != nil {
+			log.DefaultLogger.Error("Label string to map error, ", err)
+		}
+		var dimensions []string
+		if err := json.Unmarshal([]byte(metaInfo["dimensions"]), &dimensions); err != nil {
+			log.DefaultLogger.Error("Dimension string to slice error, ", err)
+		}
+		interval, err := strconv.ParseFloat(metaInfo["interval"], 64)
+		if err != nil {
+			log.DefaultLogger.Error("Interval to float error, ", err)
+		}
+
+		var (
+			r       MultivariateResult
+			failure *SeriesFailure
+		)
+		for l1Field := iter.ReadObject(); l1Field != ""; l1Field = iter.ReadObject() {
+			switch l1Field {
+			case "status":
+				code, status, message, messageCn := readStatus(iter)
+				log.DefaultLogger.Info("Multivariate result status", "code", code, "status", status,
+					"message", message)
+				if seriesFailed(status) {
+					failure = &SeriesFailure{Index: i, Code: code, Message: message, MessageCn: messageCn}
+				}
+			case "data":
+				r = readMultivariatePoints(iter, len(dimensions))
+			default:
+				log.DefaultLogger.Info("Case default: ", "key", l1Field, "value", iter.Read())
+			}
+		}
+		frames := NewMultivariateFrames(r, labels, dimensions, interval)
+		if failure != nil {
+			failures = append(failures, *failure)
+			// 失败的联合序列在score frame上说明原因
+			frames[0].AppendNotices(failure.Notice(data.NoticeSeverityError))
+		}
+		result.Frames = append(result.Frames, frames...)
+	}
+	return result, failures
+}
+
+func readMultivariatePoints(iter *jsoniter.Iterator, dimensions int) MultivariateResult {
+	r := MultivariateResult{Contributions: make([][]float64, dimensions)}
+	for iter.ReadArray() {
+		var (
+			ts            time.Time
+			score         float64
+			anomaly       float64
+			contributions = make([]float64, dimensions)
+		)
+		for l1Field := iter.ReadObject(); l1Field != ""; l1Field = iter.ReadObject() {
+			switch l1Field {
+			case "timestamp":
+				ts = time.UnixMilli(iter.ReadInt64())
+			case "score":
+				score = iter.ReadFloat64()
+			case "anomaly":
+				anomaly = iter.ReadFloat64()
+			case "contributions":
+				for j := 0; iter.ReadArray(); j++ {
+					v := iter.ReadFloat64()
+					if j < dimensions {
+						contributions[j] = v
+					}
+				}
+			default:
+				log.DefaultLogger.Info("Multivariate result case default", "key", l1Field, "value", iter.Read())
+			}
+		}
+		r.Timestamps = append(r.Timestamps, ts)
+		r.Score = append(r.Score, score)
+		r.Anomaly = append(r.Anomaly, anomaly)
+		for j := range contributions {
+			r.Contributions[j] = append(r.Contributions[j], contributions[j])
+		}
+	}
+	return r
+}
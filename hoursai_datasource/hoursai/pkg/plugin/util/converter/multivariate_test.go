@@ -0,0 +1,34 @@
+package converter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestNewMultivariateFramesOwnTimeFields(t *testing.T) {
+	r := MultivariateResult{
+		Timestamps:    []time.Time{time.UnixMilli(1000), time.UnixMilli(2000)},
+		Score:         []float64{1, 2},
+		Anomaly:       []float64{0, 1},
+		Contributions: [][]float64{{0.5, 0.9}, {0.5, 0.1}},
+	}
+	frames := NewMultivariateFrames(r, data.Labels{"host": "a"}, []string{"cpu", "mem"}, 60)
+	if len(frames) != 4 {
+		t.Fatalf("got %d frames, want 4", len(frames))
+	}
+	// 修改一个frame的时间不影响其他frame和输入
+	frames[0].Fields[0].Set(0, time.UnixMilli(0))
+	for _, frame := range frames[1:] {
+		if got := frame.Fields[0].At(0).(time.Time); !got.Equal(time.UnixMilli(1000)) {
+			t.Errorf("frame %s time changed to %v", frame.Name, got)
+		}
+	}
+	if !r.Timestamps[0].Equal(time.UnixMilli(1000)) {
+		t.Errorf("input timestamps changed")
+	}
+	if got := frames[3].Fields[1].Labels[DimensionLabel]; got != "mem" {
+		t.Errorf("dimension label = %s, want mem", got)
+	}
+}
@@ -3,6 +3,7 @@ package converter
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
@@ -46,12 +47,14 @@ func ReadPrometheusStyleResult(iter *jsoniter.Iterator, opt Options) *backend.Da
 		}
 	}
 	if status == "error" {
+		queryErr := util.DownstreamError(PrometheusErrorStatus(errorType), fmt.Errorf("%s: %s", errorType, err))
 		return &backend.DataResponse{
-			Error: fmt.Errorf("%s: %s", errorType, err),
+			Error:  queryErr,
+			Status: queryErr.Status,
 		}
 	}
 
-	if len(warnings) > 0 {
+	if len(warnings) > 0 && rsp != nil {
 		for _, frame := range rsp.Frames {
 			if frame.Meta == nil {
 				frame.Meta = &data.FrameMeta{}
@@ -63,6 +66,23 @@ func ReadPrometheusStyleResult(iter *jsoniter.Iterator, opt Options) *backend.Da
 	return rsp
 }
 
+// PrometheusErrorStatus 将prometheus返回的errorType映射为backend.Status
+func PrometheusErrorStatus(errorType string) backend.Status {
+	switch errorType {
+	case "bad_data":
+		return backend.StatusBadRequest
+	case "timeout":
+		return backend.StatusTimeout
+	case "canceled":
+		return util.StatusClientClosedRequest
+	case "not_found":
+		return backend.StatusNotFound
+	default:
+		// execution、internal、unavailable等均为prometheus侧的错误
+		return backend.StatusBadGateway
+	}
+}
+
 func readPrometheusData(iter *jsoniter.Iterator, opt Options) *backend.DataResponse {
 	t := iter.WhatIsNext()
 	if t == jsoniter.ArrayValue {
@@ -0,0 +1,266 @@
+package converter
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// SeverityFieldName anomaly frame中每个点严重级别的字段名，告警frame中为标签名
+const SeverityFieldName = "severity"
+
+// AnomalyRules 异常点的后处理规则，过滤规则为零值时不过滤，过滤后按Severity为保留的异常点分级
+type AnomalyRules struct {
+	// MinConsecutive 连续异常点数少于该值的片段不输出
+	MinConsecutive int64
+	// MinSignificance 显著性低于该值的异常点不输出
+	MinSignificance float64
+	// Cooldown 上一段异常结束后该时长内开始的异常片段不输出
+	Cooldown time.Duration
+	// Direction above只保留高于上界的点，below只保留低于下界的点
+	Direction string
+	// Severity 严重级别阈值，为nil时不分级
+	Severity *models.SeverityTiers
+	// Calendar 维护窗口内的异常点不参与片段和冷却期计算，也不分级，由维护窗口抑制置为0
+	Calendar *models.Calendar
+}
+
+// Enabled 是否配置了任一规则
+func (r AnomalyRules) Enabled() bool {
+	return r.MinConsecutive > 1 || r.MinSignificance > 0 || r.Cooldown > 0 ||
+		(r.Direction != "" && r.Direction != util.DirectionBoth)
+}
+
+// ruleSeries 一条结果序列，按时间戳查找与异常点对应的值
+type ruleSeries struct {
+	times  *data.Field
+	values *data.Field
+}
+
+func (s ruleSeries) lookup() map[int64]float64 {
+	if s.times == nil || s.values == nil {
+		return nil
+	}
+	result := make(map[int64]float64)
+	for i := 0; i < s.times.Len() && i < s.values.Len(); i++ {
+		ts, ok := s.times.ConcreteAt(i)
+		if !ok {
+			continue
+		}
+		if v, err := s.values.NullableFloatAt(i); err == nil && v != nil {
+			result[ts.(time.Time).UnixMilli()] = *v
+		}
+	}
+	return result
+}
+
+// apply 按规则将不满足条件的异常点置为0，返回被过滤的点数；缺少显著性、原始值或上下界时对应规则不生效，
+// 维护窗口内的点视为非异常点，不计入过滤点数
+func (r AnomalyRules) apply(anomaly ruleSeries, related map[string]ruleSeries) int {
+	if !r.Enabled() || anomaly.times == nil || anomaly.values == nil {
+		return 0
+	}
+	significance := related["significance"].lookup()
+	value, upper, lower := related["value"].lookup(), related["upper"].lookup(), related["lower"].lookup()
+
+	n := anomaly.values.Len()
+	times := make([]time.Time, n)
+	candidate := make([]bool, n)
+	maintenance := make([]bool, n)
+	for i := 0; i < n && i < anomaly.times.Len(); i++ {
+		ts, ok := anomaly.times.ConcreteAt(i)
+		if !ok {
+			continue
+		}
+		times[i] = ts.(time.Time)
+		v, err := anomaly.values.NullableFloatAt(i)
+		if err != nil || v == nil || *v == 0 {
+			continue
+		}
+		if r.Calendar.InWindow(times[i]) {
+			maintenance[i] = true
+			continue
+		}
+		candidate[i] = r.keep(times[i].UnixMilli(), significance, value, upper, lower)
+	}
+
+	// 相邻的异常点组成一个片段，片段过短或处于冷却期内时整体过滤
+	var lastEnd time.Time
+	for start := 0; start < n; {
+		if !candidate[start] {
+			start++
+			continue
+		}
+		end := start
+		for end+1 < n && candidate[end+1] {
+			end++
+		}
+		drop := int64(end-start+1) < r.MinConsecutive ||
+			(r.Cooldown > 0 && !lastEnd.IsZero() && times[start].Sub(lastEnd) < r.Cooldown)
+		if drop {
+			for i := start; i <= end; i++ {
+				candidate[i] = false
+			}
+		} else {
+			lastEnd = times[end]
+		}
+		start = end + 1
+	}
+
+	filtered := 0
+	for i := 0; i < n; i++ {
+		v, err := anomaly.values.NullableFloatAt(i)
+		if err != nil || v == nil || *v == 0 || candidate[i] || maintenance[i] {
+			continue
+		}
+		switch anomaly.values.Type() {
+		case data.FieldTypeFloat64:
+			anomaly.values.Set(i, 0.0)
+		case data.FieldTypeNullableFloat64:
+			zero := 0.0
+			anomaly.values.Set(i, &zero)
+		default:
+			continue
+		}
+		filtered++
+	}
+	return filtered
+}
+
+// keep 单点的显著性和方向条件
+func (r AnomalyRules) keep(ts int64, significance, value, upper, lower map[int64]float64) bool {
+	if r.MinSignificance > 0 {
+		if s, ok := significance[ts]; ok && s < r.MinSignificance {
+			return false
+		}
+	}
+	v, ok := value[ts]
+	if !ok {
+		return true
+	}
+	switch r.Direction {
+	case util.DirectionAbove:
+		if u, ok := upper[ts]; ok {
+			return v > u
+		}
+	case util.DirectionBelow:
+		if l, ok := lower[ts]; ok {
+			return v < l
+		}
+	}
+	return true
+}
+
+// applyRealtime 实时结果每种结果是一个frame，frame名为指标名，按场景映射到结果名，
+// 按去掉指标名后的标签找到同一序列的上下界和显著性
+func (r AnomalyRules) applyRealtime(frames data.Frames, scene string) {
+	if !r.Enabled() && r.Severity == nil {
+		return
+	}
+	groups := make(map[string]map[string]*data.Frame)
+	var keys []string
+	for _, frame := range frames {
+		if len(frame.Fields) < 2 {
+			continue
+		}
+		labels := frame.Fields[1].Labels.Copy()
+		delete(labels, "__name__")
+		key := labels.String()
+		if groups[key] == nil {
+			groups[key] = make(map[string]*data.Frame)
+			keys = append(keys, key)
+		}
+		groups[key][realtimeFrameName(frame.Name, scene)] = frame
+	}
+	for _, key := range keys {
+		r.ApplyFrames(groups[key], false)
+	}
+}
+
+// ApplyFrames 按规则过滤同一序列的结果frame并为异常点分级，frames按结果名称索引，原始序列的名称为value，
+// alerting为true时严重级别只作为标签
+func (r AnomalyRules) ApplyFrames(frames map[string]*data.Frame, alerting bool) {
+	anomaly, ok := frames["anomaly"]
+	if !ok || len(anomaly.Fields) < 2 {
+		return
+	}
+	related := make(map[string]ruleSeries, len(frames))
+	for name, frame := range frames {
+		if len(frame.Fields) >= 2 {
+			related[name] = ruleSeries{times: frame.Fields[0], values: frame.Fields[1]}
+		}
+	}
+	series := ruleSeries{times: anomaly.Fields[0], values: anomaly.Fields[1]}
+	filteredMeta(anomaly, r.apply(series, related))
+	withSeverity(anomaly, r.classify(series, related), alerting)
+}
+
+// classify 每个异常点的严重级别，非异常点为空字符串；Severity为nil时返回nil
+func (r AnomalyRules) classify(anomaly ruleSeries, related map[string]ruleSeries) []string {
+	if r.Severity == nil || anomaly.times == nil || anomaly.values == nil {
+		return nil
+	}
+	significance := related["significance"].lookup()
+	value, baseline := related["value"].lookup(), related["baseline"].lookup()
+	severities := make([]string, anomaly.values.Len())
+	for i := range severities {
+		if i >= anomaly.times.Len() {
+			break
+		}
+		v, err := anomaly.values.NullableFloatAt(i)
+		if err != nil || v == nil || *v == 0 {
+			continue
+		}
+		ts, ok := anomaly.times.ConcreteAt(i)
+		if !ok {
+			continue
+		}
+		if r.Calendar.InWindow(ts.(time.Time)) {
+			continue
+		}
+		ms := ts.(time.Time).UnixMilli()
+		var s, d *float64
+		if sv, ok := significance[ms]; ok {
+			s = &sv
+		}
+		vv, okValue := value[ms]
+		bv, okBaseline := baseline[ms]
+		if okValue && okBaseline {
+			d = models.Deviation(vv, bv)
+		}
+		severities[i] = r.Severity.Classify(s, d)
+	}
+	return severities
+}
+
+// withSeverity anomaly frame加入严重级别字段；告警只接受数值字段，告警按最新的点判断，
+// 因此告警frame只在标签中加入最后一个点的级别，最后一个点不是异常点时不加标签
+func withSeverity(frame *data.Frame, severities []string, alerting bool) {
+	if severities == nil {
+		return
+	}
+	if !alerting {
+		frame.Fields = append(frame.Fields, data.NewField(SeverityFieldName, nil, severities))
+		return
+	}
+	if len(severities) == 0 || severities[len(severities)-1] == "" {
+		return
+	}
+	field := frame.Fields[1]
+	labels := field.Labels.Copy()
+	if labels == nil {
+		labels = data.Labels{}
+	}
+	labels[SeverityFieldName] = severities[len(severities)-1]
+	field.Labels = labels
+}
+
+// filteredMeta 在anomaly frame的meta中记录被规则过滤的点数
+func filteredMeta(frame *data.Frame, filtered int) {
+	if filtered > 0 {
+		frame.Meta = WithCustomMeta(frame.Meta, map[string]string{"filteredAnomalies": strconv.Itoa(filtered)})
+	}
+}
@@ -0,0 +1,337 @@
+package converter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/models"
+	"github.com/grafana/grafana-datasource-backend-cloudwise/pkg/plugin/util"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// ruleFrame 每分钟一个点的结果frame
+func ruleFrame(name string, labels data.Labels, values ...float64) *data.Frame {
+	times := make([]time.Time, len(values))
+	for i := range times {
+		times[i] = time.Unix(int64(i)*60, 0)
+	}
+	return data.NewFrame(name, data.NewField("Time", nil, times), data.NewField("Value", labels, values))
+}
+
+func frameSeries(frame *data.Frame) ruleSeries {
+	return ruleSeries{times: frame.Fields[0], values: frame.Fields[1]}
+}
+
+func frameValues(frame *data.Frame) []float64 {
+	values := make([]float64, frame.Fields[1].Len())
+	for i := range values {
+		values[i] = frame.Fields[1].At(i).(float64)
+	}
+	return values
+}
+
+func equalFloats(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAnomalyRulesApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    AnomalyRules
+		anomaly  []float64
+		related  map[string][]float64
+		want     []float64
+		filtered int
+	}{
+		{name: "no rules", rules: AnomalyRules{Direction: util.DirectionBoth},
+			anomaly: []float64{1, 0, 1}, want: []float64{1, 0, 1}},
+		{name: "short segments are dropped", rules: AnomalyRules{MinConsecutive: 2},
+			anomaly: []float64{1, 0, 1, 1, 0, 1}, want: []float64{0, 0, 1, 1, 0, 0}, filtered: 2},
+		{name: "segment of exactly the minimum length is kept", rules: AnomalyRules{MinConsecutive: 3},
+			anomaly: []float64{1, 1, 1, 0}, want: []float64{1, 1, 1, 0}},
+		{name: "low significance", rules: AnomalyRules{MinSignificance: 0.5},
+			anomaly: []float64{1, 1, 1}, related: map[string][]float64{"significance": {0.9, 0.1, 0.5}},
+			want: []float64{1, 0, 1}, filtered: 1},
+		{name: "missing significance keeps the points", rules: AnomalyRules{MinSignificance: 0.5},
+			anomaly: []float64{1, 1}, want: []float64{1, 1}},
+		{name: "low significance splits a segment", rules: AnomalyRules{MinSignificance: 0.5, MinConsecutive: 2},
+			anomaly: []float64{1, 1, 1, 1}, related: map[string][]float64{"significance": {0.9, 0.9, 0.1, 0.9}},
+			want: []float64{1, 1, 0, 0}, filtered: 2},
+		{name: "segments inside the cooldown are dropped", rules: AnomalyRules{Cooldown: 3 * time.Minute},
+			anomaly: []float64{1, 0, 1, 0, 0, 0, 1}, want: []float64{1, 0, 0, 0, 0, 0, 1}, filtered: 1},
+		{name: "cooldown starts at the end of a segment", rules: AnomalyRules{Cooldown: 3 * time.Minute},
+			anomaly: []float64{1, 1, 1, 0, 1}, want: []float64{1, 1, 1, 0, 0}, filtered: 1},
+		{name: "above", rules: AnomalyRules{Direction: util.DirectionAbove}, anomaly: []float64{1, 1, 1},
+			related: map[string][]float64{"value": {5, 1, 3}, "upper": {3, 3, 3}, "lower": {0, 2, 0}},
+			want:    []float64{1, 0, 0}, filtered: 2},
+		{name: "below", rules: AnomalyRules{Direction: util.DirectionBelow}, anomaly: []float64{1, 1, 1},
+			related: map[string][]float64{"value": {5, 1, 3}, "upper": {3, 3, 3}, "lower": {0, 2, 0}},
+			want:    []float64{0, 1, 0}, filtered: 2},
+		{name: "missing bounds keep the points", rules: AnomalyRules{Direction: util.DirectionAbove},
+			anomaly: []float64{1, 1}, related: map[string][]float64{"value": {5, 1}, "lower": {0, 2}},
+			want: []float64{1, 1}},
+		{name: "missing value keeps the points", rules: AnomalyRules{Direction: util.DirectionBelow},
+			anomaly: []float64{1, 1}, related: map[string][]float64{"upper": {3, 3}, "lower": {0, 2}},
+			want: []float64{1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			anomaly := ruleFrame("anomaly", nil, tt.anomaly...)
+			related := make(map[string]ruleSeries)
+			for name, values := range tt.related {
+				related[name] = frameSeries(ruleFrame(name, nil, values...))
+			}
+			filtered := tt.rules.apply(frameSeries(anomaly), related)
+			if got := frameValues(anomaly); !equalFloats(got, tt.want) {
+				t.Errorf("anomaly = %v, want %v", got, tt.want)
+			}
+			if filtered != tt.filtered {
+				t.Errorf("filtered %d points, want %d", filtered, tt.filtered)
+			}
+		})
+	}
+}
+
+func TestAnomalyRulesApplyNullable(t *testing.T) {
+	one := 1.0
+	anomaly := data.NewFrame("anomaly",
+		data.NewField("Time", nil, []time.Time{time.Unix(0, 0), time.Unix(60, 0), time.Unix(120, 0)}),
+		data.NewField("Value", nil, []*float64{&one, nil, &one}))
+	filtered := AnomalyRules{MinConsecutive: 2}.apply(frameSeries(anomaly), nil)
+	if filtered != 2 {
+		t.Errorf("filtered %d points, want 2", filtered)
+	}
+	for i := 0; i < 3; i++ {
+		if v := anomaly.Fields[1].At(i).(*float64); v != nil && *v != 0 {
+			t.Errorf("point %d = %v, want 0 or null", i, *v)
+		}
+	}
+}
+
+func TestAnomalyRulesApplyMaintenance(t *testing.T) {
+	// 维护窗口覆盖第1个点
+	calendar := &models.Calendar{Windows: []models.Window{{Start: 60000, End: 120000}}}
+	tests := []struct {
+		name     string
+		rules    AnomalyRules
+		anomaly  []float64
+		want     []float64
+		filtered int
+	}{
+		{name: "maintenance points split segments and are left for suppression",
+			rules:   AnomalyRules{MinConsecutive: 2, Calendar: calendar},
+			anomaly: []float64{1, 1, 1}, want: []float64{0, 1, 0}, filtered: 2},
+		{name: "maintenance points do not start a cooldown",
+			rules:   AnomalyRules{Cooldown: 5 * time.Minute, Calendar: calendar},
+			anomaly: []float64{0, 1, 0, 1}, want: []float64{0, 1, 0, 1}},
+		{name: "without calendar the first segment starts the cooldown",
+			rules:   AnomalyRules{Cooldown: 5 * time.Minute},
+			anomaly: []float64{0, 1, 0, 1}, want: []float64{0, 1, 0, 0}, filtered: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			anomaly := ruleFrame("anomaly", nil, tt.anomaly...)
+			filtered := tt.rules.apply(frameSeries(anomaly), nil)
+			if got := frameValues(anomaly); !equalFloats(got, tt.want) {
+				t.Errorf("anomaly = %v, want %v", got, tt.want)
+			}
+			if filtered != tt.filtered {
+				t.Errorf("filtered %d points, want %d", filtered, tt.filtered)
+			}
+		})
+	}
+}
+
+func TestAnomalyRulesKeep(t *testing.T) {
+	series := func(v float64) map[int64]float64 { return map[int64]float64{0: v} }
+	tests := []struct {
+		name         string
+		rules        AnomalyRules
+		significance map[int64]float64
+		value        map[int64]float64
+		upper        map[int64]float64
+		lower        map[int64]float64
+		want         bool
+	}{
+		{name: "no rules", want: true},
+		{name: "significance below minimum", rules: AnomalyRules{MinSignificance: 0.5},
+			significance: series(0.4)},
+		{name: "significance at minimum", rules: AnomalyRules{MinSignificance: 0.5},
+			significance: series(0.5), want: true},
+		{name: "missing significance", rules: AnomalyRules{MinSignificance: 0.5}, want: true},
+		{name: "above upper", rules: AnomalyRules{Direction: util.DirectionAbove},
+			value: series(5), upper: series(3), want: true},
+		{name: "not above upper", rules: AnomalyRules{Direction: util.DirectionAbove},
+			value: series(3), upper: series(3)},
+		{name: "below lower", rules: AnomalyRules{Direction: util.DirectionBelow},
+			value: series(1), lower: series(2), want: true},
+		{name: "not below lower", rules: AnomalyRules{Direction: util.DirectionBelow},
+			value: series(5), lower: series(2)},
+		{name: "both directions", rules: AnomalyRules{Direction: util.DirectionBoth},
+			value: series(3), upper: series(5), lower: series(1), want: true},
+		{name: "missing upper", rules: AnomalyRules{Direction: util.DirectionAbove},
+			value: series(1), lower: series(2), want: true},
+		{name: "missing value", rules: AnomalyRules{Direction: util.DirectionAbove},
+			upper: series(3), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rules.keep(0, tt.significance, tt.value, tt.upper, tt.lower); got != tt.want {
+				t.Errorf("keep = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnomalyRulesApplyRealtime(t *testing.T) {
+	// 实时结果的frame以指标名命名，按场景映射到结果名后再应用规则
+	realtimeFrame := func(name, host string, values ...float64) *data.Frame {
+		metric := name + "_task_1"
+		return ruleFrame(metric, data.Labels{"__name__": metric, "host": host}, values...)
+	}
+	frames := data.Frames{
+		realtimeFrame("significance", "a", 0.9, 0.1),
+		realtimeFrame("upper", "a", 3, 3),
+		realtimeFrame("anomaly", "a", 1, 1),
+		// host b没有显著性，显著性规则不生效
+		realtimeFrame("upper", "b", 3, 3),
+		realtimeFrame("anomaly", "b", 1, 1),
+	}
+	AnomalyRules{MinSignificance: 0.5}.applyRealtime(frames, util.SceneAnomalyDetection)
+	if got := frameValues(frames[2]); !equalFloats(got, []float64{1, 0}) {
+		t.Errorf("host a anomaly = %v, want [1 0]", got)
+	}
+	if frames[2].Meta == nil || frames[2].Meta.Custom.(map[string]string)["filteredAnomalies"] != "1" {
+		t.Error("filtered points are not recorded in the frame meta")
+	}
+	if got := frameValues(frames[4]); !equalFloats(got, []float64{1, 1}) {
+		t.Errorf("host b anomaly = %v, want [1 1]", got)
+	}
+	if frames[4].Meta != nil {
+		t.Error("no points of host b should be filtered")
+	}
+	for _, frame := range frames {
+		if frame.Name != frame.Fields[1].Labels["__name__"] {
+			t.Errorf("frame %s was renamed", frame.Fields[1].Labels["__name__"])
+		}
+	}
+}
+
+func TestResultName(t *testing.T) {
+	for name, want := range map[string]string{
+		"anomaly":              "anomaly",
+		"anomaly_task_1":       "anomaly",
+		"significance_task_12": "significance",
+		"changePoint_task_1":   "changePoint",
+		"anomalyScore":         "anomalyScore",
+		"custom_task_1":        "custom_task_1",
+	} {
+		if got := ResultName(name); got != want {
+			t.Errorf("ResultName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestAnomalyRulesApplyFrames(t *testing.T) {
+	tiers := &models.SeverityTiers{
+		Warning:  models.SeverityThreshold{Significance: 0.8},
+		Critical: models.SeverityThreshold{Deviation: 1},
+	}
+	tests := []struct {
+		name     string
+		rules    AnomalyRules
+		anomaly  []float64
+		alerting bool
+		want     []string
+		label    string
+	}{
+		{name: "no tiers", anomaly: []float64{0, 1, 1, 1}},
+		{name: "severity field", rules: AnomalyRules{Severity: tiers}, anomaly: []float64{0, 1, 1, 1},
+			want: []string{"", "info", "warning", "critical"}},
+		{name: "filtered points are not classified", rules: AnomalyRules{Severity: tiers, MinSignificance: 0.5},
+			anomaly: []float64{0, 1, 1, 1}, want: []string{"", "", "warning", "critical"}},
+		{name: "points in maintenance windows are not classified",
+			rules: AnomalyRules{Severity: tiers, Calendar: &models.Calendar{
+				Windows: []models.Window{{Start: 180000, End: 240000}}}},
+			anomaly: []float64{0, 1, 1, 1}, want: []string{"", "info", "warning", ""}},
+		{name: "alert label from the latest point", rules: AnomalyRules{Severity: tiers},
+			anomaly: []float64{0, 1, 1, 1}, alerting: true, label: "critical"},
+		{name: "no alert label when the latest point is normal", rules: AnomalyRules{Severity: tiers},
+			anomaly: []float64{0, 1, 1, 0}, alerting: true},
+		{name: "no alert label without tiers", anomaly: []float64{0, 1, 1, 1}, alerting: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := data.Labels{"host": "a"}
+			frames := map[string]*data.Frame{
+				"anomaly":      ruleFrame("anomaly", labels, tt.anomaly...),
+				"significance": ruleFrame("significance", labels, 0, 0.1, 0.9, 0.9),
+				"value":        ruleFrame("value", labels, 1, 1, 1, 5),
+				"baseline":     ruleFrame("baseline", labels, 1, 1, 1, 2),
+			}
+			tt.rules.ApplyFrames(frames, tt.alerting)
+			anomaly := frames["anomaly"]
+			field, _ := anomaly.FieldByName(SeverityFieldName)
+			if tt.want == nil {
+				if field != nil {
+					t.Error("unexpected severity field")
+				}
+			} else {
+				if field == nil {
+					t.Fatal("severity field is missing")
+				}
+				for i, want := range tt.want {
+					if got := field.At(i).(string); got != want {
+						t.Errorf("point %d severity = %q, want %q", i, got, want)
+					}
+				}
+			}
+			if got := anomaly.Fields[1].Labels[SeverityFieldName]; got != tt.label {
+				t.Errorf("severity label = %q, want %q", got, tt.label)
+			}
+		})
+	}
+}
+
+func TestWithSeverity(t *testing.T) {
+	tests := []struct {
+		name       string
+		severities []string
+		alerting   bool
+		fields     int
+		label      string
+	}{
+		{name: "not classified", fields: 2},
+		{name: "severity field", severities: []string{"critical", ""}, fields: 3},
+		{name: "latest point", severities: []string{"critical", "info"}, alerting: true, fields: 2, label: "info"},
+		{name: "latest point normal", severities: []string{"critical", ""}, alerting: true, fields: 2},
+		{name: "empty series", severities: []string{}, alerting: true, fields: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := data.Labels{"host": "a"}
+			frame := ruleFrame("anomaly", labels, 0, 0)
+			withSeverity(frame, tt.severities, tt.alerting)
+			if len(frame.Fields) != tt.fields {
+				t.Fatalf("got %d fields, want %d", len(frame.Fields), tt.fields)
+			}
+			if got := frame.Fields[1].Labels[SeverityFieldName]; got != tt.label {
+				t.Errorf("severity label = %q, want %q", got, tt.label)
+			}
+			if frame.Fields[1].Labels["host"] != "a" {
+				t.Error("other labels must be kept")
+			}
+			if _, ok := labels[SeverityFieldName]; ok {
+				t.Error("labels shared with other frames must not be changed")
+			}
+		})
+	}
+}
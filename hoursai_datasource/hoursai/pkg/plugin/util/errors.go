@@ -0,0 +1,171 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const (
+	// ErrorSourcePlugin 插件自身的错误，如配置错误、请求参数错误
+	ErrorSourcePlugin = "plugin"
+	// ErrorSourceDownstream Prometheus或HoursAI manager返回的错误
+	ErrorSourceDownstream = "downstream"
+
+	// LocaleZh 数据源配置locale为zh时错误信息优先使用中文
+	LocaleZh = "zh"
+)
+
+// StatusClientClosedRequest 调用方取消请求，沿用nginx的499约定
+const StatusClientClosedRequest backend.Status = 499
+
+// QueryError 带有来源、状态码和中英文信息的错误
+type QueryError struct {
+	Source    string
+	Status    backend.Status
+	Message   string
+	MessageCn string
+	Err       error
+}
+
+func (e *QueryError) Error() string {
+	if e.Message == "" && e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// PluginError 插件自身的错误，status为0时按错误类型推断
+func PluginError(status backend.Status, err error) *QueryError {
+	return newQueryError(ErrorSourcePlugin, status, err)
+}
+
+// DownstreamError 下游服务的错误，status为0时按错误类型推断
+func DownstreamError(status backend.Status, err error) *QueryError {
+	return newQueryError(ErrorSourceDownstream, status, err)
+}
+
+func newQueryError(source string, status backend.Status, err error) *QueryError {
+	var qe *QueryError
+	if errors.As(err, &qe) {
+		if err == error(qe) {
+			return qe
+		}
+		// 被包装过的错误保留外层的上下文信息
+		return &QueryError{Source: qe.Source, Status: qe.Status, Message: err.Error(), MessageCn: qe.MessageCn,
+			Err: err}
+	}
+	if status == 0 {
+		status = StatusFromError(err)
+	}
+	return &QueryError{Source: source, Status: status, Message: err.Error(), Err: err}
+}
+
+// WithMessageCn 附加中文错误信息
+func (e *QueryError) WithMessageCn(messageCn string) *QueryError {
+	e.MessageCn = messageCn
+	return e
+}
+
+// StatusFromHTTP 将下游返回的HTTP状态码映射为backend.Status，非HTTP错误码按下游错误处理
+func StatusFromHTTP(code int) backend.Status {
+	switch {
+	case code == http.StatusBadRequest || code == http.StatusUnprocessableEntity:
+		return backend.StatusBadRequest
+	case code == http.StatusUnauthorized:
+		return backend.StatusUnauthorized
+	case code == http.StatusForbidden:
+		return backend.StatusForbidden
+	case code == http.StatusNotFound:
+		return backend.StatusNotFound
+	case code == http.StatusTooManyRequests:
+		return backend.StatusTooManyRequests
+	case code == http.StatusRequestTimeout || code == http.StatusGatewayTimeout:
+		return backend.StatusTimeout
+	case code == int(StatusClientClosedRequest):
+		return StatusClientClosedRequest
+	case code == http.StatusNotImplemented:
+		return backend.StatusNotImplemented
+	case code >= 400 && code < 500:
+		return backend.StatusBadRequest
+	default:
+		return backend.StatusBadGateway
+	}
+}
+
+// StatusFromError 推断错误对应的backend.Status，超时和取消优先于其它错误
+func StatusFromError(err error) backend.Status {
+	if err == nil {
+		return backend.StatusOK
+	}
+	var qe *QueryError
+	if errors.As(err, &qe) {
+		return qe.Status
+	}
+	if errors.Is(err, context.Canceled) {
+		return StatusClientClosedRequest
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return backend.StatusTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return backend.StatusTimeout
+		}
+		return backend.StatusBadGateway
+	}
+	return backend.StatusInternal
+}
+
+// ErrorSource 错误来源，未标记的错误视为插件错误
+func ErrorSource(err error) string {
+	var qe *QueryError
+	if errors.As(err, &qe) {
+		return qe.Source
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrorSourceDownstream
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrorSourceDownstream
+	}
+	return ErrorSourcePlugin
+}
+
+// ErrorResponse 将错误转换为查询结果，locale为zh且有中文信息时使用中文信息
+func ErrorResponse(err error, locale string) backend.DataResponse {
+	message := err.Error()
+	var qe *QueryError
+	if errors.As(err, &qe) && qe.MessageCn != "" && strings.HasPrefix(strings.ToLower(locale), LocaleZh) {
+		message = qe.MessageCn
+	}
+	return backend.DataResponse{
+		Status: StatusFromError(err),
+		Error:  errors.New(message),
+	}
+}
+
+// ErrorBody 资源接口的错误响应体，同时包含中英文信息
+func ErrorBody(err error) map[string]interface{} {
+	body := map[string]interface{}{
+		"status":  "error",
+		"code":    int(StatusFromError(err)),
+		"source":  ErrorSource(err),
+		"message": err.Error(),
+	}
+	var qe *QueryError
+	if errors.As(err, &qe) && qe.MessageCn != "" {
+		body["messageCn"] = qe.MessageCn
+	}
+	return body
+}
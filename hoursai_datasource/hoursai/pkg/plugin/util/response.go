@@ -0,0 +1,83 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// bodyPreviewLength 错误信息中展示的响应体长度
+const bodyPreviewLength = 256
+
+// ReadJSONBody 读取完整的响应体，响应体为空、Content-Type声明为非JSON类型或内容不是JSON（如代理返回的HTML错误页）时
+// 返回带有响应体开头内容的错误，未设置Content-Type时只检查内容
+func ReadJSONBody(res *http.Response, source string) ([]byte, error) {
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, DownstreamError(responseStatus(res.StatusCode),
+			fmt.Errorf("%s response (HTTP %d) could not be read: %w; body starts with: %q", source, res.StatusCode,
+				err, BodyPreview(body)))
+	}
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil, DownstreamError(responseStatus(res.StatusCode),
+			fmt.Errorf("%s returned an empty response (HTTP %d)", source, res.StatusCode))
+	}
+	contentType := res.Header.Get("Content-Type")
+	if (contentType != "" && !isJSONContentType(contentType)) || (trimmed[0] != '{' && trimmed[0] != '[') {
+		if contentType == "" {
+			contentType = "unknown"
+		}
+		return nil, DownstreamError(responseStatus(res.StatusCode),
+			fmt.Errorf("%s returned a non-JSON response (HTTP %d, Content-Type %s); body starts with: %q", source,
+				res.StatusCode, contentType, BodyPreview(body)))
+	}
+	return body, nil
+}
+
+// CheckIterator 解析结束后检查jsoniter的错误，截断或格式错误的响应不能当作正常结果使用
+func CheckIterator(iter *jsoniter.Iterator, body []byte, source string) error {
+	if iter.Error == nil || iter.Error == io.EOF {
+		return nil
+	}
+	return DownstreamError(backend.StatusBadGateway,
+		fmt.Errorf("%s returned an invalid or truncated JSON response: %v; body starts with: %q", source,
+			iter.Error, BodyPreview(body)))
+}
+
+// BodyPreview 响应体的开头部分，截断时不拆分多字节字符
+func BodyPreview(body []byte) string {
+	preview := string(bytes.TrimSpace(body))
+	if len(preview) <= bodyPreviewLength {
+		return preview
+	}
+	preview = preview[:bodyPreviewLength]
+	for len(preview) > 0 && !utf8.ValidString(preview) {
+		preview = preview[:len(preview)-1]
+	}
+	return strings.TrimSpace(preview) + "..."
+}
+
+// isJSONContentType application/json以及application/problem+json等JSON类型，未显式设置类型的服务默认返回text/plain，
+// 这种情况只按内容判断
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/plain" || strings.HasSuffix(mediaType, "+json")
+}
+
+func responseStatus(code int) backend.Status {
+	if code >= http.StatusBadRequest {
+		return StatusFromHTTP(code)
+	}
+	return backend.StatusBadGateway
+}
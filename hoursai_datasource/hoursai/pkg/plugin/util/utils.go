@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 func GetJsonData(settings backend.DataSourceInstanceSettings) (map[string]interface{}, error) {
@@ -36,3 +38,131 @@ func SdkHeaderToHttpHeader(headers map[string]string) http.Header {
 	}
 	return httpHeader
 }
+
+// forwardedHeaders grafana转发给数据源的认证相关请求头
+var forwardedHeaders = []string{"Authorization", "X-Id-Token", "Cookie"}
+
+// ResourceHeaders 从资源请求的请求头中取出需要转发给prometheus的认证信息，与查询请求转发的请求头一致
+func ResourceHeaders(headers map[string][]string) map[string]string {
+	result := make(map[string]string)
+	for key, values := range headers {
+		if len(values) == 0 {
+			continue
+		}
+		for _, name := range forwardedHeaders {
+			if strings.EqualFold(key, name) {
+				result[name] = values[0]
+			}
+		}
+	}
+	return result
+}
+
+// GetStringList 读取字符串数组配置，也支持逗号分隔的字符串
+func GetStringList(jsonData map[string]interface{}, key string) ([]string, error) {
+	var items []string
+	switch value := jsonData[key].(type) {
+	case nil:
+	case string:
+		items = strings.Split(value, ",")
+	case []interface{}:
+		for _, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("the field '%s' should be a list of strings", key)
+			}
+			items = append(items, s)
+		}
+	default:
+		return nil, fmt.Errorf("the field '%s' should be a list of strings", key)
+	}
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+func GetInt64Optional(obj map[string]interface{}, key string) (int64, error) {
+	untypedValue, ok := obj[key]
+	if !ok {
+		return 0, nil
+	}
+	switch value := untypedValue.(type) {
+	case float64:
+		return int64(value), nil
+	case string:
+		if value == "" {
+			return 0, nil
+		}
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("the field '%s' should be an integer", key)
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("the field '%s' should be an integer", key)
+	}
+}
+
+func GetFloat64Optional(obj map[string]interface{}, key string) (float64, error) {
+	untypedValue, ok := obj[key]
+	if !ok {
+		return 0, nil
+	}
+	switch value := untypedValue.(type) {
+	case float64:
+		return value, nil
+	case string:
+		if value == "" {
+			return 0, nil
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("the field '%s' should be a number", key)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("the field '%s' should be a number", key)
+	}
+}
+
+// JsonDataToStringMap 只保留JsonData中的字符串字段，数值等其它类型的配置项不影响manager相关配置的解析
+func JsonDataToStringMap(raw json.RawMessage) (map[string]string, error) {
+	var jsonData map[string]interface{}
+	if err := json.Unmarshal(raw, &jsonData); err != nil {
+		return nil, fmt.Errorf("error unmarshalling JSONData: %w", err)
+	}
+	jsonMap := make(map[string]string, len(jsonData))
+	for key, val := range jsonData {
+		if s, ok := val.(string); ok {
+			jsonMap[key] = s
+		}
+	}
+	return jsonMap, nil
+}
+
+// GetBoolOptional 读取布尔配置，也支持"true"、"false"字符串
+func GetBoolOptional(obj map[string]interface{}, key string) (bool, error) {
+	untypedValue, ok := obj[key]
+	if !ok {
+		return false, nil
+	}
+	switch value := untypedValue.(type) {
+	case bool:
+		return value, nil
+	case string:
+		if value == "" {
+			return false, nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, fmt.Errorf("the field '%s' should be a boolean", key)
+		}
+		return b, nil
+	default:
+		return false, fmt.Errorf("the field '%s' should be a boolean", key)
+	}
+}